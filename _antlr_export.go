@@ -73,3 +73,67 @@ func (atn *ATN) GetRuleIndexToStartStateSlice() []*RuleStartState {
 func (atn *ATN) GetRuleIndexToStopStateSlice() []*RuleStopState {
 	return atn.ruleToStopState
 }
+
+func (atn *ATN) GetRuleToTokenType() []int {
+	return atn.ruleToTokenType
+}
+
+func (atn *ATN) GetModeToStartState() []*TokensStartState {
+	return atn.modeToStartState
+}
+
+func (atn *ATN) GetLexerActions() []LexerAction {
+	return atn.lexerActions
+}
+
+func (t *ActionTransition) GetActionIndex() int {
+	return t.actionIndex
+}
+
+func (l *LexerTypeAction) GetType() int {
+	return l.thetype
+}
+
+func (l *LexerChannelAction) GetChannel() int {
+	return l.channel
+}
+
+func (l *LexerModeAction) GetMode() int {
+	return l.mode
+}
+
+func (l *LexerPushModeAction) GetMode() int {
+	return l.mode
+}
+
+func (s *IntervalSet) AddRange(l, h int) {
+	s.addRange(l, h)
+}
+
+func (t *RuleTransition) GetPrecedence() int {
+	return t.precedence
+}
+
+func (t *PrecedencePredicateTransition) GetPrecedence() int {
+	return t.precedence
+}
+
+func (t *PredicateTransition) GetRuleIndex() int {
+	return t.ruleIndex
+}
+
+func (t *PredicateTransition) GetPredIndex() int {
+	return t.predIndex
+}
+
+func (t *ActionTransition) GetRuleIndex() int {
+	return t.ruleIndex
+}
+
+func (l *LexerCustomAction) GetRuleIndex() int {
+	return l.ruleIndex
+}
+
+func (l *LexerCustomAction) GetActionIndex() int {
+	return l.actionIndex
+}
@@ -0,0 +1,139 @@
+package atnwalk
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+// DefaultAlphabet are the characters that lexer wildcards generate unless ATNWalker.SetAlphabet sets others, i.e.,
+// the printable ASCII characters. Encode and Decode must use the same alphabet.
+var DefaultAlphabet = mustParseAlphabet(` -~`)
+
+// ParseAlphabet parses a set of characters in the notation of ANTLR lexer sets without the brackets, e.g., `a-z0-9_`
+// or ` -~\t\n`. The escape sequences \n, \r, \t, \b, \f, \\, \-, \], \uXXXX, and \u{X...} are supported.
+func ParseAlphabet(spec string) (*antlr.IntervalSet, error) {
+	runes := []rune(spec)
+	alphabet := antlr.NewIntervalSet()
+	next := func(i int) (rune, int, error) {
+		if runes[i] != '\\' {
+			return runes[i], i + 1, nil
+		}
+		if i+1 >= len(runes) {
+			return 0, 0, fmt.Errorf("alphabet %q ends with an incomplete escape sequence", spec)
+		}
+		switch runes[i+1] {
+		case 'n':
+			return '\n', i + 2, nil
+		case 'r':
+			return '\r', i + 2, nil
+		case 't':
+			return '\t', i + 2, nil
+		case 'b':
+			return '\b', i + 2, nil
+		case 'f':
+			return '\f', i + 2, nil
+		case '\\', '-', ']':
+			return runes[i+1], i + 2, nil
+		case 'u':
+			digits, end := "", i+2
+			if end < len(runes) && runes[end] == '{' {
+				closing := end + 1
+				for closing < len(runes) && runes[closing] != '}' {
+					closing++
+				}
+				if closing >= len(runes) {
+					return 0, 0, fmt.Errorf("alphabet %q has an unterminated \\u{...} escape sequence", spec)
+				}
+				digits, end = string(runes[end+1:closing]), closing+1
+			} else if end+4 <= len(runes) {
+				digits, end = string(runes[end:end+4]), end+4
+			}
+			value, err := strconv.ParseUint(digits, 16, 32)
+			if err != nil || value > antlr.LexerMaxCharValue {
+				return 0, 0, fmt.Errorf("alphabet %q has an invalid \\u escape sequence", spec)
+			}
+			return rune(value), end, nil
+		}
+		return 0, 0, fmt.Errorf("alphabet %q has an unknown escape sequence \\%c", spec, runes[i+1])
+	}
+	for i := 0; i < len(runes); {
+		start, end, err := next(i)
+		if err != nil {
+			return nil, err
+		}
+		stop := start
+		if end+1 < len(runes) && runes[end] == '-' {
+			if stop, end, err = next(end + 1); err != nil {
+				return nil, err
+			}
+			if stop < start {
+				return nil, fmt.Errorf("alphabet %q has the empty range %c-%c", spec, start, stop)
+			}
+		}
+		alphabet.AddRange(int(start), int(stop))
+		i = end
+	}
+	if alphabet.Length() == 0 {
+		return nil, fmt.Errorf("the alphabet is empty")
+	}
+	return alphabet, nil
+}
+
+func mustParseAlphabet(spec string) *antlr.IntervalSet {
+	alphabet, err := ParseAlphabet(spec)
+	if err != nil {
+		panic(err)
+	}
+	return alphabet
+}
+
+// SetAlphabet sets the characters that lexer wildcards generate, nil is the DefaultAlphabet. Characters that a wildcard
+// matched during encoding but that are not in the alphabet are encoded as the first character of the alphabet.
+func (w *ATNWalker) SetAlphabet(alphabet *antlr.IntervalSet) {
+	w.alphabet = alphabet
+}
+
+func (w *ATNWalker) wildcardAlphabet() *antlr.IntervalSet {
+	if w.alphabet == nil {
+		return DefaultAlphabet
+	}
+	return w.alphabet
+}
+
+// wildcardTokens returns the token types that a parser wildcard produces, i.e., all token types with a lexer rule.
+func (w *ATNWalker) wildcardTokens() *antlr.IntervalSet {
+	model := w.lexerModel()
+	model.mutex.Lock()
+	defer model.mutex.Unlock()
+	if model.wildcardTokens == nil {
+		model.wildcardTokens = antlr.NewIntervalSet()
+		for tokenType := range model.tokenRules {
+			model.wildcardTokens.AddRange(tokenType, tokenType)
+		}
+	}
+	return model.wildcardTokens
+}
+
+// tokenSet returns the token types of a parser transition that matches a set of tokens, a wildcard matches all token
+// types with a lexer rule (see wildcardTokens) and a NotSetTransition all of them except those of its label.
+func (w *ATNWalker) tokenSet(transition antlr.Transition) *antlr.IntervalSet {
+	switch t := transition.(type) {
+	case *antlr.NotSetTransition:
+		tokens := antlr.NewIntervalSet()
+		for i := 0; i < w.wildcardTokens().Length(); i++ {
+			if tokenType := w.wildcardTokens().Get(i); !t.GetLabel().Contains(tokenType) {
+				tokens.AddRange(tokenType, tokenType)
+			}
+		}
+		return tokens
+	case *antlr.WildcardTransition:
+		return w.wildcardTokens()
+	case *antlr.SetTransition:
+		return t.GetLabel()
+	case *antlr.RangeTransition:
+		return t.GetLabel()
+	}
+	panic("transition does not match a set of tokens")
+}
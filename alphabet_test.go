@@ -0,0 +1,120 @@
+package atnwalk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+func TestParseAlphabet(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{"a-c", "abc", false},
+		{` -~`, "", false},
+		{`x\-\]\\`, `x-]\`, false},
+		{`\t\n`, "\t\n", false},
+		{`ä\u{1F600}`, "ä😀", false},
+		{"ab-", "ab-", false},
+		{"c-a", "", true},
+		{`\q`, "", true},
+		{`\u{1F600`, "", true},
+		{`\`, "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseAlphabet(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAlphabet() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr || tt.want == "" {
+				return
+			}
+			if got.Length() != len([]rune(tt.want)) {
+				t.Errorf("ParseAlphabet() has %d characters, want %d", got.Length(), len([]rune(tt.want)))
+			}
+			for _, r := range tt.want {
+				if !got.Contains(int(r)) {
+					t.Errorf("ParseAlphabet() does not contain %q", r)
+				}
+			}
+		})
+	}
+	if DefaultAlphabet.Length() != 95 || !DefaultAlphabet.Contains(' ') || !DefaultAlphabet.Contains('~') {
+		t.Errorf("DefaultAlphabet is not the printable ASCII characters")
+	}
+}
+
+// newWildcardGrammar returns the parser and the lexer of the grammar:
+//
+//	s : . | ~ANY | ANY..NAME ;
+//	ANY : '#' . ;
+//	NAME : [x-z] ;
+func newWildcardGrammar() (*testParser, *testLexer) {
+	const ANY, NAME = 1, 2
+	wildcard := step{antlr.TransitionWILDCARD, 0, 0, 0}
+
+	lexerBuilder := &atnBuilder{grammarType: antlr.ATNTypeLexer, maxTokenType: NAME}
+	anyStart, anyStop := lexerBuilder.rule(ANY)
+	nameStart, nameStop := lexerBuilder.rule(NAME)
+	lexerBuilder.alt(0, anyStart, anyStop, atom('#'), wildcard)
+	lexerBuilder.alt(1, nameStart, nameStop, charRange('x', 'z'))
+	lexer := &testLexer{antlr.NewBaseLexer(nil), lexerBuilder.build()}
+	lexer.RuleNames = []string{"ANY", "NAME"}
+
+	parserBuilder := &atnBuilder{grammarType: antlr.ATNTypeParser, maxTokenType: NAME}
+	sStart, sStop := parserBuilder.rule(0)
+	parserBuilder.alt(0, sStart, sStop, wildcard)
+	parserBuilder.alt(0, sStart, sStop, step{antlr.TransitionNOTSET, parserBuilder.set(ANY), 0, 0})
+	parserBuilder.alt(0, sStart, sStop, step{antlr.TransitionRANGE, ANY, NAME, 0})
+	parser := &testParser{antlr.NewBaseParser(nil), parserBuilder.build()}
+	parser.RuleNames = []string{"s"}
+	return parser, lexer
+}
+
+func TestATNWalker_Wildcard(t *testing.T) {
+	parser, lexer := newWildcardGrammar()
+	alphabet, err := ParseAlphabet("ab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newWalker := func() *ATNWalker {
+		walker := NewATNWalker(parser, lexer)
+		walker.SetAlphabet(alphabet)
+		return walker
+	}
+
+	outputs := decodeRandom(t, newWalker, 500)
+	for _, want := range []string{"#a", "#b", "x", "y", "z"} {
+		if _, ok := outputs[want]; !ok {
+			t.Errorf("Decode() never produced %q", want)
+		}
+		delete(outputs, want)
+	}
+	for output := range outputs {
+		t.Errorf("Decode() produced %q which is not in the language or the alphabet", output)
+	}
+
+	for data := byte(0); data < 32; data++ {
+		walker := newWalker()
+		walker.SetDeadline(time.Now().Add(time.Second))
+		text, tree := decodeTree(walker, []byte{data, ^data})
+		if decoded := newWalker().Decode(newWalker().Encode(tree), nil); decoded != text {
+			t.Errorf("Decode(Encode(%q)) = %q", text, decoded)
+		}
+	}
+
+	// characters outside of the alphabet still match the wildcard but cannot be generated
+	tree := antlr.NewBaseParserRuleContext(nil, -1)
+	tree.RuleIndex = 0
+	token := antlr.NewCommonToken(&antlr.TokenSourceCharStreamPair{}, 1, antlr.TokenDefaultChannel, -1, -1)
+	token.SetText("#€")
+	tree.AddTokenNode(token)
+	if decoded := newWalker().Decode(newWalker().Encode(tree), nil); decoded != "#a" {
+		t.Errorf("Decode(Encode(\"#€\")) = %q, want \"#a\"", decoded)
+	}
+}
@@ -2,7 +2,7 @@ package atnwalk
 
 import (
 	"fmt"
-	"runtime"
+	"reflect"
 	"strings"
 	"time"
 
@@ -18,6 +18,12 @@ type RuleNode struct {
 	Parent     TreeNode
 	Children   []TreeNode
 	StartState *antlr.RuleStartState
+	// the node follows the end of the input (EOF), it produces nothing
+	ended bool
+	// the precedence that a left-recursive rule was invoked with, see PrecedencePredicateTransition
+	precedence int
+	// the number of children before each EOF that the rule matched, parse trees have EOF tokens there
+	eofs []int
 }
 
 func (n *RuleNode) GetParent() TreeNode {
@@ -36,6 +42,12 @@ type SymbolNode struct {
 	Parent     TreeNode
 	Children   []TreeNode
 	StartState *antlr.RuleStartState
+	// the token type of a token, i.e., a symbol node that a parser rule created, 0 for the symbol nodes of sub-rules
+	TokenType int
+	// the node follows the end of the input (EOF), it produces nothing
+	ended bool
+	// the lexer mode that a token is lexed in, i.e., the mode of the separator before it (see SeparatorPolicy)
+	mode int
 }
 
 func (n *SymbolNode) GetParent() TreeNode {
@@ -68,32 +80,141 @@ func NewLiteralNode(parent TreeNode, text rune) *LiteralNode {
 }
 
 type ATNWalker struct {
-	Lexer         antlr.Lexer
-	Parser        antlr.Parser
-	parserRouter  map[int]*Router
-	lexerRouter   map[int]*Router
-	deadline      time.Time
-	deadlineIsSet bool
+	Lexer           antlr.Lexer
+	Parser          antlr.Parser
+	parserRouter    map[int]*Router
+	lexerRouter     map[int]*Router
+	routingStrategy RoutingStrategy
+	deadline        time.Time
+	deadlineIsSet   bool
+	decisions       int
+	routedDecisions int
+	startRuleIndex  int
+	// how the lexer produces tokens and the lexer's modes while tokens are decoded or encoded in the order of the text
+	tokens *lexerModel
+	modes  lexerModes
+	// the characters of lexer wildcards, nil is the DefaultAlphabet
+	alphabet *antlr.IntervalSet
+	// the nodes that are left to decode, they decide whether the input can end (see canEnd)
+	pending *Stack[TreeNode]
+	// the callbacks of semantic predicates and actions (see SetPredicate and SetAction)
+	predicates map[semanticKey]Predicate
+	actions    map[semanticKey]Action
+	// what TreeToString writes between two tokens
+	separatorPolicy SeparatorPolicy
+	// the repairs of the tree that is encoded by EncodeTolerant, nil while encoding strictly
+	tolerance *tolerance
 }
 
 func NewATNWalker(parser antlr.Parser, lexer antlr.Lexer) *ATNWalker {
-	walker := &ATNWalker{Lexer: lexer, Parser: parser, parserRouter: make(map[int]*Router), lexerRouter: make(map[int]*Router), deadlineIsSet: false}
+	walker := &ATNWalker{Lexer: lexer, Parser: parser, parserRouter: make(map[int]*Router), lexerRouter: make(map[int]*Router), routingStrategy: &DefaultStrategy{}, deadlineIsSet: false, separatorPolicy: DefaultSeparatorPolicy}
 	return walker
 }
 
+// SetRoutingStrategy sets the strategy that chooses transitions once the PRNG drives the generation,
+// it must be set before decoding since the routers keep the strategy they were created with.
+func (w *ATNWalker) SetRoutingStrategy(strategy RoutingStrategy) {
+	w.routingStrategy = strategy
+}
+
+// SetStartRule sets the parser rule that Decode and Repair start with, an empty name is the first rule of the grammar.
+func (w *ATNWalker) SetStartRule(name string) error {
+	index, err := ruleIndex(w.Parser, name)
+	if err != nil {
+		return err
+	}
+	w.startRuleIndex = index
+	return nil
+}
+
+func ruleIndex(parser antlr.Parser, name string) (int, error) {
+	if name == "" {
+		return 0, nil
+	}
+	for i, ruleName := range parser.GetRuleNames() {
+		if ruleName == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("the grammar has no parser rule '%s'", name)
+}
+
+// ParseRule parses the input of the parser's token stream starting with the rule, an empty name is the first rule of
+// the grammar. The parsers generated by ANTLR have a method per rule, i.e., the rule name with an upper case initial.
+func ParseRule(parser antlr.Parser, name string) (antlr.Tree, error) {
+	index, err := ruleIndex(parser, name)
+	if err != nil {
+		return nil, err
+	}
+	name = parser.GetRuleNames()[index]
+	method := reflect.ValueOf(parser).MethodByName(strings.ToUpper(name[:1]) + name[1:])
+	if !method.IsValid() || method.Type().NumIn() != 0 || method.Type().NumOut() != 1 {
+		return nil, fmt.Errorf("the parser has no method for the rule '%s'", name)
+	}
+	tree, ok := method.Call(nil)[0].Interface().(antlr.Tree)
+	if !ok {
+		return nil, fmt.Errorf("the method of the rule '%s' does not return a parse tree", name)
+	}
+	return tree, nil
+}
+
+func (w *ATNWalker) observeChoice(router *Router, state, choice int) {
+	if observer, ok := w.routingStrategy.(ChoiceObserver); ok {
+		observer.ObserveChoice(router, state, choice)
+	}
+}
+
+// allowedToken returns the token type at the index of the set or, if the routing strategy disables it, the next
+// allowed token type of the set (wrapping around), see ChoiceFilter.
+func (w *ATNWalker) allowedToken(set *antlr.IntervalSet, index int) int {
+	if filter, ok := w.routingStrategy.(ChoiceFilter); ok {
+		for i := 0; i < set.Length(); i++ {
+			if tokenType := set.Get((index + i) % set.Length()); !filter.TokenDisabled(tokenType) {
+				return tokenType
+			}
+		}
+	}
+	return set.Get(index)
+}
+
 func (w *ATNWalker) SetDeadline(t time.Time) {
 	w.deadline = t
 	w.deadlineIsSet = true
 }
 
+// TimedOut reports whether the deadline was exceeded, i.e., whether the last Decode or Repair gave up.
+func (w *ATNWalker) TimedOut() bool {
+	return w.exceededDeadline()
+}
+
+// DecisionStats returns how many choices were made at decision states and how many of them were routed,
+// i.e., made by the PRNG because the data was exhausted.
+func (w *ATNWalker) DecisionStats() (decisions, routed int) {
+	return w.decisions, w.routedDecisions
+}
+
+// RouterStats returns the number of routers (one per rule) and the number of decision states they learned routes
+// for, it waits until the routers finished learning.
+func (w *ATNWalker) RouterStats() (routers, routeOptions int) {
+	for _, routerTable := range []map[int]*Router{w.parserRouter, w.lexerRouter} {
+		for _, router := range routerTable {
+			router.mutex.Lock()
+			routeOptions += len(router.stateToOptions)
+			router.mutex.Unlock()
+		}
+		routers += len(routerTable)
+	}
+	return routers, routeOptions
+}
+
 type ParserTraceEdge struct {
 	State  antlr.ATNState
 	Choice int
 	Cursor int
 }
 
-func (w *ATNWalker) encodeParserRuleATN(encoder *Encoder, node *WrappedTreeNode) {
-
+// traceParserRule returns the path through the rule of the node that matches its children, false if there is none.
+func (w *ATNWalker) traceParserRule(node *WrappedTreeNode) ([]*ParserTraceEdge, bool) {
 	var transition antlr.Transition
 	cursor := 0
 	choice := 0
@@ -103,6 +224,9 @@ func (w *ATNWalker) encodeParserRuleATN(encoder *Encoder, node *WrappedTreeNode)
 	for !(state.GetStateType() == antlr.ATNStateRuleStop && cursor == len(node.Children)) {
 		// backtrack
 		if choice >= len(state.GetTransitions()) || state.GetStateType() == antlr.ATNStateRuleStop {
+			if traceStack.IsEmpty() {
+				return nil, false
+			}
 			t := traceStack.Pop()
 			state = t.State
 			choice = t.Choice + 1
@@ -148,6 +272,26 @@ func (w *ATNWalker) encodeParserRuleATN(encoder *Encoder, node *WrappedTreeNode)
 					}
 				}
 			}
+		case *antlr.NotSetTransition, *antlr.RangeTransition, *antlr.WildcardTransition:
+			if cursor < len(node.Children) {
+				if terminal, ok := node.Children[cursor].OriginalNode.(antlr.TerminalNode); ok {
+					if w.tokenSet(t).Contains(terminal.GetSymbol().GetTokenType()) {
+						traceStack.Push(&ParserTraceEdge{state, choice, cursor})
+						state = transition.(antlr.AnyTransition).GetTarget()
+						cursor++
+						choice = 0
+						continue
+					}
+				}
+			}
+		case *antlr.PrecedencePredicateTransition:
+			// the operators of a left-recursive rule that bind weaker than the rule's invocation are not in the rule
+			if t.GetPrecedence() >= node.precedence {
+				traceStack.Push(&ParserTraceEdge{state, choice, cursor})
+				state = t.GetTarget()
+				choice = 0
+				continue
+			}
 		default:
 			traceStack.Push(&ParserTraceEdge{state, choice, cursor})
 			state = transition.(antlr.AnyTransition).GetTarget()
@@ -162,6 +306,27 @@ func (w *ATNWalker) encodeParserRuleATN(encoder *Encoder, node *WrappedTreeNode)
 	for i := traceStack.Size() - 1; i >= 0; i-- {
 		edges[i] = traceStack.Pop()
 	}
+	return edges, true
+}
+
+// encodeParserRuleATN encodes the choices of the rule node and reports whether the input ends within the node, the
+// choices after the end of the input are not encoded since decoding them takes the first path that produces nothing.
+// It panics if the children of the node do not match the rule, unless the walker tolerates errors (see EncodeTolerant).
+func (w *ATNWalker) encodeParserRuleATN(encoder *Encoder, node *WrappedTreeNode) bool {
+	if w.tolerance != nil {
+		w.tolerance.dropErrorNodes(node)
+	}
+	edges, ok := w.traceParserRule(node)
+	if !ok && w.tolerance != nil {
+		w.tolerance.align(w, node)
+		edges, ok = w.traceParserRule(node)
+	}
+	if !ok {
+		panic(fmt.Sprintf("the children of the rule %s do not match the grammar, does the tree contain errors?",
+			w.Parser.GetRuleNames()[node.GetRuleIndex()]))
+	}
+
+	ends := endModels.get(w.Parser.GetATN())
 	headerSet := false
 	for _, edge := range edges {
 		if len(edge.State.GetTransitions()) > 1 {
@@ -174,185 +339,26 @@ func (w *ATNWalker) encodeParserRuleATN(encoder *Encoder, node *WrappedTreeNode)
 
 		transition := edge.State.GetTransitions()[edge.Choice]
 		switch t := transition.(type) {
-		case *antlr.SetTransition:
-			if t.GetLabel().Length() > 1 {
+		case *antlr.RuleTransition:
+			node.Children[edge.Cursor].precedence = t.GetPrecedence()
+		case *antlr.SetTransition, *antlr.NotSetTransition, *antlr.RangeTransition, *antlr.WildcardTransition:
+			tokens := w.tokenSet(transition)
+			if tokens.Length() > 1 {
 				if !headerSet {
 					encoder.WriteRuleHeader(node.GetRuleIndex(), len(w.Parser.GetATN().GetRuleIndexToStartStateSlice()), false)
 					headerSet = true
 				}
-				encoder.Encode(t.GetLabel().GetIndex(node.Children[edge.Cursor].OriginalNode.(antlr.TerminalNode).GetSymbol().GetTokenType()), t.GetLabel().Length())
+				encoder.Encode(tokens.GetIndex(node.Children[edge.Cursor].OriginalNode.(antlr.TerminalNode).GetSymbol().GetTokenType()), tokens.Length())
 			}
 		}
-	}
-}
-
-// to avoid memory leaks
-func cleanupTraces(traceNodes ...*LexerTrace) {
-	stack := &Stack[*LexerTrace]{}
-	for _, t := range traceNodes {
-		stack.Push(t)
-	}
-	var node *LexerTrace
-	for !stack.IsEmpty() {
-		node = stack.Pop()
-		if node.SubTraces != nil {
-			for _, st := range node.SubTraces {
-				stack.Push(st)
+		if ends.endsInput(transition) {
+			for _, child := range node.Children[edge.Cursor+1:] {
+				child.ended = true
 			}
+			return true
 		}
-		node.SubTraces = nil
 	}
-}
-
-func computeRuleMatches(text string, cursor int, state antlr.ATNState) *Stack[*LexerTrace] {
-	semaphore := make(chan struct{}, runtime.NumCPU())
-	for i := 0; i < runtime.NumCPU(); i++ {
-		semaphore <- struct{}{}
-	}
-	results := make(chan *LexerTrace, runtime.NumCPU())
-	for i := len(text); i > cursor; i-- {
-		go func(i int) {
-			<-semaphore
-			results <- match(text[cursor:i], state)
-			semaphore <- struct{}{}
-		}(i)
-	}
-	stack := &Stack[*LexerTrace]{}
-	for i := len(text); i > cursor; i-- {
-		solution := <-results
-		if solution != nil {
-			stack.Push(solution)
-		}
-	}
-	return stack
-}
-
-func match(text string, state antlr.ATNState) *LexerTrace {
-	// return valid trace if we can match the text, otherwise return nil
-
-	var transition antlr.Transition
-	cursor := 0
-	choice := 0
-	traceStack := &Stack[*LexerTraceEdge]{}
-
-	ruleMatches := map[string]*Stack[*LexerTrace]{}
-
-	var subTraces []*LexerTrace
-	for !(state.GetStateType() == antlr.ATNStateRuleStop && cursor == len(text)) {
-		// backtrack or report mismatch
-		if choice >= len(state.GetTransitions()) && len(state.GetTransitions()) > 0 || state.GetStateType() == antlr.ATNStateRuleStop {
-			// report mismatch
-			if traceStack.IsEmpty() {
-				cleanupTraces(subTraces...)
-				for i := 0; i < len(subTraces); i++ {
-					subTraces[i] = nil
-				}
-				return nil
-			}
-			// backtrack
-			t := traceStack.Pop()
-			state = t.State
-			choice = t.Choice + 1
-			cursor = t.Cursor
-
-			// special case, when other token matches could be possible
-			// do not continue with the next choice then, but with the next match
-			id := id(t.State.GetStateNumber(), t.Choice, cursor)
-			if possibleMatches, ok := ruleMatches[id]; ok {
-				if !possibleMatches.IsEmpty() {
-					choice = t.Choice
-				}
-			}
-
-			cleanupTraces(subTraces[t.SubTraceLen:]...)
-			for i := len(subTraces) - 1; i >= t.SubTraceLen; i-- {
-				subTraces[i] = nil
-			}
-			subTraces = subTraces[:t.SubTraceLen]
-			continue
-		}
-
-		transition = state.GetTransitions()[choice]
-		switch t := transition.(type) {
-		case *antlr.RuleTransition:
-			if cursor < len(text) {
-
-				id := id(state.GetStateNumber(), choice, cursor)
-				possibleMatches, ok := ruleMatches[id]
-				if !ok {
-					possibleMatches = computeRuleMatches(text, cursor, transition.(antlr.AnyTransition).GetTarget())
-					ruleMatches[id] = possibleMatches
-				}
-
-				if !possibleMatches.IsEmpty() {
-					trace := possibleMatches.Pop()
-					traceStack.Push(&LexerTraceEdge{state, choice, cursor, len(subTraces)})
-					subTraces = append(subTraces, trace)
-					state = t.GetFollowState()
-					cursor += len(trace.Text)
-					choice = 0
-					continue
-				}
-			}
-		case *antlr.AtomTransition:
-			if cursor < len(text) {
-				if rune(text[cursor]) == rune(t.GetLabelValue()) {
-					traceStack.Push(&LexerTraceEdge{state, choice, cursor, len(subTraces)})
-					state = transition.(antlr.AnyTransition).GetTarget()
-					cursor++
-					choice = 0
-					continue
-				}
-			}
-		case *antlr.NotSetTransition:
-			if cursor < len(text) {
-				possibleRunes := t.GetLabel().Complement()
-				if possibleRunes.Contains(int(rune(text[cursor]))) {
-					traceStack.Push(&LexerTraceEdge{state, choice, cursor, len(subTraces)})
-					state = transition.(antlr.AnyTransition).GetTarget()
-					cursor++
-					choice = 0
-					continue
-				}
-			}
-		case *antlr.SetTransition:
-			if cursor < len(text) {
-				possibleRunes := t.GetLabel()
-				if possibleRunes.Contains(int(rune(text[cursor]))) {
-					traceStack.Push(&LexerTraceEdge{state, choice, cursor, len(subTraces)})
-					state = transition.(antlr.AnyTransition).GetTarget()
-					cursor++
-					choice = 0
-					continue
-				}
-			}
-		case *antlr.RangeTransition:
-			if cursor < len(text) {
-				possibleRunes := t.GetLabel()
-				if possibleRunes.Contains(int(rune(text[cursor]))) {
-					traceStack.Push(&LexerTraceEdge{state, choice, cursor, len(subTraces)})
-					state = transition.(antlr.AnyTransition).GetTarget()
-					cursor++
-					choice = 0
-					continue
-				}
-			}
-		default:
-			traceStack.Push(&LexerTraceEdge{state, choice, cursor, len(subTraces)})
-			state = transition.(antlr.AnyTransition).GetTarget()
-			choice = 0
-			continue
-		}
-		// try next choice from the current state
-		choice++
-	}
-
-	edges := make([]*LexerTraceEdge, traceStack.Size())
-	for i := traceStack.Size() - 1; i >= 0; i-- {
-		edges[i] = traceStack.Pop()
-	}
-
-	return &LexerTrace{text, edges, subTraces}
+	return false
 }
 
 type LexerTraceEdge struct {
@@ -363,40 +369,33 @@ type LexerTraceEdge struct {
 }
 
 type LexerTrace struct {
-	Text      string
+	Text      []rune
 	Edges     []*LexerTraceEdge
 	SubTraces []*LexerTrace
 }
 
-func id(state, choice, cursor int) string {
-	idBytes := make([]byte, 0, 24)
-	for _, number := range []int{state, choice, cursor} {
-		idBytes = append(idBytes,
-			byte(number>>56),
-			byte(number&0x00ff000000000000>>48),
-			byte(number&0x0000ff0000000000>>40),
-			byte(number&0x000000ff00000000>>32),
-			byte(number&0x00000000ff000000>>24),
-			byte(number&0x0000000000ff0000>>16),
-			byte(number&0x000000000000ff00>>8),
-			byte(number&0x00000000000000ff))
-	}
-	return string(idBytes)
-}
-
-func (w *ATNWalker) encodeLexerSymbolATN(encoder *Encoder, node antlr.Token) {
-	// TODO: currently, we just ignore EOF tokens, in the original SQLite grammar this caused to produce invalid statements <sql_stmt><EOF><sql_stmt> ...
-	//       right now, this is a known limitation but a reasonable one since the above example seems odd
+// encodeLexerSymbolATN encodes the lexer rules of the token and reports whether the token ends the input.
+func (w *ATNWalker) encodeLexerSymbolATN(encoder *Encoder, node antlr.Token) bool {
+	// the EOF token has no lexer rule, it ends the input (see encodeParserRuleATN)
 	if node.GetTokenType() != antlr.TokenEOF {
+		// the traces that follow EOF within the token are not encoded
+		ends := endModels.get(w.Lexer.GetATN())
+		endedTraces := map[*LexerTrace]bool{}
+		endsInput := false
 		var trace *LexerTrace
-		trace = match(node.GetText(), w.Lexer.GetATN().GetRuleIndexToStartStateSlice()[node.GetTokenType()-1])
 		nextTracesQueue := &Queue[*LexerTrace]{}
-		nextTracesQueue.Enqueue(trace)
+		for _, trace = range w.encodeTokenSteps(encoder, node.GetTokenType(), []rune(node.GetText())) {
+			nextTracesQueue.Enqueue(trace)
+		}
 		for !nextTracesQueue.IsEmpty() {
 			trace = nextTracesQueue.Dequeue()
 			headerSet := false
 			// encoder.WriteRuleHeader(trace.Edges[0].State.GetRuleIndex(), len(w.Lexer.GetATN().GetRuleIndexToStartStateSlice()), true)
+			ended, endIndex := endedTraces[trace], 0
 			for _, edge := range trace.Edges {
+				if ended {
+					break
+				}
 				if len(edge.State.GetTransitions()) > 1 {
 					if !headerSet {
 						encoder.WriteRuleHeader(trace.Edges[0].State.GetRuleIndex(), len(w.Lexer.GetATN().GetRuleIndexToStartStateSlice()), true)
@@ -413,7 +412,7 @@ func (w *ATNWalker) encodeLexerSymbolATN(encoder *Encoder, node antlr.Token) {
 							encoder.WriteRuleHeader(trace.Edges[0].State.GetRuleIndex(), len(w.Lexer.GetATN().GetRuleIndexToStartStateSlice()), true)
 							headerSet = true
 						}
-						encoder.Encode(possibleRunes.GetIndex(int(rune(trace.Text[edge.Cursor]))), possibleRunes.Length())
+						encoder.Encode(possibleRunes.GetIndex(int(trace.Text[edge.Cursor])), possibleRunes.Length())
 					}
 				case *antlr.SetTransition:
 					possibleRunes := t.GetLabel()
@@ -422,7 +421,7 @@ func (w *ATNWalker) encodeLexerSymbolATN(encoder *Encoder, node antlr.Token) {
 							encoder.WriteRuleHeader(trace.Edges[0].State.GetRuleIndex(), len(w.Lexer.GetATN().GetRuleIndexToStartStateSlice()), true)
 							headerSet = true
 						}
-						encoder.Encode(possibleRunes.GetIndex(int(rune(trace.Text[edge.Cursor]))), possibleRunes.Length())
+						encoder.Encode(possibleRunes.GetIndex(int(trace.Text[edge.Cursor])), possibleRunes.Length())
 					}
 				case *antlr.RangeTransition:
 					possibleRunes := t.GetLabel()
@@ -431,21 +430,51 @@ func (w *ATNWalker) encodeLexerSymbolATN(encoder *Encoder, node antlr.Token) {
 							encoder.WriteRuleHeader(trace.Edges[0].State.GetRuleIndex(), len(w.Lexer.GetATN().GetRuleIndexToStartStateSlice()), true)
 							headerSet = true
 						}
-						encoder.Encode(possibleRunes.GetIndex(int(rune(trace.Text[edge.Cursor]))), possibleRunes.Length())
+						encoder.Encode(possibleRunes.GetIndex(int(trace.Text[edge.Cursor])), possibleRunes.Length())
+					}
+				case *antlr.WildcardTransition:
+					possibleRunes := w.wildcardAlphabet()
+					if possibleRunes.Length() > 1 {
+						if !headerSet {
+							encoder.WriteRuleHeader(trace.Edges[0].State.GetRuleIndex(), len(w.Lexer.GetATN().GetRuleIndexToStartStateSlice()), true)
+							headerSet = true
+						}
+						// the wildcard matched a character that is not generated, the first one is the closest we have
+						index := 0
+						if possibleRunes.Contains(int(trace.Text[edge.Cursor])) {
+							index = possibleRunes.GetIndex(int(trace.Text[edge.Cursor]))
+						}
+						encoder.Encode(index, possibleRunes.Length())
+					}
+				}
+				// the sub-trace of a rule that ends the input is encoded, the ones after it are not
+				if ends.endsInput(transition) {
+					ended, endIndex, endsInput = true, edge.SubTraceLen, true
+					if _, ok := transition.(*antlr.RuleTransition); ok {
+						endIndex++
 					}
 				}
 			}
-			for _, subTrace := range trace.SubTraces {
+			for i, subTrace := range trace.SubTraces {
+				if ended && i >= endIndex {
+					endedTraces[subTrace] = true
+				}
 				nextTracesQueue.Enqueue(subTrace)
 			}
 		}
+		return endsInput
 	}
+	return false
 }
 
 type WrappedTreeNode struct {
 	OriginalNode antlr.Tree
 	Parent       *WrappedTreeNode
 	Children     []*WrappedTreeNode
+	// the node follows the end of the input (EOF), it is not encoded
+	ended bool
+	// the precedence that a left-recursive rule was invoked with, see PrecedencePredicateTransition
+	precedence int
 }
 
 func NewWrappedTreeNode(root antlr.Tree) *WrappedTreeNode {
@@ -536,19 +565,32 @@ func (w *ATNWalker) wrapANTLRTreeAndEliminateLeftRecursion(root antlr.Tree) *Wra
 func (w *ATNWalker) Encode(root antlr.Tree) []byte {
 	newRoot := w.wrapANTLRTreeAndEliminateLeftRecursion(root)
 	encoder := NewEncoder(nil)
+	w.modes = lexerModes{}
 	var node *WrappedTreeNode
 
 	nextNodesStack := &Stack[*WrappedTreeNode]{}
 	nextNodesStack.Push(newRoot)
 	for !nextNodesStack.IsEmpty() {
 		node = nextNodesStack.Pop()
-		if node.IsRule() {
-			w.encodeParserRuleATN(encoder, node)
+		if node.ended {
 			for i := len(node.Children) - 1; i >= 0; i-- {
+				node.Children[i].ended = true
 				nextNodesStack.Push(node.Children[i])
 			}
-		} else {
-			w.encodeLexerSymbolATN(encoder, node.OriginalNode.(antlr.TerminalNode).GetSymbol())
+		} else if node.IsRule() {
+			// the nodes that follow the end of the input are not encoded
+			if w.encodeParserRuleATN(encoder, node) {
+				for _, next := range nextNodesStack.data {
+					next.ended = true
+				}
+			}
+			for i := len(node.Children) - 1; i >= 0; i-- {
+				nextNodesStack.Push(node.Children[i])
+			}
+		} else if w.encodeLexerSymbolATN(encoder, node.OriginalNode.(antlr.TerminalNode).GetSymbol()) {
+			for _, next := range nextNodesStack.data {
+				next.ended = true
+			}
 		}
 	}
 
@@ -557,7 +599,31 @@ func (w *ATNWalker) Encode(root antlr.Tree) []byte {
 
 func (w *ATNWalker) decodeParserRuleATN(decoder *Decoder, parent *RuleNode) {
 
-	decoder.Init(parent.StartState.GetRuleIndex(), false)
+	// nothing is decoded after the end of the input, the node takes the first path that produces nothing
+	ended := parent.ended
+	if !ended {
+		decoder.Init(parent.StartState.GetRuleIndex(), false)
+	}
+	ends := endModels.get(w.Parser.GetATN())
+	guards := guardModels.get(w.Parser.GetATN())
+	endIndex := 0
+	decode := func(boundary int) int {
+		if ended {
+			return 0
+		}
+		return decoder.Decode(boundary)
+	}
+	// once the input ends, the remaining children of this node and the nodes left to decode produce nothing
+	end := func() {
+		ended = true
+		endIndex = len(parent.Children)
+		w.endPending()
+	}
+	defer func() {
+		if ended {
+			endNodes(parent.Children[endIndex:])
+		}
+	}()
 
 	var state antlr.ATNState = parent.StartState
 	var transition antlr.Transition
@@ -574,8 +640,10 @@ func (w *ATNWalker) decodeParserRuleATN(decoder *Decoder, parent *RuleNode) {
 			state.GetRuleIndex(),
 			state.GetStateNumber(),
 			state.GetATN().GetRuleIndexToStopStateSlice()[parent.StartState.GetRuleIndex()].GetStateNumber(),
+			false,
 			state.GetATN(),
-			decoder)
+			decoder,
+			w.routingStrategy)
 		w.parserRouter[parent.StartState.GetRuleIndex()] = router
 	}
 	router.mutex.Lock()
@@ -604,32 +672,42 @@ func (w *ATNWalker) decodeParserRuleATN(decoder *Decoder, parent *RuleNode) {
 			if prevState >= 0 {
 				edges <- &RouteEdge{prevState, state.GetStateNumber(), prevChoice, rules}
 			}
-			if !decoder.usePRNG {
-				choice = decoder.Decode(numTransitions)
+			if ended {
+				choice = ends.emptyChoice(state)
 			} else {
-				if rootPathRules == nil {
-					rootPathRules = make(map[int]struct{})
-					p := parent
-					for p != nil {
-						rootPathRules[p.StartState.BaseATNState.GetRuleIndex()] = struct{}{}
-						if p.GetParent() != nil {
-							p = p.GetParent().(*RuleNode)
-						} else {
-							p = nil
+				w.decisions++
+				if !decoder.usePRNG {
+					choice = router.allowedChoice(state.GetStateNumber(), decoder.Decode(numTransitions))
+					choice = w.viableChoice(parent, ends, guards, router, state.GetStateNumber(), choice)
+				} else {
+					w.routedDecisions++
+					if rootPathRules == nil {
+						rootPathRules = make(map[int]struct{})
+						p := parent
+						for p != nil {
+							rootPathRules[p.StartState.BaseATNState.GetRuleIndex()] = struct{}{}
+							if p.GetParent() != nil {
+								p = p.GetParent().(*RuleNode)
+							} else {
+								p = nil
+							}
 						}
 					}
-				}
-				edges <- nil
-				<-okLearned
-				choice = router.route(state.GetStateNumber(), rootPathRules)
-				if decoder.writeBackEncoder != nil {
-					if !decoder.writeBackHead.isSet {
-						decoder.writeBackEncoder.WriteRuleHeader(decoder.writeBackHead.ruleIndex, decoder.writeBackHead.numRules, decoder.writeBackHead.isLexerRule)
-						decoder.writeBackHead.isSet = true
+					edges <- nil
+					<-okLearned
+					choice = w.viableChoice(parent, ends, guards, router, state.GetStateNumber(), router.route(state.GetStateNumber(), rootPathRules))
+					if decoder.writeBackEncoder != nil {
+						if !decoder.writeBackHead.isSet {
+							decoder.writeBackEncoder.WriteRuleHeader(decoder.writeBackHead.ruleIndex, decoder.writeBackHead.numRules, decoder.writeBackHead.isLexerRule)
+							decoder.writeBackHead.isSet = true
+						}
+						decoder.writeBackEncoder.Encode(choice, numTransitions)
 					}
-					decoder.writeBackEncoder.Encode(choice, numTransitions)
 				}
 			}
+			if !ended {
+				w.observeChoice(router, state.GetStateNumber(), choice)
+			}
 			prevState = state.GetStateNumber()
 			prevChoice = choice
 			rules = make([]int, 0)
@@ -640,8 +718,13 @@ func (w *ATNWalker) decodeParserRuleATN(decoder *Decoder, parent *RuleNode) {
 		transition = state.GetTransitions()[choice]
 		switch t := transition.(type) {
 		case *antlr.RuleTransition:
-			parent.Children = append(parent.Children, NewRuleNode(parent, w.Parser.GetATN().GetRuleIndexToStartStateSlice()[t.GetRuleIndex()]))
+			child := NewRuleNode(parent, w.Parser.GetATN().GetRuleIndexToStartStateSlice()[t.GetRuleIndex()])
+			child.precedence = t.GetPrecedence()
+			parent.Children = append(parent.Children, child)
 			rules = append(rules, t.GetRuleIndex())
+			if !ended && ends.endsInput(t) {
+				end()
+			}
 			state = t.GetFollowState()
 			continue
 		case *antlr.AtomTransition:
@@ -653,18 +736,29 @@ func (w *ATNWalker) decodeParserRuleATN(decoder *Decoder, parent *RuleNode) {
 			// - each element in antlr.BaseParser.SymbolicNames, is a rule in the antlr.BaseLexer.RuleNames
 			//   (antlr.BaseParser.SymbolicNames is a subset of antlr.BaseLexer.RuleNames, the lexer may have additional rules defined,
 			//   which are, however, not present in the parser ATN as tokens in the AtomTransition)
-			// - the lexer RuleNames start at 0 and not at 1, thus the (parser transition) label-1 represents the corresponding rule in the lexer
+			// - the lexer RuleNames start at 0 and not at 1, thus the (parser transition) label-1 usually represents the
+			//   corresponding rule in the lexer, however, rules with the type command, fragments, or tokens without a
+			//   rule break this, the lexer ATN knows the token type of each rule (see tokenStartState)
 			if t.GetLabelValue() != antlr.TokenEOF {
-				parent.Children = append(parent.Children, NewSymbolNode(nil, w.Lexer.GetATN().GetRuleIndexToStartStateSlice()[t.GetLabelValue()-1]))
+				w.appendToken(parent, t.GetLabelValue())
+			} else {
+				parent.eofs = append(parent.eofs, len(parent.Children))
+				if !ended {
+					end()
+				}
 			}
 		case *antlr.SetTransition:
 			// from what I understood:
 			// - a SetTransition in a parser encodes a set of symbols, i.e. token types
 			// - using the same logic as for AtomTransitions to obtain the lexer rule start state should work
-			lexerRuleIndex := t.GetLabel().Get(decoder.Decode(t.GetLabel().Length())) - 1
-			parent.Children = append(parent.Children, NewSymbolNode(nil, w.Lexer.GetATN().GetRuleIndexToStartStateSlice()[lexerRuleIndex]))
-		case *antlr.RangeTransition:
-			panic("Transition type antlr.RangeTransition is not implemented for decodeParserRuleATN.")
+			w.appendToken(parent, w.allowedToken(t.GetLabel(), decode(t.GetLabel().Length())))
+		case *antlr.NotSetTransition, *antlr.RangeTransition, *antlr.WildcardTransition:
+			// the same as a SetTransition once the set of token types is known
+			if tokens := w.tokenSet(t); tokens.Length() > 0 {
+				w.appendToken(parent, w.allowedToken(tokens, decode(tokens.Length())))
+			}
+		case *antlr.ActionTransition:
+			w.act(parent, t)
 		}
 		state = transition.(antlr.AnyTransition).GetTarget()
 	}
@@ -673,9 +767,59 @@ func (w *ATNWalker) decodeParserRuleATN(decoder *Decoder, parent *RuleNode) {
 	}
 }
 
+// appendToken appends a token node of the token type to the parser rule node, tokens without a lexer rule are omitted.
+func (w *ATNWalker) appendToken(parent *RuleNode, tokenType int) {
+	if startState := w.tokenStartState(tokenType); startState != nil {
+		node := NewSymbolNode(nil, startState)
+		node.TokenType = tokenType
+		parent.Children = append(parent.Children, node)
+	}
+}
+
 func (w *ATNWalker) decodeLexerSymbolATN(decoder *Decoder, parent *SymbolNode) {
 
-	decoder.Init(parent.StartState.GetRuleIndex(), true)
+	// the lexer rules of a token depend on the lexer's mode, tokens are decoded in the order of the text
+	if parent.TokenType != 0 {
+		parent.mode = w.modes.mode
+	}
+	if parent.TokenType != 0 && !parent.ended && w.decodeToken(decoder, parent) {
+		return
+	}
+
+	// after EOF, the node takes the first path that consumes nothing without decoding anything
+	ended := parent.ended
+	ends := endModels.get(w.Lexer.GetATN())
+	guards := guardModels.get(w.Lexer.GetATN())
+	endIndex := 0
+	end := func() {
+		ended = true
+		endIndex = len(parent.Children)
+		w.endPending()
+	}
+	defer func() {
+		if ended {
+			endNodes(parent.Children[endIndex:])
+		}
+	}()
+
+	// the decoder is initialized when data is needed, rules without decisions have no data of their own, and they must
+	// not take the data of the next node of the same rule, e.g., the token options of a canonical rule (see decodeToken)
+	initialized := false
+	initDecoder := func() {
+		if !initialized {
+			decoder.Init(parent.StartState.GetRuleIndex(), true)
+			initialized = true
+		}
+	}
+	decode := func(boundary int) int {
+		if ended {
+			return 0
+		}
+		if boundary > 1 {
+			initDecoder()
+		}
+		return decoder.Decode(boundary)
+	}
 
 	var state antlr.ATNState = parent.StartState
 	var transition antlr.Transition
@@ -692,8 +836,10 @@ func (w *ATNWalker) decodeLexerSymbolATN(decoder *Decoder, parent *SymbolNode) {
 			state.GetRuleIndex(),
 			state.GetStateNumber(),
 			state.GetATN().GetRuleIndexToStopStateSlice()[state.GetRuleIndex()].GetStateNumber(),
+			true,
 			state.GetATN(),
-			decoder)
+			decoder,
+			w.routingStrategy)
 		w.lexerRouter[parent.StartState.GetRuleIndex()] = router
 	}
 	router.mutex.Lock()
@@ -722,32 +868,44 @@ func (w *ATNWalker) decodeLexerSymbolATN(decoder *Decoder, parent *SymbolNode) {
 			if prevState >= 0 {
 				edges <- &RouteEdge{prevState, state.GetStateNumber(), prevChoice, rules}
 			}
-			if !decoder.usePRNG {
-				choice = decoder.Decode(numTransitions)
+			if ended {
+				choice = ends.emptyChoice(state)
 			} else {
-				if rootPathRules == nil {
-					rootPathRules = make(map[int]struct{})
-					p := parent
-					for p != nil {
-						rootPathRules[p.StartState.BaseATNState.GetRuleIndex()] = struct{}{}
-						if p.GetParent() != nil {
-							p = p.GetParent().(*SymbolNode)
-						} else {
-							p = nil
+				w.decisions++
+				if !decoder.usePRNG {
+					initDecoder()
+					choice = router.allowedChoice(state.GetStateNumber(), decode(numTransitions))
+					choice = w.viableChoice(parent, ends, guards, router, state.GetStateNumber(), choice)
+				} else {
+					initDecoder()
+					w.routedDecisions++
+					if rootPathRules == nil {
+						rootPathRules = make(map[int]struct{})
+						p := parent
+						for p != nil {
+							rootPathRules[p.StartState.BaseATNState.GetRuleIndex()] = struct{}{}
+							if p.GetParent() != nil {
+								p = p.GetParent().(*SymbolNode)
+							} else {
+								p = nil
+							}
 						}
 					}
-				}
-				edges <- nil
-				<-okLearned
-				choice = router.route(state.GetStateNumber(), rootPathRules)
-				if decoder.writeBackEncoder != nil {
-					if !decoder.writeBackHead.isSet {
-						decoder.writeBackEncoder.WriteRuleHeader(decoder.writeBackHead.ruleIndex, decoder.writeBackHead.numRules, decoder.writeBackHead.isLexerRule)
-						decoder.writeBackHead.isSet = true
+					edges <- nil
+					<-okLearned
+					choice = w.viableChoice(parent, ends, guards, router, state.GetStateNumber(), router.route(state.GetStateNumber(), rootPathRules))
+					if decoder.writeBackEncoder != nil {
+						if !decoder.writeBackHead.isSet {
+							decoder.writeBackEncoder.WriteRuleHeader(decoder.writeBackHead.ruleIndex, decoder.writeBackHead.numRules, decoder.writeBackHead.isLexerRule)
+							decoder.writeBackHead.isSet = true
+						}
+						decoder.writeBackEncoder.Encode(choice, numTransitions)
 					}
-					decoder.writeBackEncoder.Encode(choice, numTransitions)
 				}
 			}
+			if !ended {
+				w.observeChoice(router, state.GetStateNumber(), choice)
+			}
 			prevState = state.GetStateNumber()
 			prevChoice = choice
 			rules = make([]int, 0)
@@ -759,28 +917,40 @@ func (w *ATNWalker) decodeLexerSymbolATN(decoder *Decoder, parent *SymbolNode) {
 		switch t := transition.(type) {
 		case *antlr.RuleTransition:
 			parent.Children = append(parent.Children, NewSymbolNode(parent, w.Lexer.GetATN().GetRuleIndexToStartStateSlice()[t.GetRuleIndex()]))
+			if !ended && ends.endsInput(t) {
+				end()
+			}
 			state = t.GetFollowState()
 			continue
 		case *antlr.AtomTransition:
+			// EOF matches nothing, the rest of the token must not consume anything either
+			if t.GetLabelValue() == antlr.TokenEOF {
+				if !ended {
+					end()
+				}
+				break
+			}
 			parent.Children = append(parent.Children, NewLiteralNode(parent, rune(t.GetLabelValue())))
 		// order is important here, a NotSetTransition is also a SetTransition so find out whether this is a NotSetTransition first
 		case *antlr.NotSetTransition:
 			possibleRunes := t.GetLabel().Complement()
-			chosenRune := rune(possibleRunes.Get(decoder.Decode(possibleRunes.Length())))
+			chosenRune := rune(possibleRunes.Get(decode(possibleRunes.Length())))
 			parent.Children = append(parent.Children, NewLiteralNode(parent, chosenRune))
 		case *antlr.SetTransition:
 			possibleRunes := t.GetLabel()
-			chosenRune := rune(possibleRunes.Get(decoder.Decode(possibleRunes.Length())))
+			chosenRune := rune(possibleRunes.Get(decode(possibleRunes.Length())))
 			parent.Children = append(parent.Children, NewLiteralNode(parent, chosenRune))
 		case *antlr.RangeTransition:
 			possibleRunes := t.GetLabel()
-			chosenRune := rune(possibleRunes.Get(decoder.Decode(possibleRunes.Length())))
+			chosenRune := rune(possibleRunes.Get(decode(possibleRunes.Length())))
+			parent.Children = append(parent.Children, NewLiteralNode(parent, chosenRune))
+		case *antlr.WildcardTransition:
+			// a wildcard has no label, it matches any character but only generates those of the alphabet
+			possibleRunes := w.wildcardAlphabet()
+			chosenRune := rune(possibleRunes.Get(decode(possibleRunes.Length())))
 			parent.Children = append(parent.Children, NewLiteralNode(parent, chosenRune))
-			// TODO: why does it crash here?
-			//case *antlr.WildcardTransition:
-			//	possibleRunes := t.GetLabel()
-			//	chosenRune := rune(possibleRunes.Get(decoder.Decode(possibleRunes.Length())))
-			//	parent.Children = append(parent.Children, NewLiteralNode(parent, chosenRune))
+		case *antlr.ActionTransition:
+			w.act(parent, t)
 		}
 		state = transition.(antlr.AnyTransition).GetTarget()
 	}
@@ -814,10 +984,19 @@ func (w *ATNWalker) exceededDeadline() bool {
 }
 
 func (w *ATNWalker) AssembleTree(decoder *Decoder, root *RuleNode, stack *Stack[TreeNode]) bool {
-	var node TreeNode
-	var children []TreeNode
 	stack = &Stack[TreeNode]{}
 	stack.Push(root)
+	return w.assemble(decoder, stack)
+}
+
+// assemble decodes the nodes on the stack and their descendants, the nodes below the top also count as pending, i.e.,
+// the input cannot end before them (see canEnd).
+func (w *ATNWalker) assemble(decoder *Decoder, stack *Stack[TreeNode]) bool {
+	var node TreeNode
+	var children []TreeNode
+	w.modes = lexerModes{}
+	w.pending = stack
+	defer func() { w.pending = nil }()
 	for !stack.IsEmpty() {
 		if w.exceededDeadline() {
 			return false
@@ -846,6 +1025,10 @@ func (w *ATNWalker) TreeToString(root *RuleNode, stack *Stack[TreeNode]) string
 	builder := strings.Builder{}
 	var node TreeNode
 	var children []TreeNode
+	// the rune offsets of the tokens that follow another token, the separators are inserted there
+	var gaps []separatorGap
+	offset, tokens := 0, 0
+	previous, previousMode := 0, 0
 	stack.Push(root)
 	for !stack.IsEmpty() {
 		if w.exceededDeadline() {
@@ -857,11 +1040,20 @@ func (w *ATNWalker) TreeToString(root *RuleNode, stack *Stack[TreeNode]) string
 			stack.Push(children[i])
 		}
 		switch n := node.(type) {
+		case *SymbolNode:
+			if n.TokenType != 0 && !n.ended && w.separatorPolicy != NoSeparator {
+				if tokens > 0 {
+					gaps = append(gaps, separatorGap{offset, n.mode, previous, previousMode})
+				}
+				previous, previousMode = offset, n.mode
+				tokens++
+			}
 		case *LiteralNode:
 			builder.WriteRune(n.Text)
+			offset++
 		}
 	}
-	return builder.String()
+	return w.separate(builder.String(), gaps)
 }
 
 func (w *ATNWalker) Repair(data []byte) []byte {
@@ -870,7 +1062,7 @@ func (w *ATNWalker) Repair(data []byte) []byte {
 		len(w.Parser.GetATN().GetRuleIndexToStartStateSlice()),
 		len(w.Lexer.GetATN().GetRuleIndexToStartStateSlice()), writeBack)
 	stack := &Stack[TreeNode]{}
-	root := NewRuleNode(nil, w.Parser.GetATN().GetRuleIndexToStartStateSlice()[0])
+	root := NewRuleNode(nil, w.Parser.GetATN().GetRuleIndexToStartStateSlice()[w.startRuleIndex])
 	if !w.AssembleTree(decoder, root, stack) {
 		writeBack = nil
 		return []byte{}
@@ -884,7 +1076,7 @@ func (w *ATNWalker) Decode(data []byte, writeBack *[]byte) string {
 		len(w.Parser.GetATN().GetRuleIndexToStartStateSlice()),
 		len(w.Lexer.GetATN().GetRuleIndexToStartStateSlice()), writeBack)
 	stack := &Stack[TreeNode]{}
-	root := NewRuleNode(nil, w.Parser.GetATN().GetRuleIndexToStartStateSlice()[0])
+	root := NewRuleNode(nil, w.Parser.GetATN().GetRuleIndexToStartStateSlice()[w.startRuleIndex])
 	if !w.AssembleTree(decoder, root, stack) {
 		writeBack = nil
 		return ""
@@ -0,0 +1,319 @@
+package atnwalk
+
+import (
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// atnBuilder serializes a small ATN in the format of the ANTLR tool, i.e., tests do not depend on generated parsers
+type atnBuilder struct {
+	grammarType  int
+	maxTokenType int
+	states       []int32
+	rules        []int32
+	numRules     int
+	sets         []int32
+	numSets      int
+	edges        []int32
+	numEdges     int
+	modes        []int32
+	actions      []int32
+}
+
+// step is a transition of an alternative: the serialized transition type and its arguments
+type step [4]int
+
+func atom(label int) step {
+	return step{antlr.TransitionATOM, label, 0, 0}
+}
+
+func charRange(start, stop rune) step {
+	return step{antlr.TransitionRANGE, int(start), int(stop), 0}
+}
+
+func setOf(set int) step {
+	return step{antlr.TransitionSET, set, 0, 0}
+}
+
+func ruleRef(startState, ruleIndex int) step {
+	return step{antlr.TransitionRULE, startState, ruleIndex, 0}
+}
+
+func (b *atnBuilder) state(stateType, ruleIndex int) int {
+	b.states = append(b.states, int32(stateType), int32(ruleIndex))
+	return len(b.states)/2 - 1
+}
+
+// rule adds the start and stop state of the next rule, the token type is only used for lexers
+func (b *atnBuilder) rule(tokenType int) (startState, stopState int) {
+	ruleIndex := b.numRules
+	b.numRules++
+	startState = b.state(antlr.ATNStateRuleStart, ruleIndex)
+	stopState = b.state(antlr.ATNStateRuleStop, ruleIndex)
+	b.rules = append(b.rules, int32(startState))
+	if b.grammarType == antlr.ATNTypeLexer {
+		b.rules = append(b.rules, int32(tokenType))
+	}
+	return startState, stopState
+}
+
+func (b *atnBuilder) edge(src, trg int, s step) {
+	b.edges = append(b.edges, int32(src), int32(trg), int32(s[0]), int32(s[1]), int32(s[2]), int32(s[3]))
+	b.numEdges++
+}
+
+// alt adds an alternative of the rule that follows the steps from the start state to the stop state
+func (b *atnBuilder) alt(ruleIndex, startState, stopState int, steps ...step) {
+	state := b.state(antlr.ATNStateBasic, ruleIndex)
+	b.edge(startState, state, step{antlr.TransitionEPSILON})
+	for _, s := range steps {
+		// the target of a rule transition is its follow state
+		next := b.state(antlr.ATNStateBasic, ruleIndex)
+		b.edge(state, next, s)
+		state = next
+	}
+	b.edge(state, stopState, step{antlr.TransitionEPSILON})
+}
+
+// mode adds the start state of the next lexer mode that tries the rules of the start states in the given order
+func (b *atnBuilder) mode(ruleStartStates ...int) {
+	state := b.state(antlr.ATNStateTokenStart, -1)
+	for _, ruleStartState := range ruleStartStates {
+		b.edge(state, ruleStartState, step{antlr.TransitionEPSILON})
+	}
+	b.modes = append(b.modes, int32(state))
+}
+
+// command adds a lexer action of the rule and returns the step that executes it
+func (b *atnBuilder) command(ruleIndex, actionType, data int) step {
+	b.actions = append(b.actions, int32(actionType), int32(data), 0)
+	return step{antlr.TransitionACTION, ruleIndex, len(b.actions)/3 - 1, 0}
+}
+
+func (b *atnBuilder) set(elements ...int) int {
+	b.sets = append(b.sets, int32(len(elements)), 0)
+	for _, element := range elements {
+		b.sets = append(b.sets, int32(element), int32(element))
+	}
+	b.numSets++
+	return b.numSets - 1
+}
+
+func (b *atnBuilder) build() *antlr.ATN {
+	return b.buildWithOptions(nil)
+}
+
+func (b *atnBuilder) buildWithOptions(options *antlr.ATNDeserializationOptions) *antlr.ATN {
+	data := []int32{4, int32(b.grammarType), int32(b.maxTokenType), int32(len(b.states) / 2)}
+	data = append(data, b.states...)
+	data = append(data, 0, 0, int32(b.numRules))
+	data = append(data, b.rules...)
+	data = append(data, int32(len(b.modes)))
+	data = append(data, b.modes...)
+	data = append(data, int32(b.numSets))
+	data = append(data, b.sets...)
+	data = append(data, int32(b.numEdges))
+	data = append(data, b.edges...)
+	data = append(data, 0)
+	if b.grammarType == antlr.ATNTypeLexer {
+		data = append(data, int32(len(b.actions)/3))
+		data = append(data, b.actions...)
+	}
+	return antlr.NewATNDeserializer(options).Deserialize(data)
+}
+
+// testParser mimics a generated parser with a method per rule
+type testParser struct {
+	*antlr.BaseParser
+	atn *antlr.ATN
+}
+
+func (p *testParser) GetATN() *antlr.ATN {
+	return p.atn
+}
+
+func (p *testParser) Stmt() antlr.Tree {
+	return newTestContext(0)
+}
+
+func (p *testParser) Expr() antlr.Tree {
+	return newTestContext(1)
+}
+
+func newTestContext(ruleIndex int) antlr.Tree {
+	ctx := antlr.NewBaseParserRuleContext(nil, -1)
+	ctx.RuleIndex = ruleIndex
+	return ctx
+}
+
+type testLexer struct {
+	*antlr.BaseLexer
+	atn *antlr.ATN
+}
+
+func (l *testLexer) GetATN() *antlr.ATN {
+	return l.atn
+}
+
+// newTestGrammar returns the parser and the lexer of the grammar:
+//
+//	stmt : SELECT expr | ATTACH NAME | SELECT (NAME | NUM) ;
+//	expr : NAME | NUM ;
+//	SELECT : 's' ;
+//	ATTACH : 'a' ;
+//	NAME : [x-z] ;
+//	NUM : DIGIT ;
+//	fragment DIGIT : [0-9] ;
+func newTestGrammar() (*testParser, *testLexer) {
+	const SELECT, ATTACH, NAME, NUM = 1, 2, 3, 4
+
+	lexerBuilder := &atnBuilder{grammarType: antlr.ATNTypeLexer, maxTokenType: NUM}
+	var starts, stops [5]int
+	for i, tokenType := range []int{SELECT, ATTACH, NAME, NUM, 0} {
+		starts[i], stops[i] = lexerBuilder.rule(tokenType)
+	}
+	lexerBuilder.alt(0, starts[0], stops[0], atom('s'))
+	lexerBuilder.alt(1, starts[1], stops[1], atom('a'))
+	lexerBuilder.alt(2, starts[2], stops[2], charRange('x', 'z'))
+	lexerBuilder.alt(3, starts[3], stops[3], ruleRef(starts[4], 4))
+	lexerBuilder.alt(4, starts[4], stops[4], charRange('0', '9'))
+	lexer := &testLexer{antlr.NewBaseLexer(nil), lexerBuilder.build()}
+	lexer.RuleNames = []string{"SELECT", "ATTACH", "NAME", "NUM", "DIGIT"}
+
+	parserBuilder := &atnBuilder{grammarType: antlr.ATNTypeParser, maxTokenType: NUM}
+	stmtStart, stmtStop := parserBuilder.rule(0)
+	exprStart, exprStop := parserBuilder.rule(0)
+	parserBuilder.alt(0, stmtStart, stmtStop, atom(SELECT), ruleRef(exprStart, 1))
+	parserBuilder.alt(0, stmtStart, stmtStop, atom(ATTACH), atom(NAME))
+	parserBuilder.alt(0, stmtStart, stmtStop, atom(SELECT), setOf(parserBuilder.set(NAME, NUM)))
+	parserBuilder.alt(1, exprStart, exprStop, atom(NAME))
+	parserBuilder.alt(1, exprStart, exprStop, atom(NUM))
+	parser := &testParser{antlr.NewBaseParser(nil), parserBuilder.build()}
+	parser.RuleNames = []string{"stmt", "expr"}
+	return parser, lexer
+}
+
+// newUnicodeGrammar returns the parser and the lexer of the grammar:
+//
+//	text : WORD | WORD text ;
+//	WORD : CHAR | CHAR CHAR ;
+//	fragment CHAR : [α-ω] | 'ü' | '😀' | [€ß] ;
+func newUnicodeGrammar() (*testParser, *testLexer) {
+	lexerBuilder := &atnBuilder{grammarType: antlr.ATNTypeLexer, maxTokenType: 1}
+	wordStart, wordStop := lexerBuilder.rule(1)
+	charStart, charStop := lexerBuilder.rule(0)
+	lexerBuilder.alt(0, wordStart, wordStop, ruleRef(charStart, 1))
+	lexerBuilder.alt(0, wordStart, wordStop, ruleRef(charStart, 1), ruleRef(charStart, 1))
+	lexerBuilder.alt(1, charStart, charStop, charRange('α', 'ω'))
+	lexerBuilder.alt(1, charStart, charStop, atom('ü'))
+	lexerBuilder.alt(1, charStart, charStop, atom('😀'))
+	lexerBuilder.alt(1, charStart, charStop, setOf(lexerBuilder.set('€', 'ß')))
+	lexer := &testLexer{antlr.NewBaseLexer(nil), lexerBuilder.build()}
+	lexer.RuleNames = []string{"WORD", "CHAR"}
+
+	parserBuilder := &atnBuilder{grammarType: antlr.ATNTypeParser, maxTokenType: 1}
+	textStart, textStop := parserBuilder.rule(0)
+	parserBuilder.alt(0, textStart, textStop, atom(1))
+	parserBuilder.alt(0, textStart, textStop, atom(1), ruleRef(textStart, 0))
+	parser := &testParser{antlr.NewBaseParser(nil), parserBuilder.build()}
+	parser.RuleNames = []string{"text"}
+	return parser, lexer
+}
+
+// decodeTree decodes the data like Decode and also returns the parse tree of the output, i.e., the tree that a parser
+// generated by ANTLR would produce
+func decodeTree(walker *ATNWalker, data []byte) (string, antlr.Tree) {
+	decoder := NewDecoder(data,
+		len(walker.Parser.GetATN().GetRuleIndexToStartStateSlice()),
+		len(walker.Lexer.GetATN().GetRuleIndexToStartStateSlice()), nil)
+	root := NewRuleNode(nil, walker.Parser.GetATN().GetRuleIndexToStartStateSlice()[walker.startRuleIndex])
+	if !walker.AssembleTree(decoder, root, nil) {
+		return "", nil
+	}
+	return walker.TreeToString(root, &Stack[TreeNode]{}), toParseTree(root)
+}
+
+// decodeRandom decodes random data with a new walker each time and returns the distinct outputs
+func decodeRandom(t *testing.T, newWalker func() *ATNWalker, n int) map[string]struct{} {
+	prng := rand.New(rand.NewSource(1))
+	outputs := map[string]struct{}{}
+	for i := 0; i < n; i++ {
+		data := make([]byte, 1+prng.Intn(8))
+		prng.Read(data)
+		walker := newWalker()
+		walker.SetDeadline(time.Now().Add(time.Second))
+		output := walker.Decode(data, nil)
+		if walker.TimedOut() {
+			t.Fatalf("Decode(%v) timed out", data)
+		}
+		outputs[output] = struct{}{}
+	}
+	return outputs
+}
+
+func TestATNWalker_Decode(t *testing.T) {
+	parser, lexer := newTestGrammar()
+	outputs := decodeRandom(t, func() *ATNWalker { return NewATNWalker(parser, lexer) }, 2000)
+	for _, want := range []string{"sx", "sy", "sz", "s0", "s9", "ax", "az"} {
+		if _, ok := outputs[want]; !ok {
+			t.Errorf("Decode() never produced %q", want)
+		}
+	}
+	for output := range outputs {
+		if len(output) != 2 {
+			t.Errorf("Decode() produced %q which is not in the language", output)
+		}
+	}
+}
+
+func TestATNWalker_EncodeUnicode(t *testing.T) {
+	parser, lexer := newUnicodeGrammar()
+	prng := rand.New(rand.NewSource(1))
+	nonASCII := 0
+	for i := 0; i < 200; i++ {
+		data := make([]byte, 1+prng.Intn(16))
+		prng.Read(data)
+		walker := NewATNWalker(parser, lexer)
+		walker.SetDeadline(time.Now().Add(time.Second))
+		text, tree := decodeTree(walker, data)
+		if tree == nil {
+			t.Fatalf("decodeTree(%v) timed out", data)
+		}
+		if len(text) > len([]rune(text)) {
+			nonASCII++
+		}
+
+		// every output of the decoder can be encoded again and decodes to the same output
+		encoded := NewATNWalker(parser, lexer).Encode(tree)
+		if decoded := NewATNWalker(parser, lexer).Decode(encoded, nil); decoded != text {
+			t.Errorf("Decode(Encode(%q)) = %q", text, decoded)
+		}
+	}
+	if nonASCII == 0 {
+		t.Errorf("Decode() never produced multi-byte UTF-8")
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	parser, _ := newTestGrammar()
+	parser.RuleNames = []string{"stmt", "expr", "literal"}
+
+	for name, want := range map[string]int{"": 0, "stmt": 0, "expr": 1} {
+		tree, err := ParseRule(parser, name)
+		if err != nil {
+			t.Errorf("ParseRule(%q) failed: %v", name, err)
+			continue
+		}
+		if index := tree.(antlr.RuleContext).GetRuleIndex(); index != want {
+			t.Errorf("ParseRule(%q) parsed rule %d, want %d", name, index, want)
+		}
+	}
+	if _, err := ParseRule(parser, "literal"); err == nil {
+		t.Errorf("ParseRule() of a rule without a method should fail")
+	}
+	if _, err := ParseRule(parser, "unknown"); err == nil {
+		t.Errorf("ParseRule() of an unknown rule should fail")
+	}
+}
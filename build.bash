@@ -54,94 +54,95 @@ function generate_antlr_go_files() {
   cd - > /dev/null
 }
 
+function insert_grammar_code() {
+  # inserts the import lines (${3}) and the executable lines (${4}) for the grammar (${1}) into the main.go file of the
+  # command (${2}) at the lines marked with 'DO NOT REMOVE THIS LINE - IMPORT' and 'DO NOT REMOVE THIS LINE - EXEC'
+  local src="${SCRIPT_DIR}"/cmd/"${2}"/main.go
+  local dst="${SCRIPT_DIR}"/build/"${1,,}"/gen/cmd/"${2}"/main.go
+  cat <(sed -E '/^[ \t]*\/\/\ DO\ NOT\ REMOVE\ THIS\ LINE\ \-\ IMPORT[ \t]*$/q' "${src}") <(echo "${3}") \
+    <(sed -n -E '/^[ \t]*\/\/\ DO\ NOT\ REMOVE\ THIS\ LINE\ \-\ IMPORT[ \t]*$/,/^[ \t]*\/\/\ DO\ NOT\ REMOVE\ THIS\ LINE\ \-\ EXEC[ \t]*$/p' "${src}" | sed '1d') \
+    <(echo "${4}") <(sed -E '0,/^[ \t]*\/\/\ DO\ NOT\ REMOVE\ THIS\ LINE\ \-\ EXEC[ \t]*$/d' "${src}") > "${dst}"
+  go fmt "${dst}" > /dev/null
+}
+
 function generate_atnwalk_go_files() {
   echo "[ ${1} ] Generating Go files for atnwalk"
   mkdir -p "${SCRIPT_DIR}"/build/"${1,,}"/{gen,bin}/
   cp -r cmd "${SCRIPT_DIR}"/build/"${1,,}"/gen/
 
-  ###############################################
-  # build/<grammar_name>/gen/cmd/decode/main.go #
-  ###############################################
-  cat <(sed -E '/^[ \t]*\/\/\ DO\ NOT\ REMOVE\ THIS\ LINE\ \-\ IMPORT[ \t]*$/q' "${SCRIPT_DIR}"/cmd/decode/main.go) <(cat <<EOF
-parser "atnwalk/build/${1,,}/gen"
-EOF
-) <(sed -n -E '/^[ \t]*\/\/\ DO\ NOT\ REMOVE\ THIS\ LINE\ \-\ IMPORT[ \t]*$/,/^[ \t]*\/\/\ DO\ NOT\ REMOVE\ THIS\ LINE\ \-\ EXEC[ \t]*$/p' "${SCRIPT_DIR}"/cmd/decode/main.go | sed '1d') <(cat <<EOF
+  ################################################################################
+  # build/<grammar_name>/gen/cmd/{decode,server,aflmutator,fuzz,generate}/main.go #
+  ################################################################################
+  for cmd in decode server aflmutator fuzz generate
+  do
+    insert_grammar_code "${1}" "${cmd}" "parser \"atnwalk/build/${1,,}/gen\"" "$(cat <<EOF
 parser_ = parser.New${1}Parser(nil)
 lexer = parser.New${1}Lexer(nil)
 EOF
-  ) <(sed -E '0,/^[ \t]*\/\/\ DO\ NOT\ REMOVE\ THIS\ LINE\ \-\ EXEC[ \t]*$/d' "${SCRIPT_DIR}"/cmd/decode/main.go) > "${SCRIPT_DIR}"/build/"${1,,}"/gen/cmd/decode/main.go
-  go fmt "${SCRIPT_DIR}"/build/"${1,,}"/gen/cmd/decode/main.go > /dev/null
+    )"
+  done
 
   ###############################################
   # build/<grammar_name>/gen/cmd/encode/main.go #
   ###############################################
-  cat <(sed -E '/^[ \t]*\/\/\ DO\ NOT\ REMOVE\ THIS\ LINE\ \-\ IMPORT[ \t]*$/q' "${SCRIPT_DIR}"/cmd/encode/main.go) <(cat <<EOF
-parser "atnwalk/build/${1,,}/gen"
-"atnwalk"
-EOF
-  ) <(sed -n -E '/^[ \t]*\/\/\ DO\ NOT\ REMOVE\ THIS\ LINE\ \-\ IMPORT[ \t]*$/,/^[ \t]*\/\/\ DO\ NOT\ REMOVE\ THIS\ LINE\ \-\ EXEC[ \t]*$/p' "${SCRIPT_DIR}"/cmd/encode/main.go | sed '1d') <(cat <<EOF
-// create the input and token streams, lexer, and parser instances
-input := antlr.NewInputStream(string(data))
-lexer := parser.New${1}Lexer(input)
-stream := antlr.NewCommonTokenStream(lexer, antlr.TokenDefaultChannel)
-parser_ := parser.New${1}Parser(stream)
-
-// call the main rule that shall be parsed
-tree := parser_.Start()
-
-// create an ATNWalker
-walker := atnwalk.NewATNWalker(parser_, lexer)
-os.Stdout.Write(walker.Encode(tree))
-
-// this is just a safety measure to make sure that both, lexer and parser, have been properly initialized
-_parser_ = parser_
-_lexer = lexer
+  insert_grammar_code "${1}" encode "parser \"atnwalk/build/${1,,}/gen\"" "$(cat <<EOF
+_parser_ = parser.New${1}Parser(nil)
+_lexer = parser.New${1}Lexer(nil)
+parse = func(text, startRule string) (antlr.Parser, antlr.Lexer, antlr.Tree, error) {
+	lexer := parser.New${1}Lexer(antlr.NewInputStream(text))
+	parser_ := parser.New${1}Parser(antlr.NewCommonTokenStream(lexer, antlr.TokenDefaultChannel))
+	if auto {
+		// the syntax errors of the candidates that do not match are expected
+		lexer.RemoveErrorListeners()
+		parser_.RemoveErrorListeners()
+	}
+	tree, err := atnwalk.ParseRule(parser_, startRule)
+	return parser_, lexer, tree, err
+}
 EOF
-    ) <(sed -E '0,/^[ \t]*\/\/\ DO\ NOT\ REMOVE\ THIS\ LINE\ \-\ EXEC[ \t]*$/d' "${SCRIPT_DIR}"/cmd/encode/main.go) > "${SCRIPT_DIR}"/build/"${1,,}"/gen/cmd/encode/main.go
-    go fmt "${SCRIPT_DIR}"/build/"${1,,}"/gen/cmd/encode/main.go > /dev/null
+  )"
 
-  ###############################################
-  # build/<grammar_name>/gen/cmd/server/main.go #
-  ###############################################
-  cat <(sed -E '/^[ \t]*\/\/\ DO\ NOT\ REMOVE\ THIS\ LINE\ \-\ IMPORT[ \t]*$/q' "${SCRIPT_DIR}"/cmd/server/main.go) <(cat <<EOF
-parser "atnwalk/build/${1,,}/gen"
-EOF
-) <(sed -n -E '/^[ \t]*\/\/\ DO\ NOT\ REMOVE\ THIS\ LINE\ \-\ IMPORT[ \t]*$/,/^[ \t]*\/\/\ DO\ NOT\ REMOVE\ THIS\ LINE\ \-\ EXEC[ \t]*$/p' "${SCRIPT_DIR}"/cmd/server/main.go | sed '1d') <(cat <<EOF
+  #######################################################
+  # build/<grammar_name>/gen/cmd/{learn,verify}/main.go #
+  #######################################################
+  for cmd in learn verify
+  do
+    insert_grammar_code "${1}" "${cmd}" "parser \"atnwalk/build/${1,,}/gen\"" "$(cat <<EOF
 parser_ = parser.New${1}Parser(nil)
 lexer = parser.New${1}Lexer(nil)
+parse = func(text, startRule string) (antlr.Parser, antlr.Lexer, antlr.Tree, error) {
+	lexer := parser.New${1}Lexer(antlr.NewInputStream(text))
+	parser_ := parser.New${1}Parser(antlr.NewCommonTokenStream(lexer, antlr.TokenDefaultChannel))
+	tree, err := atnwalk.ParseRule(parser_, startRule)
+	return parser_, lexer, tree, err
+}
 EOF
-  ) <(sed -E '0,/^[ \t]*\/\/\ DO\ NOT\ REMOVE\ THIS\ LINE\ \-\ EXEC[ \t]*$/d' "${SCRIPT_DIR}"/cmd/server/main.go) > "${SCRIPT_DIR}"/build/"${1,,}"/gen/cmd/server/main.go
-  go fmt "${SCRIPT_DIR}"/build/"${1,,}"/gen/cmd/server/main.go > /dev/null
+    )"
+  done
 }
 
 function run_go_build() {
   echo "[ ${1} ] Running go build commands"
-  go build -o "${SCRIPT_DIR}"/build/"${1,,}"/bin/client "${SCRIPT_DIR}"/build/"${1,,}"/gen/cmd/client/main.go
-  go build -o "${SCRIPT_DIR}"/build/"${1,,}"/bin/decode "${SCRIPT_DIR}"/build/"${1,,}"/gen/cmd/decode/main.go
-  go build -o "${SCRIPT_DIR}"/build/"${1,,}"/bin/encode "${SCRIPT_DIR}"/build/"${1,,}"/gen/cmd/encode/main.go
-  go build -o "${SCRIPT_DIR}"/build/"${1,,}"/bin/mutate "${SCRIPT_DIR}"/build/"${1,,}"/gen/cmd/mutate/main.go
-  go build -o "${SCRIPT_DIR}"/build/"${1,,}"/bin/server "${SCRIPT_DIR}"/build/"${1,,}"/gen/cmd/server/main.go
-}
-
-function runtests() {
-  for i in {001..10000}
+  for cmd in "${SCRIPT_DIR}"/build/"${1,,}"/gen/cmd/*/
   do
-    head -c8 /dev/urandom | tee blob.bytes | ./decode -wb > decoded.txt 2> wb.encoded.bytes
-    cat decoded.txt | ../atnencode/encode > orig.encoded.bytes
-    printf "\r                    \r"
-    printf "\r${i}: "$(ls -l decoded.txt | awk '{print $5}')
-    diff <(cat wb.encoded.bytes | ./decode) <(cat orig.encoded.bytes | ./decode)
-    if [[ $? -ne 0 ]]; then
-      echo "ERROR"
-      break;
-    fi
-    diff <(cat wb.encoded.bytes | ./decode) decoded.txt
-    if [[ $? -ne 0 ]]; then
-      echo "ERROR"
-      break;
+    if [[ "$(basename "${cmd}")" == "aflmutator" ]]; then
+      # the AFL++ custom mutator is a shared library (requires cgo)
+      go build -buildmode=c-shared -o "${SCRIPT_DIR}"/build/"${1,,}"/bin/libatnwalk.so "${cmd}"main.go
+      rm -f "${SCRIPT_DIR}"/build/"${1,,}"/bin/libatnwalk.h
+    else
+      go build -o "${SCRIPT_DIR}"/build/"${1,,}"/bin/"$(basename "${cmd}")" "${cmd}"main.go
     fi
   done
-  printf "\n"
+}
+
+function run_verify() {
+  # checks decode->encode->decode stability and repair idempotence with random inputs if VERIFY is set to the number
+  # of inputs, e.g., VERIFY=10000 ./build.bash
+  if [[ -z ${VERIFY+x} ]]; then
+    return
+  fi
+  echo "[ ${1} ] Verifying the grammar with ${VERIFY} random inputs"
+  "${SCRIPT_DIR}"/build/"${1,,}"/bin/verify -n "${VERIFY}"
 }
 
 function main() {
@@ -159,7 +160,7 @@ function main() {
     generate_antlr_go_files "${f}"
     generate_atnwalk_go_files "${f}"
     run_go_build "${f}"
-    # TODO: runtests
+    run_verify "${f}"
   done
 }
 
@@ -0,0 +1,30 @@
+package atnwalk
+
+import (
+	"sync"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+// atnCache computes a model of an ATN once and shares it between walkers, e.g., the end model of a parser ATN.
+type atnCache[T any] struct {
+	mutex   sync.Mutex
+	models  map[*antlr.ATN]T
+	compute func(atn *antlr.ATN) T
+}
+
+func newATNCache[T any](compute func(atn *antlr.ATN) T) *atnCache[T] {
+	return &atnCache[T]{models: map[*antlr.ATN]T{}, compute: compute}
+}
+
+// get returns the model of the ATN, it is computed on first use.
+func (c *atnCache[T]) get(atn *antlr.ATN) T {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if model, ok := c.models[atn]; ok {
+		return model
+	}
+	model := c.compute(atn)
+	c.models[atn] = model
+	return model
+}
@@ -0,0 +1,295 @@
+package main
+
+/*
+	AFL++ custom mutator library with the grammar compiled in, build it with:
+
+	go build -buildmode=c-shared -o libatnwalk.so main.go
+
+	and load it with AFL_CUSTOM_MUTATOR_LIBRARY=libatnwalk.so AFL_CUSTOM_MUTATOR_ONLY=1 afl-fuzz ...
+	AFL++ stores the encoded data in its queue, the target receives the decoded data from afl_custom_post_process.
+
+	Each grammar needs its own parser and lexer initialization which includes the import of the parser package.
+	We do this by searching for 'DO NOT REMOVE THIS LINE' and insert the lines below with a Bash script.
+
+	E.g., for SQLite, we need to insert these subsequent lines:
+
+	parser "atnwalk/out/gen/sqlite"
+*/
+
+// #include <stdint.h>
+// #include <stdlib.h>
+import "C"
+import (
+	// DO NOT REMOVE THIS LINE - IMPORT
+	"atnwalk"
+	"fmt"
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+const (
+	// environment variables to configure the mutator since AFL++ passes no arguments
+	TimeoutEnv    = "ATNWALK_TIMEOUT"
+	StrategyEnv   = "ATNWALK_STRATEGY"
+	WeightsEnv    = "ATNWALK_WEIGHTS"
+	StartRuleEnv  = "ATNWALK_START_RULE"
+	ExclusionsEnv = "ATNWALK_EXCLUSIONS"
+	AlphabetEnv   = "ATNWALK_ALPHABET"
+	SeparatorEnv  = "ATNWALK_SEPARATOR"
+
+	DefaultTimeout = 500
+
+	// number of recent inputs kept as crossover partners since AFL++ passes no splice inputs (see splice_optout)
+	PartnerPoolSize = 64
+)
+
+var parser_ antlr.Parser
+var lexer antlr.Lexer
+
+// mutator is the state of one afl_custom_init call, the buffers are allocated with malloc because AFL++ reads them
+// after the functions returned (until the next call)
+type mutator struct {
+	prng       *rand.Rand
+	timeout    time.Duration
+	strategy   atnwalk.RoutingStrategy
+	startRule  string
+	fuzzBuf    *C.uint8_t
+	fuzzCap    int
+	postBuf    *C.uint8_t
+	postCap    int
+	trimBuf    *C.uint8_t
+	trimCap    int
+	trimmed    []byte
+	candidates [][]byte
+	step       int
+	partners   [][]byte
+}
+
+// Go pointers must not be passed to C, AFL++ gets a malloc'd handle that refers to the mutator instead
+var mutators = map[unsafe.Pointer]*mutator{}
+var mutatorsMutex sync.Mutex
+
+func getMutator(data unsafe.Pointer) *mutator {
+	mutatorsMutex.Lock()
+	defer mutatorsMutex.Unlock()
+	return mutators[data]
+}
+
+var grammarOnce sync.Once
+
+// initGrammar initializes the parser and the lexer once for all mutators.
+func initGrammar() {
+	grammarOnce.Do(func() {
+		/*
+			Each grammar needs its own parser and lexer initialization.
+			We do this by searching for 'DO NOT REMOVE THIS LINE' and insert the lines below with a Bash script.
+
+			E.g., for SQLite, we need to insert these subsequent lines:
+
+			parser_ = parser.NewSQLiteParser(nil)
+			lexer = parser.NewSQLiteLexer(nil)
+		*/
+
+		// DO NOT REMOVE THIS LINE - EXEC
+
+		if parser_ == nil || lexer == nil {
+			panic(fmt.Errorf("parser_ or lexer are nil, make sure to insert the appropriate parser and lexer " +
+				"initialization into the code; inspect the comment above this panic statement in the code"))
+		}
+	})
+}
+
+// output copies the data into the malloc'd buffer, the buffer grows as needed.
+func output(buf **C.uint8_t, capacity *int, data []byte) C.size_t {
+	if len(data) > *capacity || *buf == nil {
+		C.free(unsafe.Pointer(*buf))
+		*capacity = len(data) + 1
+		*buf = (*C.uint8_t)(C.malloc(C.size_t(*capacity)))
+	}
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(*buf)), *capacity), data)
+	return C.size_t(len(data))
+}
+
+// input returns the encoded input without the record of the start rule (see WithStartRule), AFL++ passes the seeds as
+// they are. It returns false for seeds that record another start rule, they decode differently than they were encoded
+// and are skipped (AFL++ hides the output of the mutator, i.e., reporting them is pointless).
+func (m *mutator) input(buf *C.uint8_t, size C.size_t) ([]byte, bool) {
+	stripped, err := m.newWalker().StripStartRule(C.GoBytes(unsafe.Pointer(buf), C.int(size)))
+	return stripped, err == nil
+}
+
+func (m *mutator) newWalker() *atnwalk.ATNWalker {
+	walker := atnwalk.NewATNWalker(parser_, lexer)
+	walker.SetRoutingStrategy(m.strategy)
+	walker.SetStartRule(m.startRule)
+	walker.SetDeadline(time.Now().Add(m.timeout))
+	return walker
+}
+
+//export afl_custom_init
+func afl_custom_init(afl unsafe.Pointer, seed C.uint) unsafe.Pointer {
+	initGrammar()
+	timeout, err := strconv.Atoi(os.Getenv(TimeoutEnv))
+	if err != nil {
+		timeout = DefaultTimeout
+	}
+	strategy, err := atnwalk.ParseRoutingStrategy(os.Getenv(StrategyEnv), os.Getenv(WeightsEnv), parser_, lexer)
+	if err == nil {
+		strategy, err = atnwalk.WithExclusions(strategy, os.Getenv(ExclusionsEnv), parser_, lexer)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "atnwalk mutator: %v\n", err)
+		return nil
+	}
+
+	if spec := os.Getenv(AlphabetEnv); spec != "" {
+		if atnwalk.DefaultAlphabet, err = atnwalk.ParseAlphabet(spec); err != nil {
+			fmt.Fprintf(os.Stderr, "atnwalk mutator: %v\n", err)
+			return nil
+		}
+	}
+
+	if atnwalk.DefaultSeparatorPolicy, err = atnwalk.ParseSeparatorPolicy(os.Getenv(SeparatorEnv)); err != nil {
+		fmt.Fprintf(os.Stderr, "atnwalk mutator: %v\n", err)
+		return nil
+	}
+
+	startRule := os.Getenv(StartRuleEnv)
+	if err = atnwalk.NewATNWalker(parser_, lexer).SetStartRule(startRule); err != nil {
+		fmt.Fprintf(os.Stderr, "atnwalk mutator: %v\n", err)
+		return nil
+	}
+
+	handle := C.malloc(1)
+	mutatorsMutex.Lock()
+	mutators[handle] = &mutator{prng: rand.New(rand.NewSource(int64(seed))),
+		timeout: time.Duration(timeout) * time.Millisecond, strategy: strategy, startRule: startRule}
+	mutatorsMutex.Unlock()
+	return handle
+}
+
+//export afl_custom_deinit
+func afl_custom_deinit(data unsafe.Pointer) {
+	mutatorsMutex.Lock()
+	m := mutators[data]
+	delete(mutators, data)
+	mutatorsMutex.Unlock()
+	if m != nil {
+		C.free(unsafe.Pointer(m.fuzzBuf))
+		C.free(unsafe.Pointer(m.postBuf))
+		C.free(unsafe.Pointer(m.trimBuf))
+	}
+	C.free(data)
+}
+
+// afl_custom_fuzz crosses the input over with the splice input or a recent input (if any), mutates it, and repairs
+// the result.
+//
+//export afl_custom_fuzz
+func afl_custom_fuzz(data unsafe.Pointer, buf *C.uint8_t, bufSize C.size_t, outBuf **C.uint8_t, addBuf *C.uint8_t,
+	addBufSize C.size_t, maxSize C.size_t) C.size_t {
+	m := getMutator(data)
+	input, ok := m.input(buf, bufSize)
+	if !ok {
+		// AFL++ skips the mutation if no data is returned
+		return 0
+	}
+	result := input
+	if addBuf != nil && addBufSize > 0 {
+		// a splice input that records another start rule is not crossed over with
+		if splice, ok := m.input(addBuf, addBufSize); ok {
+			result = atnwalk.Crossover(result, splice, m.prng.Int63())
+		}
+	} else if len(m.partners) > 0 && m.prng.Intn(2) == 0 {
+		result = atnwalk.Crossover(result, m.partners[m.prng.Intn(len(m.partners))], m.prng.Int63())
+	}
+	if len(m.partners) < PartnerPoolSize {
+		m.partners = append(m.partners, input)
+	} else {
+		m.partners[m.prng.Intn(PartnerPoolSize)] = input
+	}
+	result = atnwalk.Mutate(result, m.prng.Int63())
+	if repaired := m.newWalker().Repair(result); len(repaired) > 0 {
+		result = repaired
+	}
+	if len(result) > int(maxSize) {
+		result = result[:maxSize]
+	}
+	n := output(&m.fuzzBuf, &m.fuzzCap, result)
+	*outBuf = m.fuzzBuf
+	return n
+}
+
+// afl_custom_post_process decodes the input before it is passed to the target.
+//
+//export afl_custom_post_process
+func afl_custom_post_process(data unsafe.Pointer, buf *C.uint8_t, bufSize C.size_t, outBuf **C.uint8_t) C.size_t {
+	m := getMutator(data)
+	input, ok := m.input(buf, bufSize)
+	if !ok {
+		// AFL++ does not execute the target if no data is returned
+		return 0
+	}
+	decoded := m.newWalker().Decode(input, nil)
+	n := output(&m.postBuf, &m.postCap, []byte(decoded))
+	*outBuf = m.postBuf
+	return n
+}
+
+// afl_custom_init_trim repairs the input and prepares shorter candidates, i.e., repaired prefixes, that are tried as
+// long as they are shorter than the input that AFL++ accepted last.
+//
+//export afl_custom_init_trim
+func afl_custom_init_trim(data unsafe.Pointer, buf *C.uint8_t, bufSize C.size_t) C.int32_t {
+	m := getMutator(data)
+	m.candidates = m.candidates[:0]
+	m.step = 0
+	var ok bool
+	if m.trimmed, ok = m.input(buf, bufSize); !ok {
+		// there are no candidates to trim the input with
+		return 0
+	}
+	if repaired := m.newWalker().Repair(m.trimmed); len(repaired) > 0 && len(repaired) < len(m.trimmed) {
+		m.candidates = append(m.candidates, repaired)
+	}
+	for n := len(m.trimmed) / 2; n > 0; n /= 2 {
+		if repaired := m.newWalker().Repair(m.trimmed[:n]); len(repaired) > 0 && len(repaired) < len(m.trimmed) {
+			m.candidates = append(m.candidates, repaired)
+		}
+	}
+	return C.int32_t(len(m.candidates))
+}
+
+//export afl_custom_trim
+func afl_custom_trim(data unsafe.Pointer, outBuf **C.uint8_t) C.size_t {
+	m := getMutator(data)
+	n := output(&m.trimBuf, &m.trimCap, m.candidates[m.step])
+	*outBuf = m.trimBuf
+	return n
+}
+
+//export afl_custom_post_trim
+func afl_custom_post_trim(data unsafe.Pointer, success C.uint8_t) C.int32_t {
+	m := getMutator(data)
+	if success != 0 {
+		m.trimmed = m.candidates[m.step]
+	}
+	// skip the candidates that are not shorter than the accepted one
+	m.step++
+	for m.step < len(m.candidates) && len(m.candidates[m.step]) >= len(m.trimmed) {
+		m.step++
+	}
+	return C.int32_t(m.step)
+}
+
+// afl_custom_splice_optout tells AFL++ not to splice, the crossover of encoded data happens in afl_custom_fuzz.
+//
+//export afl_custom_splice_optout
+func afl_custom_splice_optout(data unsafe.Pointer) {}
+
+func main() {}
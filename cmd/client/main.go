@@ -3,24 +3,56 @@ package main
 import (
 	"atnwalk"
 	"bufio"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
 	"time"
 )
 
-const SocketFile = "./atnwalk.socket"
+const (
+	// exit status if the server could not be reached, distinct from any status the Go runtime uses (2 for panics)
+	ExitServerUnavailable = 75
+	// exit status per response status of the server
+	ExitTimeout          = 76
+	ExitBadRequest       = 77
+	ExitInternalError    = 78
+	ExitBrokenConnection = 79
+	MinBackoff           = 10 * time.Millisecond
+	MaxBackoff           = time.Second
+	// the largest timeout in ms that is accepted
+	MaxTimeout = 60000
+)
 
 func main() {
 	var data1, data2 []byte
 	var seedCrossover, seedMutation uint64
 	var err error
 	var wanted byte = 0
+	wantStats := false
 	timeout := 500
-	for i, arg := range os.Args[1:] {
-		i += 1
-		switch arg {
+	maxAttempts := 10
+	serverBin := ""
+	var serverArgs []string
+	config, err := atnwalk.NewConfigFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	for i := 1; i < len(os.Args); i++ {
+		if n, err := config.ParseArg(os.Args, i); err != nil {
+			panic(err)
+		} else if n > 0 {
+			i += n - 1
+			continue
+		}
+		if os.Args[i] == "--" {
+			// all remaining arguments are passed to the server that is started with '-S'
+			serverArgs = os.Args[i+1:]
+			break
+		}
+		switch os.Args[i] {
 		case "-c":
 			wanted |= atnwalk.CrossoverBit
 			if len(os.Args[i+1:]) < 3 {
@@ -38,6 +70,7 @@ func main() {
 			if err != nil {
 				panic("Could not parse uint64: " + os.Args[i+3] + " (need: SEED)")
 			}
+			i += 3
 		case "-m":
 			wanted |= atnwalk.MutateBit
 			if len(os.Args[i+1:]) < 1 {
@@ -47,16 +80,60 @@ func main() {
 			if err != nil {
 				panic("Could not parse uint64: " + os.Args[i+1] + " (need: SEED)")
 			}
+			i++
 		case "-d":
 			wanted |= atnwalk.DecodeBit
 		case "-e":
 			wanted |= atnwalk.EncodeBit
+		case "-s":
+			wantStats = true
+		case "-n":
+			if len(os.Args[i+1:]) < 1 {
+				panic("Not enough arguments for '-n' option, need: ATTEMPTS")
+			}
+			maxAttempts, err = strconv.Atoi(os.Args[i+1])
+			if err != nil {
+				panic("Could not parse int: " + os.Args[i+1] + " (need: ATTEMPTS, 0 retries forever)")
+			}
+			i++
+		case "-S":
+			if len(os.Args[i+1:]) < 1 {
+				panic("Not enough arguments for '-S' option, need: SERVER_BIN")
+			}
+			// resolve the path before changing the working directory
+			serverBin, err = filepath.Abs(os.Args[i+1])
+			if err != nil {
+				panic(err)
+			}
+			i++
 		case "-t":
+			if len(os.Args[i+1:]) < 1 {
+				panic("Not enough arguments for '-t' option, need: TIMEOUT")
+			}
 			timeout, err = strconv.Atoi(os.Args[i+1])
 			if err != nil {
 				panic("Could not parse int: " + os.Args[i+1] + " (need: TIMEOUT in ms)")
 			}
+			if timeout <= 0 || timeout > MaxTimeout {
+				panic("Timeout out of range: " + os.Args[i+1] + " (need: TIMEOUT in ms, 1 to " +
+					strconv.Itoa(MaxTimeout) + ")")
+			}
+			i++
+		}
+	}
+
+	if err := config.Chdir(); err != nil {
+		panic(err)
+	}
+
+	if wantStats {
+		stats, ok := atnwalk.SendStatsRequest(config.Address, timeout)
+		if !ok {
+			fmt.Fprintln(os.Stderr, "Could not obtain the statistics from the server")
+			os.Exit(1)
 		}
+		os.Stdout.Write(stats)
+		os.Exit(0)
 	}
 
 	if data1 == nil {
@@ -68,24 +145,58 @@ func main() {
 	}
 	encoded, decoded := &([]byte{}), &([]byte{})
 
-	for !atnwalk.SendRequest(SocketFile, timeout, data1, data2, wanted, seedCrossover, seedMutation, encoded, decoded) {
-		// atnwalk.RestartServer(LockFile, ServerBin)
-		time.Sleep(50 * time.Millisecond)
+	// retry with exponential backoff, optionally starting the server if none is running
+	backoff := MinBackoff
+	var status byte
+	for attempt := 1; ; attempt++ {
+		var ok bool
+		if config.Transport == atnwalk.SharedMemoryTransport {
+			status, ok = atnwalk.SendSharedMemoryRequest(config.ShmFile(), timeout, data1, data2, wanted, seedCrossover,
+				seedMutation, encoded, decoded)
+		} else {
+			status, ok = atnwalk.SendRequest(config.Address, timeout, data1, data2, wanted, seedCrossover,
+				seedMutation, encoded, decoded)
+		}
+		if ok {
+			break
+		}
+		if maxAttempts > 0 && attempt >= maxAttempts {
+			fmt.Fprintf(os.Stderr, "atnwalk client: server at %s unavailable after %d attempts\n", config.Address, attempt)
+			os.Exit(ExitServerUnavailable)
+		}
+		if serverBin != "" {
+			// the server's timeout precedes the passed arguments such that these may override it
+			args := append(append(config.ServerArgs(), strconv.Itoa(timeout)), serverArgs...)
+			if err = atnwalk.StartServer(config.PidFile, config.LockFile(), serverBin, args...); err != nil {
+				fmt.Fprintf(os.Stderr, "atnwalk client: could not start %s: %v\n", serverBin, err)
+			}
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > MaxBackoff {
+			backoff = MaxBackoff
+		}
 	}
 
-	if wanted&atnwalk.DecodeBit > 0 {
-		if decoded == nil || len(*decoded) == 0 {
-			os.Stdout.Write([]byte{0})
-		} else {
-			os.Stdout.Write(*decoded)
+	if status != atnwalk.StatusOK {
+		fmt.Fprintf(os.Stderr, "atnwalk client: server at %s responded %s\n", config.Address, atnwalk.StatusText(status))
+		switch status {
+		case atnwalk.StatusTimeout:
+			os.Exit(ExitTimeout)
+		case atnwalk.StatusBadRequest:
+			os.Exit(ExitBadRequest)
+		case atnwalk.StatusInternalError:
+			os.Exit(ExitInternalError)
+		default:
+			os.Exit(ExitBrokenConnection)
 		}
 	}
 
+	if wanted&atnwalk.DecodeBit > 0 {
+		// empty output is written as nothing, e.g., the decoded text of an empty rule
+		os.Stdout.Write(*decoded)
+	}
+
 	if wanted&atnwalk.CrossoverBit > 0 || wanted&atnwalk.MutateBit > 0 || wanted&atnwalk.EncodeBit > 0 {
-		if encoded == nil || len(*encoded) == 0 {
-			os.Stderr.Write([]byte{0})
-		} else {
-			os.Stderr.Write(*encoded)
-		}
+		os.Stderr.Write(*encoded)
 	}
 }
@@ -32,8 +32,51 @@ func main() {
 	}
 
 	var writeBack *[]byte
-	if len(os.Args) > 1 && os.Args[1] == "-wb" {
-		writeBack = &([]byte{})
+	var strategyName, weightsFile, exclusionsFile, startRule string
+	for i, arg := range os.Args[1:] {
+		i += 1
+		switch arg {
+		case "-wb":
+			writeBack = &([]byte{})
+		case "-r":
+			if len(os.Args[i+1:]) < 1 {
+				panic("Not enough arguments for '-r' option, need: STRATEGY")
+			}
+			strategyName = os.Args[i+1]
+		case "-w":
+			if len(os.Args[i+1:]) < 1 {
+				panic("Not enough arguments for '-w' option, need: WEIGHTS_FILE")
+			}
+			weightsFile = os.Args[i+1]
+		case "-R":
+			if len(os.Args[i+1:]) < 1 {
+				panic("Not enough arguments for '-R' option, need: START_RULE")
+			}
+			startRule = os.Args[i+1]
+		case "-x":
+			if len(os.Args[i+1:]) < 1 {
+				panic("Not enough arguments for '-x' option, need: EXCLUSIONS_FILE")
+			}
+			exclusionsFile = os.Args[i+1]
+		case "-A":
+			if len(os.Args[i+1:]) < 1 {
+				panic("Not enough arguments for '-A' option, need: ALPHABET")
+			}
+			alphabet, err := atnwalk.ParseAlphabet(os.Args[i+1])
+			if err != nil {
+				panic(err)
+			}
+			atnwalk.DefaultAlphabet = alphabet
+		case "-sep":
+			if len(os.Args[i+1:]) < 1 {
+				panic("Not enough arguments for '-sep' option, need: SEPARATOR")
+			}
+			separator, err := atnwalk.ParseSeparatorPolicy(os.Args[i+1])
+			if err != nil {
+				panic(err)
+			}
+			atnwalk.DefaultSeparatorPolicy = separator
+		}
 	}
 
 	go func() {
@@ -58,10 +101,30 @@ func main() {
 			"initialization into the code; inspect the comment above this panic statement in the code"))
 	}
 
+	strategy, err := atnwalk.ParseRoutingStrategy(strategyName, weightsFile, parser_, lexer)
+	if err != nil {
+		panic(err)
+	}
+	if strategy, err = atnwalk.WithExclusions(strategy, exclusionsFile, parser_, lexer); err != nil {
+		panic(err)
+	}
+
+	// the start rule that 'encode -auto' recorded in the data is used unless the start rule is set explicitly
+	recordedRule, data := atnwalk.SplitStartRule(data)
+	if startRule == "" {
+		startRule = recordedRule
+	}
 	walker := atnwalk.NewATNWalker(parser_, lexer)
+	walker.SetRoutingStrategy(strategy)
+	if err = walker.SetStartRule(startRule); err != nil {
+		panic(err)
+	}
 	output := walker.Decode(data, writeBack)
 	os.Stdout.WriteString(output)
 	if writeBack != nil {
+		if recordedRule != "" {
+			*writeBack = atnwalk.WithStartRule(*writeBack, recordedRule)
+		}
 		os.Stderr.Write(*writeBack)
 	}
 
@@ -7,19 +7,31 @@ package main
 	E.g., for SQLite, we need to insert these subsequent lines:
 
 	parser "atnwalk/out/gen/sqlite"
-    "atnwalk"
 */
 import (
 	// DO NOT REMOVE THIS LINE - IMPORT
+	"atnwalk"
 	"bufio"
 	"fmt"
 	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
 	"io"
 	"os"
+	"strings"
 )
 
 var _parser_ antlr.Parser
 var _lexer antlr.Lexer
+var parse atnwalk.ParseFunc
+
+// the rule that the text is parsed with, by default, the first rule of the grammar, or the comma-separated candidates
+// if the start rule is detected (by default, all rules of the grammar)
+var startRule string
+
+// whether a text with syntax errors is encoded on a best-effort basis instead of failing
+var tolerant bool
+
+// whether the start rule is detected and recorded in the encoded data, decode uses the recorded start rule
+var auto bool
 
 func main() {
 	reader := bufio.NewReader(os.Stdin)
@@ -29,36 +41,105 @@ func main() {
 		os.Exit(0)
 	}
 
+	for i, arg := range os.Args[1:] {
+		i += 1
+		if arg == "-R" {
+			if len(os.Args[i+1:]) < 1 {
+				panic("Not enough arguments for '-R' option, need: START_RULE")
+			}
+			startRule = os.Args[i+1]
+		}
+		if arg == "-A" {
+			if len(os.Args[i+1:]) < 1 {
+				panic("Not enough arguments for '-A' option, need: ALPHABET")
+			}
+			alphabet, err := atnwalk.ParseAlphabet(os.Args[i+1])
+			if err != nil {
+				panic(err)
+			}
+			atnwalk.DefaultAlphabet = alphabet
+		}
+		if arg == "-tolerant" {
+			tolerant = true
+		}
+		if arg == "-auto" {
+			auto = true
+		}
+	}
+
+	if auto && tolerant {
+		panic("The options '-auto' and '-tolerant' cannot be combined, '-auto' only detects start rules that match " +
+			"without syntax errors")
+	}
+
 	/*
 		Each grammar needs its own parser and lexer initialization.
 		We do this by searching for 'DO NOT REMOVE THIS LINE' and insert the lines below with a Bash script.
 
 		E.g., for SQLite, we need to insert these subsequent lines:
 
-		// create the input and token streams, lexer, and parser instances
-		input := antlr.NewInputStream(string(data))
-		lexer := parser.NewSQLiteLexer(input)
-		stream := antlr.NewCommonTokenStream(lexer, antlr.TokenDefaultChannel)
-		parser_ := parser.NewSQLiteParser(stream)
-
-		// call the main rule that shall be parsed
-		tree := parser_.Start()
-
-		// create an ATNWalker
-		walker := atnwalk.NewATNWalker(parser_, lexer)
-		os.Stdout.Write(walker.Encode(tree))
-
-		// this is just a safety measure to make sure that both, lexer and parser, have been properly initialized
-		_parser_ = parser_
-		_lexer = lexer
+		_parser_ = parser.NewSQLiteParser(nil)
+		_lexer = parser.NewSQLiteLexer(nil)
+		parse = func(text, startRule string) (antlr.Parser, antlr.Lexer, antlr.Tree, error) {
+			lexer := parser.NewSQLiteLexer(antlr.NewInputStream(text))
+			parser_ := parser.NewSQLiteParser(antlr.NewCommonTokenStream(lexer, antlr.TokenDefaultChannel))
+			if auto {
+				// the syntax errors of the candidates that do not match are expected
+				lexer.RemoveErrorListeners()
+				parser_.RemoveErrorListeners()
+			}
+			tree, err := atnwalk.ParseRule(parser_, startRule)
+			return parser_, lexer, tree, err
+		}
 	*/
 
 	// DO NOT REMOVE THIS LINE - EXEC
 
-	if _parser_ == nil || _lexer == nil {
-		panic(fmt.Errorf("_parser_ or _lexer are nil, make sure to insert the appropriate parser and lexer " +
+	if _parser_ == nil || _lexer == nil || parse == nil {
+		panic(fmt.Errorf("_parser_, _lexer, or parse are nil, make sure to insert the appropriate parser and lexer " +
 			"initialization into the code; inspect the comment above this panic statement in the code"))
 	}
 
+	if auto {
+		var candidates []string
+		if startRule != "" {
+			candidates = strings.Split(startRule, ",")
+		}
+		detected, encoded, err := atnwalk.DetectStartRule(_parser_, parse, string(data), candidates)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "detected the start rule %s\n", detected)
+		os.Stdout.Write(atnwalk.WithStartRule(encoded, detected))
+		os.Exit(0)
+	}
+
+	parser_, lexer, tree, err := parse(string(data), startRule)
+	if err != nil {
+		panic(err)
+	}
+	walker := atnwalk.NewATNWalker(parser_, lexer)
+	if tolerant {
+		encoded, preserved := walker.EncodeTolerant(tree)
+		fmt.Fprintf(os.Stderr, "preserved %.1f%% of the seed\n", 100*preserved)
+		os.Stdout.Write(encoded)
+	} else {
+		os.Stdout.Write(encode(walker, tree))
+	}
+
 	os.Exit(0)
 }
+
+// encode encodes the tree, it exits with an error instead of a panic if the tree does not match the grammar, e.g.,
+// because the text has syntax errors.
+func encode(walker *atnwalk.ATNWalker, tree antlr.Tree) []byte {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(os.Stderr, "could not encode the text: %v\n", r)
+			fmt.Fprintln(os.Stderr, "use -tolerant to encode a text with syntax errors on a best-effort basis")
+			os.Exit(1)
+		}
+	}()
+	return walker.Encode(tree)
+}
@@ -0,0 +1,181 @@
+package main
+
+/*
+	Each grammar needs its own parser and lexer initialization which includes the import of the parser package.
+	We do this by searching for 'DO NOT REMOVE THIS LINE' and insert the lines below with a Bash script.
+
+	E.g., for SQLite, we need to insert these subsequent lines:
+
+	parser "atnwalk/out/gen/sqlite"
+*/
+import (
+	// DO NOT REMOVE THIS LINE - IMPORT
+	"atnwalk"
+	"fmt"
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+var parser_ antlr.Parser
+var lexer antlr.Lexer
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: fuzz [-o OUT_DIR] [-i SEED_DIR] [-t EXEC_TIMEOUT] [-d DECODE_TIMEOUT] [-n EXECS] [-s SEED]")
+	fmt.Fprintln(os.Stderr, "            [-R START_RULE] [-r STRATEGY] [-w WEIGHTS_FILE] [-x EXCLUSIONS_FILE]")
+	fmt.Fprintln(os.Stderr, "            [-A ALPHABET] [-sep SEPARATOR] [-c] -- TARGET [ARG...]")
+	fmt.Fprintln(os.Stderr, "Executes the target with decoded inputs (on STDIN, or in a file if an argument contains @@)")
+	fmt.Fprintln(os.Stderr, "and saves the crashes (signals, non-zero exit codes, timeouts) to OUT_DIR/crashes.")
+	fmt.Fprintln(os.Stderr, "With -c, the corpus only keeps inputs that hit new edges of the AFL-instrumented target")
+	fmt.Fprintln(os.Stderr, "(the coverage bitmap is shared through __AFL_SHM_ID, its size is AFL_MAP_SIZE or 65536).")
+	os.Exit(2)
+}
+
+func main() {
+	outputDir := "./fuzz-out"
+	var seedDir, strategyName, weightsFile, exclusionsFile, startRule string
+	var target []string
+	execTimeout, decodeTimeout, maxExecs := 1000, 500, uint64(0)
+	seed := time.Now().UnixNano()
+	withCoverage := false
+	var err error
+	for i := 1; i < len(os.Args); i++ {
+		if os.Args[i] == "--" {
+			target = os.Args[i+1:]
+			break
+		}
+		if os.Args[i] == "-c" {
+			withCoverage = true
+			continue
+		}
+		if i+1 >= len(os.Args) {
+			usage()
+		}
+		i++
+		switch os.Args[i-1] {
+		case "-o":
+			outputDir = os.Args[i]
+		case "-i":
+			seedDir = os.Args[i]
+		case "-t":
+			execTimeout, err = strconv.Atoi(os.Args[i])
+		case "-d":
+			decodeTimeout, err = strconv.Atoi(os.Args[i])
+		case "-n":
+			maxExecs, err = strconv.ParseUint(os.Args[i], 10, 64)
+		case "-s":
+			seed, err = strconv.ParseInt(os.Args[i], 10, 64)
+		case "-R":
+			startRule = os.Args[i]
+		case "-r":
+			strategyName = os.Args[i]
+		case "-w":
+			weightsFile = os.Args[i]
+		case "-x":
+			exclusionsFile = os.Args[i]
+		case "-A":
+			atnwalk.DefaultAlphabet, err = atnwalk.ParseAlphabet(os.Args[i])
+		case "-sep":
+			atnwalk.DefaultSeparatorPolicy, err = atnwalk.ParseSeparatorPolicy(os.Args[i])
+		default:
+			usage()
+		}
+		if err != nil {
+			panic(err)
+		}
+	}
+	if len(target) == 0 {
+		usage()
+	}
+
+	/*
+		Each grammar needs its own parser and lexer initialization.
+		We do this by searching for 'DO NOT REMOVE THIS LINE' and insert the lines below with a Bash script.
+
+		E.g., for SQLite, we need to insert these subsequent lines:
+
+		parser_ = parser.NewSQLiteParser(nil)
+		lexer = parser.NewSQLiteLexer(nil)
+	*/
+
+	// DO NOT REMOVE THIS LINE - EXEC
+
+	if parser_ == nil || lexer == nil {
+		panic(fmt.Errorf("parser_ or lexer are nil, make sure to insert the appropriate parser and lexer " +
+			"initialization into the code; inspect the comment above this panic statement in the code"))
+	}
+
+	strategy, err := atnwalk.ParseRoutingStrategy(strategyName, weightsFile, parser_, lexer)
+	if err != nil {
+		panic(err)
+	}
+	if strategy, err = atnwalk.WithExclusions(strategy, exclusionsFile, parser_, lexer); err != nil {
+		panic(err)
+	}
+	fuzzer, err := atnwalk.NewFuzzer(parser_, lexer, strategy, startRule,
+		time.Duration(decodeTimeout)*time.Millisecond, seed)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	fuzzer.Target = target
+	fuzzer.ExecTimeout = time.Duration(execTimeout) * time.Millisecond
+	fuzzer.OutputDir = outputDir
+	if seedDir != "" {
+		if err = fuzzer.AddSeeds(seedDir); err != nil {
+			panic(err)
+		}
+	}
+	if withCoverage {
+		mapSize := atnwalk.DefaultMapSize
+		if size, err := strconv.Atoi(os.Getenv(atnwalk.AFLMapSizeEnv)); err == nil && size > 0 {
+			mapSize = size
+		}
+		if fuzzer.Coverage, err = atnwalk.NewCoverageMap(mapSize); err != nil {
+			fmt.Fprintln(os.Stderr, "cannot create the coverage map (-c):", err)
+			os.Exit(1)
+		}
+		defer fuzzer.Coverage.Close()
+	}
+
+	// stop gracefully on SIGINT or SIGTERM, i.e., after the current execution
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	start, lastStatus := time.Now(), time.Now()
+	printStatus := func() {
+		fmt.Fprintf(os.Stderr, "\rexecs: %d (%.0f/s), crashes: %d, hangs: %d", fuzzer.Execs,
+			float64(fuzzer.Execs)/time.Since(start).Seconds(), fuzzer.Crashes, fuzzer.Hangs)
+		if fuzzer.Coverage != nil {
+			fmt.Fprintf(os.Stderr, ", edges: %d, new coverage: %d", fuzzer.Coverage.Edges(), fuzzer.NewCoverage)
+		}
+		fmt.Fprint(os.Stderr, " ")
+	}
+	for stopping := false; !stopping && (maxExecs == 0 || fuzzer.Execs < maxExecs); {
+		select {
+		case <-signals:
+			stopping = true
+			continue
+		default:
+		}
+		result, isNew, err := fuzzer.Step()
+		if err != nil {
+			panic(err)
+		}
+		if isNew {
+			fmt.Fprintf(os.Stderr, "\rnew crash: %s%20s\n", result.Kind(), "")
+		}
+		if fuzzer.Coverage != nil && fuzzer.Execs == 16 && fuzzer.Coverage.Edges() == 0 {
+			fmt.Fprintln(os.Stderr, "\rwarning: the target did not record any edges, is it instrumented?")
+		}
+		if time.Since(lastStatus) > time.Second {
+			printStatus()
+			lastStatus = time.Now()
+		}
+	}
+	printStatus()
+	fmt.Fprintln(os.Stderr)
+}
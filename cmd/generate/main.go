@@ -0,0 +1,151 @@
+package main
+
+/*
+	Each grammar needs its own parser and lexer initialization which includes the import of the parser package.
+	We do this by searching for 'DO NOT REMOVE THIS LINE' and insert the lines below with a Bash script.
+
+	E.g., for SQLite, we need to insert these subsequent lines:
+
+	parser "atnwalk/out/gen/sqlite"
+*/
+import (
+	// DO NOT REMOVE THIS LINE - IMPORT
+	"atnwalk"
+	"fmt"
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var parser_ antlr.Parser
+var lexer antlr.Lexer
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: generate [-n COUNT] [-o OUT_DIR] [-j WORKERS] [-s SEED] [-size MIN:MAX] [-b BUCKETS]")
+	fmt.Fprintln(os.Stderr, "                [-R START_RULE] [-m MAX_DATA_BYTES] [-d DECODE_TIMEOUT] [-r STRATEGY] [-w WEIGHTS_FILE]")
+	fmt.Fprintln(os.Stderr, "                [-x EXCLUSIONS_FILE] [-A ALPHABET] [-sep SEPARATOR]")
+	fmt.Fprintln(os.Stderr, "Generates COUNT distinct inputs and writes them as OUT_DIR/ID.bytes (encoded) and OUT_DIR/ID.txt")
+	fmt.Fprintln(os.Stderr, "(decoded), the decoded sizes are uniformly distributed over BUCKETS if MAX is set.")
+	os.Exit(2)
+}
+
+func main() {
+	count, workers, buckets, maxDataBytes, decodeTimeout := 100, runtime.NumCPU(), 10, 64, 500
+	minSize, maxSize := 0, 0
+	seed := time.Now().UnixNano()
+	outputDir := "./seeds"
+	var startRule, strategyName, weightsFile, exclusionsFile string
+	var err error
+	for i := 1; i < len(os.Args); i++ {
+		if i+1 >= len(os.Args) {
+			usage()
+		}
+		i++
+		switch os.Args[i-1] {
+		case "-n":
+			count, err = strconv.Atoi(os.Args[i])
+		case "-o":
+			outputDir = os.Args[i]
+		case "-j":
+			workers, err = strconv.Atoi(os.Args[i])
+		case "-s":
+			seed, err = strconv.ParseInt(os.Args[i], 10, 64)
+		case "-size":
+			bounds := strings.SplitN(os.Args[i], ":", 2)
+			if len(bounds) != 2 {
+				usage()
+			}
+			if minSize, err = strconv.Atoi(bounds[0]); err == nil {
+				maxSize, err = strconv.Atoi(bounds[1])
+			}
+		case "-b":
+			buckets, err = strconv.Atoi(os.Args[i])
+		case "-R":
+			startRule = os.Args[i]
+		case "-m":
+			maxDataBytes, err = strconv.Atoi(os.Args[i])
+		case "-d":
+			decodeTimeout, err = strconv.Atoi(os.Args[i])
+		case "-r":
+			strategyName = os.Args[i]
+		case "-w":
+			weightsFile = os.Args[i]
+		case "-x":
+			exclusionsFile = os.Args[i]
+		case "-A":
+			atnwalk.DefaultAlphabet, err = atnwalk.ParseAlphabet(os.Args[i])
+		case "-sep":
+			atnwalk.DefaultSeparatorPolicy, err = atnwalk.ParseSeparatorPolicy(os.Args[i])
+		default:
+			usage()
+		}
+		if err != nil {
+			panic(err)
+		}
+	}
+	if count < 1 || workers < 1 || maxDataBytes < 1 || (maxSize > 0 && maxSize < minSize) {
+		usage()
+	}
+
+	/*
+		Each grammar needs its own parser and lexer initialization.
+		We do this by searching for 'DO NOT REMOVE THIS LINE' and insert the lines below with a Bash script.
+
+		E.g., for SQLite, we need to insert these subsequent lines:
+
+		parser_ = parser.NewSQLiteParser(nil)
+		lexer = parser.NewSQLiteLexer(nil)
+	*/
+
+	// DO NOT REMOVE THIS LINE - EXEC
+
+	if parser_ == nil || lexer == nil {
+		panic(fmt.Errorf("parser_ or lexer are nil, make sure to insert the appropriate parser and lexer " +
+			"initialization into the code; inspect the comment above this panic statement in the code"))
+	}
+
+	strategy, err := atnwalk.ParseRoutingStrategy(strategyName, weightsFile, parser_, lexer)
+	if err != nil {
+		panic(err)
+	}
+	if strategy, err = atnwalk.WithExclusions(strategy, exclusionsFile, parser_, lexer); err != nil {
+		panic(err)
+	}
+	generator, err := atnwalk.NewGenerator(parser_, lexer, strategy, startRule,
+		time.Duration(decodeTimeout)*time.Millisecond)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	generator.Count = count
+	generator.Workers = workers
+	generator.Seed = seed
+	generator.MinSize = minSize
+	generator.MaxSize = maxSize
+	generator.Buckets = buckets
+	generator.MaxDataBytes = maxDataBytes
+
+	if err = os.MkdirAll(outputDir, 0755); err != nil {
+		panic(err)
+	}
+	generated := 0
+	attempts, err := generator.Run(func(id int, decoded string, encoded []byte) error {
+		name := filepath.Join(outputDir, fmt.Sprintf("%06d", id))
+		if err := os.WriteFile(name+".bytes", encoded, 0644); err != nil {
+			return err
+		}
+		generated++
+		return os.WriteFile(name+".txt", []byte(decoded), 0644)
+	})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(os.Stderr, "generated %d distinct inputs from %d candidates (seed %d)\n", generated, attempts, seed)
+	if generated < count {
+		os.Exit(1)
+	}
+}
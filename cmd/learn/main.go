@@ -0,0 +1,114 @@
+package main
+
+/*
+	Each grammar needs its own parser and lexer initialization which includes the import of the parser package.
+	We do this by searching for 'DO NOT REMOVE THIS LINE' and insert the lines below with a Bash script.
+
+	E.g., for SQLite, we need to insert these subsequent lines:
+
+	parser "atnwalk/out/gen/sqlite"
+*/
+import (
+	// DO NOT REMOVE THIS LINE - IMPORT
+	"atnwalk"
+	"fmt"
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+	"os"
+)
+
+var parser_ antlr.Parser
+var lexer antlr.Lexer
+var parse func(text, startRule string) (antlr.Parser, antlr.Lexer, antlr.Tree, error)
+
+// encodeFile returns the encoded bytes of a text file, or the file itself if it is already encoded
+func encodeFile(path string, isEncoded bool, startRule string) (data []byte, err error) {
+	data, err = os.ReadFile(path)
+	if err != nil || isEncoded {
+		return data, err
+	}
+	// the encoder panics if the text cannot be matched, skip such files
+	defer func() {
+		if r := recover(); r != nil {
+			data, err = nil, fmt.Errorf("failed to encode %s: %v", path, r)
+		}
+	}()
+	p, l, tree, err := parse(string(data), startRule)
+	if err != nil {
+		return nil, err
+	}
+	return atnwalk.NewATNWalker(p, l).Encode(tree), nil
+}
+
+func main() {
+	isEncoded := false
+	startRule := ""
+	var files []string
+	for i := 1; i < len(os.Args); i++ {
+		switch os.Args[i] {
+		case "-b":
+			isEncoded = true
+		case "-R":
+			if i+1 >= len(os.Args) {
+				panic("Not enough arguments for '-R' option, need: START_RULE")
+			}
+			i++
+			startRule = os.Args[i]
+		default:
+			files = append(files, os.Args[i])
+		}
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: learn [-b] [-R START_RULE] FILE...")
+		fmt.Fprintln(os.Stderr, "Learns the weights of the alternatives from a corpus of text files (or encoded files with -b)")
+		fmt.Fprintln(os.Stderr, "and writes them to STDOUT for the weighted routing strategy ('-w FILE' of decode and server).")
+		os.Exit(2)
+	}
+
+	/*
+		Each grammar needs its own parser and lexer initialization.
+		We do this by searching for 'DO NOT REMOVE THIS LINE' and insert the lines below with a Bash script.
+
+		E.g., for SQLite, we need to insert these subsequent lines:
+
+		parser_ = parser.NewSQLiteParser(nil)
+		lexer = parser.NewSQLiteLexer(nil)
+		parse = func(text, startRule string) (antlr.Parser, antlr.Lexer, antlr.Tree, error) {
+			lexer := parser.NewSQLiteLexer(antlr.NewInputStream(text))
+			parser_ := parser.NewSQLiteParser(antlr.NewCommonTokenStream(lexer, antlr.TokenDefaultChannel))
+			tree, err := atnwalk.ParseRule(parser_, startRule)
+			return parser_, lexer, tree, err
+		}
+	*/
+
+	// DO NOT REMOVE THIS LINE - EXEC
+
+	if parser_ == nil || lexer == nil || parse == nil {
+		panic(fmt.Errorf("parser_, lexer, or parse are nil, make sure to insert the appropriate parser and lexer " +
+			"initialization into the code; inspect the comment above this panic statement in the code"))
+	}
+
+	// decoding the encoded inputs replays all choices that were made to produce them, the counter observes them
+	counter := atnwalk.NewChoiceCounter()
+	for _, path := range files {
+		data, err := encodeFile(path, isEncoded, startRule)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		walker := atnwalk.NewATNWalker(parser_, lexer)
+		walker.SetRoutingStrategy(counter)
+		if err = walker.SetStartRule(startRule); err != nil {
+			panic(err)
+		}
+		if data, err = walker.StripStartRule(data); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			continue
+		}
+		walker.Decode(data, nil)
+	}
+
+	if err := counter.RuleWeights(parser_, lexer).Write(os.Stdout); err != nil {
+		panic(err)
+	}
+	os.Exit(0)
+}
@@ -17,18 +17,118 @@ import (
 	"os"
 	"runtime"
 	"strconv"
-)
-
-const (
-	PidFile    = "./atnwalk.pid"
-	SocketFile = "./atnwalk.socket"
+	"time"
 )
 
 var parser_ antlr.Parser
 var lexer antlr.Lexer
 
 func main() {
-	atnwalk.InitServerProcess(PidFile, SocketFile)
+	timeout := 500
+	var command, strategyName, weightsFile, exclusionsFile, statsFile, startRule string
+	config, err := atnwalk.NewConfigFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	for i := 1; i < len(os.Args); i++ {
+		if n, err := config.ParseArg(os.Args, i); err != nil {
+			panic(err)
+		} else if n > 0 {
+			i += n - 1
+			continue
+		}
+		switch os.Args[i] {
+		case "start", "stop", "status", "restart":
+			command = os.Args[i]
+		case "-r":
+			if i+1 >= len(os.Args) {
+				panic("Not enough arguments for '-r' option, need: STRATEGY")
+			}
+			i++
+			strategyName = os.Args[i]
+		case "-w":
+			if i+1 >= len(os.Args) {
+				panic("Not enough arguments for '-w' option, need: WEIGHTS_FILE")
+			}
+			i++
+			weightsFile = os.Args[i]
+		case "-x":
+			if i+1 >= len(os.Args) {
+				panic("Not enough arguments for '-x' option, need: EXCLUSIONS_FILE")
+			}
+			i++
+			exclusionsFile = os.Args[i]
+		case "-s":
+			if i+1 >= len(os.Args) {
+				panic("Not enough arguments for '-s' option, need: STATS_FILE")
+			}
+			i++
+			statsFile = os.Args[i]
+		case "-R":
+			if i+1 >= len(os.Args) {
+				panic("Not enough arguments for '-R' option, need: START_RULE")
+			}
+			i++
+			startRule = os.Args[i]
+		case "-A":
+			if i+1 >= len(os.Args) {
+				panic("Not enough arguments for '-A' option, need: ALPHABET")
+			}
+			i++
+			alphabet, err := atnwalk.ParseAlphabet(os.Args[i])
+			if err != nil {
+				panic(err)
+			}
+			atnwalk.DefaultAlphabet = alphabet
+		case "-sep":
+			if i+1 >= len(os.Args) {
+				panic("Not enough arguments for '-sep' option, need: SEPARATOR")
+			}
+			i++
+			separator, err := atnwalk.ParseSeparatorPolicy(os.Args[i])
+			if err != nil {
+				panic(err)
+			}
+			atnwalk.DefaultSeparatorPolicy = separator
+		default:
+			var err error
+			if timeout, err = strconv.Atoi(os.Args[i]); err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	if err := config.Chdir(); err != nil {
+		panic(err)
+	}
+	network, address := config.Network()
+
+	switch command {
+	case "status":
+		// exit codes follow the LSB init script conventions
+		pid, running, healthy := atnwalk.ServerStatus(config.PidFile, config.Address)
+		switch {
+		case healthy:
+			fmt.Printf("running (pid %d)\n", pid)
+			os.Exit(0)
+		case running:
+			fmt.Printf("not responding (pid %d)\n", pid)
+			os.Exit(1)
+		default:
+			fmt.Println("not running")
+			os.Exit(3)
+		}
+	case "stop", "restart":
+		if err := atnwalk.StopServer(config.PidFile, 5*time.Second); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if command == "stop" {
+			os.Exit(0)
+		}
+	}
+
+	atnwalk.InitServerProcess(config.PidFile, config.Address)
 
 	/*
 		Each grammar needs its own parser and lexer initialization.
@@ -47,34 +147,53 @@ func main() {
 			"initialization into the code; inspect the comment above this panic statement in the code"))
 	}
 
-	if err := os.RemoveAll(SocketFile); err != nil {
+	if socketFile := config.SocketFile(); socketFile != "" {
+		if err := os.RemoveAll(socketFile); err != nil {
+			panic(err)
+		}
+	}
+
+	// all requests start with the same rule
+	if err := atnwalk.NewATNWalker(parser_, lexer).SetStartRule(startRule); err != nil {
 		panic(err)
 	}
 
-	timeout := 500
-	if len(os.Args) > 1 {
-		var err error
-		if timeout, err = strconv.Atoi(os.Args[1]); err != nil {
-			panic(err)
-		}
+	// the strategy is shared by all requests, e.g., to track the coverage across requests
+	strategy, err := atnwalk.ParseRoutingStrategy(strategyName, weightsFile, parser_, lexer)
+	if err != nil {
+		panic(err)
+	}
+	if strategy, err = atnwalk.WithExclusions(strategy, exclusionsFile, parser_, lexer); err != nil {
+		panic(err)
 	}
 
-	listener, err := net.Listen("unix", SocketFile)
+	// the statistics can always be requested by the client, optionally they are dumped to a file
+	stats := atnwalk.NewServerStats()
+	if statsFile != "" {
+		go stats.DumpPrometheus(statsFile, 10*time.Second)
+	}
+
+	listener, err := net.Listen(network, address)
 	if err != nil {
 		panic(err)
 	}
-	defer listener.Close()
 
-	semaphore := make(chan struct{}, runtime.NumCPU())
-	for {
-		semaphore <- struct{}{}
-		conn, err := listener.Accept()
-		if err != nil {
+	// the shared memory transport is served in addition to the socket which still answers handshakes and statistics
+	var shmServer *atnwalk.SharedMemoryServer
+	if config.Transport == atnwalk.SharedMemoryTransport {
+		if shmServer, err = atnwalk.NewSharedMemoryServer(config.ShmFile(), runtime.NumCPU(),
+			atnwalk.DefaultShmCapacity); err != nil {
 			panic(err)
 		}
-		go func() {
-			atnwalk.HandleRequest(conn, timeout, parser_, lexer)
-			<-semaphore
-		}()
+		go shmServer.Serve(timeout, parser_, lexer, startRule, strategy, stats)
+	}
+
+	// serve until SIGTERM or SIGINT, then clean up once the in-flight requests are handled
+	atnwalk.Serve(listener, runtime.NumCPU(), func(conn net.Conn) {
+		atnwalk.HandleRequest(conn, timeout, parser_, lexer, startRule, strategy, stats)
+	})
+	if shmServer != nil {
+		shmServer.Close()
 	}
+	atnwalk.ReleaseServerProcess(config.PidFile, config.SocketFile())
 }
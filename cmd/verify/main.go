@@ -0,0 +1,130 @@
+package main
+
+/*
+	Each grammar needs its own parser and lexer initialization which includes the import of the parser package.
+	We do this by searching for 'DO NOT REMOVE THIS LINE' and insert the lines below with a Bash script.
+
+	E.g., for SQLite, we need to insert these subsequent lines:
+
+	parser "atnwalk/out/gen/sqlite"
+*/
+import (
+	// DO NOT REMOVE THIS LINE - IMPORT
+	"atnwalk"
+	"fmt"
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+	"os"
+	"strconv"
+	"time"
+)
+
+var parser_ antlr.Parser
+var lexer antlr.Lexer
+var parse atnwalk.ParseFunc
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: verify [-n INPUTS] [-s SEED] [-m MAX_DATA_BYTES] [-f MAX_FAILURES] [-d DECODE_TIMEOUT]")
+	fmt.Fprintln(os.Stderr, "              [-R START_RULE] [-r STRATEGY] [-w WEIGHTS_FILE] [-x EXCLUSIONS_FILE] [-A ALPHABET]")
+	fmt.Fprintln(os.Stderr, "              [-sep SEPARATOR]")
+	fmt.Fprintln(os.Stderr, "Decodes INPUTS random inputs and checks that the written back data, the repaired data, and the")
+	fmt.Fprintln(os.Stderr, "encoded parse trees of the outputs decode to the same outputs, and that repairing is idempotent.")
+	fmt.Fprintln(os.Stderr, "Prints the failures (at most MAX_FAILURES, 0 for all) on STDOUT and exits with 1 if there are any.")
+	os.Exit(2)
+}
+
+func main() {
+	inputs, maxDataBytes, maxFailures, decodeTimeout := 10000, 64, 10, 500
+	seed := time.Now().UnixNano()
+	var startRule, strategyName, weightsFile, exclusionsFile string
+	var err error
+	for i := 1; i < len(os.Args); i++ {
+		if i+1 >= len(os.Args) {
+			usage()
+		}
+		i++
+		switch os.Args[i-1] {
+		case "-n":
+			inputs, err = strconv.Atoi(os.Args[i])
+		case "-s":
+			seed, err = strconv.ParseInt(os.Args[i], 10, 64)
+		case "-m":
+			maxDataBytes, err = strconv.Atoi(os.Args[i])
+		case "-f":
+			maxFailures, err = strconv.Atoi(os.Args[i])
+		case "-d":
+			decodeTimeout, err = strconv.Atoi(os.Args[i])
+		case "-R":
+			startRule = os.Args[i]
+		case "-r":
+			strategyName = os.Args[i]
+		case "-w":
+			weightsFile = os.Args[i]
+		case "-x":
+			exclusionsFile = os.Args[i]
+		case "-A":
+			atnwalk.DefaultAlphabet, err = atnwalk.ParseAlphabet(os.Args[i])
+		case "-sep":
+			atnwalk.DefaultSeparatorPolicy, err = atnwalk.ParseSeparatorPolicy(os.Args[i])
+		default:
+			usage()
+		}
+		if err != nil {
+			panic(err)
+		}
+	}
+	if inputs < 1 || maxDataBytes < 1 || maxFailures < 0 {
+		usage()
+	}
+
+	/*
+		Each grammar needs its own parser and lexer initialization.
+		We do this by searching for 'DO NOT REMOVE THIS LINE' and insert the lines below with a Bash script.
+
+		E.g., for SQLite, we need to insert these subsequent lines:
+
+		parser_ = parser.NewSQLiteParser(nil)
+		lexer = parser.NewSQLiteLexer(nil)
+		parse = func(text, startRule string) (antlr.Parser, antlr.Lexer, antlr.Tree, error) {
+			lexer := parser.NewSQLiteLexer(antlr.NewInputStream(text))
+			parser_ := parser.NewSQLiteParser(antlr.NewCommonTokenStream(lexer, antlr.TokenDefaultChannel))
+			tree, err := atnwalk.ParseRule(parser_, startRule)
+			return parser_, lexer, tree, err
+		}
+	*/
+
+	// DO NOT REMOVE THIS LINE - EXEC
+
+	if parser_ == nil || lexer == nil || parse == nil {
+		panic(fmt.Errorf("parser_, lexer, or parse are nil, make sure to insert the appropriate parser and lexer " +
+			"initialization into the code; inspect the comment above this panic statement in the code"))
+	}
+
+	strategy, err := atnwalk.ParseRoutingStrategy(strategyName, weightsFile, parser_, lexer)
+	if err != nil {
+		panic(err)
+	}
+	if strategy, err = atnwalk.WithExclusions(strategy, exclusionsFile, parser_, lexer); err != nil {
+		panic(err)
+	}
+	verifier, err := atnwalk.NewVerifier(parser_, lexer, strategy, startRule,
+		time.Duration(decodeTimeout)*time.Millisecond)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	verifier.Inputs = inputs
+	verifier.Seed = seed
+	verifier.MaxDataBytes = maxDataBytes
+	verifier.MaxFailures = maxFailures
+	verifier.Parse = parse
+
+	report := verifier.Run()
+	for _, failure := range report.Failures {
+		fmt.Println(failure)
+	}
+	fmt.Fprintf(os.Stderr, "checked %d inputs, %d timed out, %d failures (seed %d)\n", report.Inputs,
+		report.TimedOut, len(report.Failures), seed)
+	if len(report.Failures) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,182 @@
+package atnwalk
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+)
+
+const (
+	DefaultAddress = "./atnwalk.socket"
+	DefaultPidFile = "./atnwalk.pid"
+
+	// the socket transport is always served, the shared memory transport is served in addition if it is selected
+	SocketTransport       = "socket"
+	SharedMemoryTransport = "shm"
+
+	// the capacity is reserved but the pages of the shared memory are only allocated once they are used
+	DefaultShmCapacity = 1 << 22
+
+	// environment variables that are shared by the server and the client so both find each other
+	AddressEnv   = "ATNWALK_ADDRESS"
+	PidFileEnv   = "ATNWALK_PID_FILE"
+	DirEnv       = "ATNWALK_DIR"
+	TransportEnv = "ATNWALK_TRANSPORT"
+)
+
+// Config locates the server, the address is either:
+//   - a path of a Unix socket file, e.g., ./atnwalk.socket
+//   - an abstract Unix socket name starting with '@', e.g., @atnwalk-sqlite (Linux only, no file is created)
+//   - a TCP address of a loopback host prefixed with 'tcp:', e.g., tcp:127.0.0.1:4242 (the server is not authenticated)
+//
+// Relative paths are resolved against Dir if it is set.
+// The Transport is either SocketTransport or SharedMemoryTransport, the latter exchanges the data of the requests
+// through the shared memory file (see ShmFile) while the socket is still used for the handshake and the statistics.
+type Config struct {
+	Address   string
+	PidFile   string
+	Dir       string
+	Transport string
+}
+
+// NewConfigFromEnv returns the defaults overridden by the environment variables, it fails if the transport is unknown
+// or not supported on this platform.
+func NewConfigFromEnv() (*Config, error) {
+	config := &Config{Address: DefaultAddress, PidFile: DefaultPidFile, Transport: SocketTransport}
+	if address, ok := os.LookupEnv(AddressEnv); ok && address != "" {
+		config.Address = address
+	}
+	if pidFile, ok := os.LookupEnv(PidFileEnv); ok && pidFile != "" {
+		config.PidFile = pidFile
+	}
+	if transport, ok := os.LookupEnv(TransportEnv); ok && transport != "" {
+		config.Transport = transport
+	}
+	config.Dir = os.Getenv(DirEnv)
+	if err := checkAddress(config.Address); err != nil {
+		return nil, err
+	}
+	if err := checkTransport(config.Transport); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ParseArg consumes the configuration options -a ADDRESS, -p PID_FILE, -C DIR, and -T TRANSPORT from the arguments at
+// index i and returns how many arguments were consumed, zero if the argument is not a configuration option.
+func (c *Config) ParseArg(args []string, i int) (int, error) {
+	var target *string
+	switch args[i] {
+	case "-a":
+		target = &c.Address
+	case "-p":
+		target = &c.PidFile
+	case "-C":
+		target = &c.Dir
+	case "-T":
+		target = &c.Transport
+	default:
+		return 0, nil
+	}
+	if i+1 >= len(args) {
+		return 0, fmt.Errorf("not enough arguments for '%s' option", args[i])
+	}
+	*target = args[i+1]
+	switch target {
+	case &c.Address:
+		if err := checkAddress(c.Address); err != nil {
+			return 0, err
+		}
+	case &c.Transport:
+		if err := checkTransport(c.Transport); err != nil {
+			return 0, err
+		}
+	}
+	return 2, nil
+}
+
+// checkAddress fails if the address is a TCP address of a host that is not a loopback host, anyone who reaches the
+// server could use it.
+func checkAddress(address string) error {
+	network, address := splitAddress(address)
+	if network != "tcp" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid TCP address '%s': %v", address, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("the TCP address '%s' is not a loopback address, the server is not authenticated", address)
+	}
+	return nil
+}
+
+// checkTransport fails if the transport is unknown or not supported on this platform.
+func checkTransport(transport string) error {
+	if transport != SocketTransport && transport != SharedMemoryTransport {
+		return fmt.Errorf("unknown transport '%s', expected '%s' or '%s'", transport, SocketTransport,
+			SharedMemoryTransport)
+	}
+	if transport == SharedMemoryTransport && !sharedMemorySupported {
+		return fmt.Errorf("the transport '%s' is not supported on %s, use '%s'", transport, runtime.GOOS,
+			SocketTransport)
+	}
+	return nil
+}
+
+// Chdir changes the working directory to Dir if it is set, thus, relative paths are resolved against it.
+func (c *Config) Chdir() error {
+	if c.Dir == "" {
+		return nil
+	}
+	return os.Chdir(c.Dir)
+}
+
+// LockFile returns the path of the lock file that clients use to start at most one server at once.
+func (c *Config) LockFile() string {
+	return c.PidFile + ".lock"
+}
+
+// ServerArgs returns the arguments to start a server with this configuration, the working directory is inherited.
+func (c *Config) ServerArgs() []string {
+	return []string{"-a", c.Address, "-p", c.PidFile, "-T", c.Transport}
+}
+
+// ShmFile returns the path of the shared memory file, i.e., next to the Unix socket file or in /dev/shm otherwise.
+func (c *Config) ShmFile() string {
+	if socketFile := c.SocketFile(); socketFile != "" {
+		return socketFile + ".shm"
+	}
+	_, address := splitAddress(c.Address)
+	name := strings.NewReplacer("@", "", "/", "_", ":", "_").Replace(address)
+	return "/dev/shm/" + name + ".shm"
+}
+
+// Network returns the network and the address for net.Dial and net.Listen.
+func (c *Config) Network() (string, string) {
+	return splitAddress(c.Address)
+}
+
+// SocketFile returns the path of the Unix socket file or an empty string for abstract and TCP sockets.
+func (c *Config) SocketFile() string {
+	network, address := splitAddress(c.Address)
+	if network != "unix" || strings.HasPrefix(address, "@") {
+		return ""
+	}
+	return address
+}
+
+func splitAddress(address string) (string, string) {
+	if strings.HasPrefix(address, "tcp:") {
+		return "tcp", strings.TrimPrefix(address, "tcp:")
+	}
+	return "unix", strings.TrimPrefix(address, "unix:")
+}
+
+func dial(address string) (net.Conn, error) {
+	network, address := splitAddress(address)
+	return net.Dial(network, address)
+}
@@ -0,0 +1,81 @@
+package atnwalk
+
+import "testing"
+
+func TestConfig_Network(t *testing.T) {
+	tests := []struct {
+		address, network, networkAddress, socketFile, shmFile string
+	}{
+		{"./atnwalk.socket", "unix", "./atnwalk.socket", "./atnwalk.socket", "./atnwalk.socket.shm"},
+		{"unix:/tmp/sqlite.socket", "unix", "/tmp/sqlite.socket", "/tmp/sqlite.socket", "/tmp/sqlite.socket.shm"},
+		{"@atnwalk-sqlite", "unix", "@atnwalk-sqlite", "", "/dev/shm/atnwalk-sqlite.shm"},
+		{"tcp:127.0.0.1:4242", "tcp", "127.0.0.1:4242", "", "/dev/shm/127.0.0.1_4242.shm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			config := &Config{Address: tt.address}
+			network, address := config.Network()
+			if network != tt.network || address != tt.networkAddress {
+				t.Errorf("Network() = (%v, %v), want (%v, %v)", network, address, tt.network, tt.networkAddress)
+			}
+			if socketFile := config.SocketFile(); socketFile != tt.socketFile {
+				t.Errorf("SocketFile() = %v, want %v", socketFile, tt.socketFile)
+			}
+			if shmFile := config.ShmFile(); shmFile != tt.shmFile {
+				t.Errorf("ShmFile() = %v, want %v", shmFile, tt.shmFile)
+			}
+		})
+	}
+}
+
+func TestConfig_ParseArg(t *testing.T) {
+	t.Setenv(AddressEnv, "@from-env")
+	t.Setenv(PidFileEnv, "")
+	t.Setenv(DirEnv, "/tmp")
+	config, err := NewConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Address != "@from-env" || config.PidFile != DefaultPidFile || config.Dir != "/tmp" {
+		t.Errorf("NewConfigFromEnv() = %+v", config)
+	}
+
+	args := []string{"server", "-a", "tcp:localhost:4242", "-d", "-p", "sqlite.pid", "-C"}
+	want := []int{0, 2, 0, 0, 2, 0}
+	for i := 1; i < len(args)-1; i++ {
+		n, err := config.ParseArg(args, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want[i] {
+			t.Errorf("ParseArg(args, %d) = %d, want %d", i, n, want[i])
+		}
+	}
+	if config.Address != "tcp:localhost:4242" || config.PidFile != "sqlite.pid" {
+		t.Errorf("ParseArg() resulted in %+v", config)
+	}
+	if _, err := config.ParseArg(args, len(args)-1); err == nil {
+		t.Errorf("ParseArg() with a missing value should fail")
+	}
+	if _, err := config.ParseArg([]string{"-T", SharedMemoryTransport}, 0); err != nil || config.Transport != "shm" {
+		t.Errorf("ParseArg() with -T shm resulted in %+v, %v", config, err)
+	}
+	if _, err := config.ParseArg([]string{"-T", "pigeon"}, 0); err == nil {
+		t.Errorf("ParseArg() with an unknown transport should fail")
+	}
+	for _, address := range []string{"tcp:127.0.0.1:4242", "tcp:[::1]:4242", "tcp:localhost:4242"} {
+		if _, err := config.ParseArg([]string{"-a", address}, 0); err != nil {
+			t.Errorf("ParseArg() with the loopback address %s failed: %v", address, err)
+		}
+	}
+	for _, address := range []string{"tcp:0.0.0.0:4242", "tcp::4242", "tcp:192.168.1.2:4242", "tcp:example.com:80",
+		"tcp:localhost"} {
+		if _, err := config.ParseArg([]string{"-a", address}, 0); err == nil {
+			t.Errorf("ParseArg() accepted the address %s", address)
+		}
+	}
+	t.Setenv(TransportEnv, "pigeon")
+	if _, err := NewConfigFromEnv(); err == nil {
+		t.Errorf("NewConfigFromEnv() with an unknown transport should fail")
+	}
+}
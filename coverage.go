@@ -0,0 +1,123 @@
+//go:build linux
+
+package atnwalk
+
+import (
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// System V shared memory is attached with raw system calls whose numbers are only defined for Linux here
+// (see coverage_other.go)
+const (
+	ipcPrivate = 0
+	ipcCreat   = 01000
+	ipcExcl    = 02000
+	ipcRmid    = 0
+)
+
+// the hit counts of an edge are classified into buckets (like AFL) so that only changes of the magnitude count as new
+var countClass [256]byte
+
+func init() {
+	for i := range countClass {
+		switch {
+		case i == 0:
+			countClass[i] = 0
+		case i <= 3:
+			countClass[i] = byte(1 << (i - 1))
+		case i <= 7:
+			countClass[i] = 8
+		case i <= 15:
+			countClass[i] = 16
+		case i <= 31:
+			countClass[i] = 32
+		case i <= 127:
+			countClass[i] = 64
+		default:
+			countClass[i] = 128
+		}
+	}
+}
+
+// CoverageMap is an AFL-compatible coverage bitmap that is shared with instrumented targets.
+type CoverageMap struct {
+	id     int
+	addr   uintptr
+	trace  []byte
+	virgin []byte
+}
+
+// NewCoverageMap creates the shared memory segment of the bitmap, it must be closed to remove the segment.
+func NewCoverageMap(size int) (*CoverageMap, error) {
+	id, _, errno := syscall.Syscall(syscall.SYS_SHMGET, ipcPrivate, uintptr(size), ipcCreat|ipcExcl|0600)
+	if errno != 0 {
+		return nil, errno
+	}
+	addr, _, errno := syscall.Syscall(syscall.SYS_SHMAT, id, 0, 0)
+	if errno != 0 {
+		syscall.Syscall(syscall.SYS_SHMCTL, id, ipcRmid, 0)
+		return nil, errno
+	}
+	virgin := make([]byte, size)
+	for i := range virgin {
+		virgin[i] = 0xff
+	}
+	// the attached segment is not Go memory, reinterpret the address instead of converting the uintptr
+	trace := unsafe.Slice((*byte)(*(*unsafe.Pointer)(unsafe.Pointer(&addr))), size)
+	return &CoverageMap{id: int(id), addr: addr, trace: trace, virgin: virgin}, nil
+}
+
+// Env returns the environment variables that tell an instrumented target where to record the edges.
+func (m *CoverageMap) Env() []string {
+	return []string{AFLShmIdEnv + "=" + strconv.Itoa(m.id), AFLMapSizeEnv + "=" + strconv.Itoa(len(m.trace))}
+}
+
+// Reset clears the trace before the target is executed.
+func (m *CoverageMap) Reset() {
+	for i := range m.trace {
+		m.trace[i] = 0
+	}
+}
+
+// Update merges the trace of the last execution and reports whether it hit new edges or new hit count buckets,
+// an empty trace indicates that the target is not instrumented.
+func (m *CoverageMap) Update() (hasNewBits, isEmpty bool) {
+	return updateVirgin(m.trace, m.virgin)
+}
+
+func updateVirgin(trace, virgin []byte) (hasNewBits, isEmpty bool) {
+	isEmpty = true
+	for i, count := range trace {
+		if count == 0 {
+			continue
+		}
+		isEmpty = false
+		if bits := countClass[count]; bits&virgin[i] != 0 {
+			virgin[i] &^= bits
+			hasNewBits = true
+		}
+	}
+	return hasNewBits, isEmpty
+}
+
+// Edges returns how many edges were hit so far.
+func (m *CoverageMap) Edges() int {
+	edges := 0
+	for _, bits := range m.virgin {
+		if bits != 0xff {
+			edges++
+		}
+	}
+	return edges
+}
+
+// Close detaches and removes the shared memory segment.
+func (m *CoverageMap) Close() error {
+	syscall.Syscall(syscall.SYS_SHMDT, m.addr, 0, 0)
+	if _, _, errno := syscall.Syscall(syscall.SYS_SHMCTL, uintptr(m.id), ipcRmid, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+//go:build !linux
+
+package atnwalk
+
+import "errors"
+
+var errCoverageUnsupported = errors.New("unsupported coverage map: the AFL-compatible coverage map requires Linux")
+
+// CoverageMap is not supported on this platform, fuzz without coverage feedback instead.
+type CoverageMap struct{}
+
+// NewCoverageMap fails because the coverage map is not supported on this platform.
+func NewCoverageMap(size int) (*CoverageMap, error) {
+	return nil, errCoverageUnsupported
+}
+
+// Env returns no environment variables because the coverage map is not supported on this platform.
+func (m *CoverageMap) Env() []string {
+	return nil
+}
+
+// Reset does nothing because the coverage map is not supported on this platform.
+func (m *CoverageMap) Reset() {
+}
+
+// Update reports an empty trace because the coverage map is not supported on this platform.
+func (m *CoverageMap) Update() (hasNewBits, isEmpty bool) {
+	return false, true
+}
+
+// Edges returns 0 because the coverage map is not supported on this platform.
+func (m *CoverageMap) Edges() int {
+	return 0
+}
+
+// Close fails because the coverage map is not supported on this platform.
+func (m *CoverageMap) Close() error {
+	return errCoverageUnsupported
+}
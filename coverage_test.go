@@ -0,0 +1,52 @@
+//go:build linux
+
+package atnwalk
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUpdateVirgin(t *testing.T) {
+	virgin := []byte{0xff, 0xff, 0xff, 0xff}
+	tests := []struct {
+		trace               []byte
+		hasNewBits, isEmpty bool
+	}{
+		{[]byte{0, 0, 0, 0}, false, true},
+		{[]byte{1, 0, 0, 0}, true, false},
+		{[]byte{1, 0, 0, 0}, false, false},
+		// 4 and 5 hits are in the same bucket
+		{[]byte{1, 4, 0, 0}, true, false},
+		{[]byte{1, 5, 0, 0}, false, false},
+		{[]byte{2, 5, 0, 200}, true, false},
+	}
+	for i, tt := range tests {
+		hasNewBits, isEmpty := updateVirgin(tt.trace, virgin)
+		if hasNewBits != tt.hasNewBits || isEmpty != tt.isEmpty {
+			t.Errorf("%d: updateVirgin(%v) = %v, %v, want %v, %v", i, tt.trace, hasNewBits, isEmpty, tt.hasNewBits,
+				tt.isEmpty)
+		}
+	}
+}
+
+func TestCoverageMap(t *testing.T) {
+	m, err := NewCoverageMap(DefaultMapSize)
+	if err != nil {
+		t.Skipf("System V shared memory is not available: %v", err)
+	}
+	defer m.Close()
+	if env := m.Env(); len(env) != 2 || !strings.HasPrefix(env[0], AFLShmIdEnv+"=") {
+		t.Errorf("Env() = %v", env)
+	}
+
+	// stand in for an instrumented target
+	m.trace[7], m.trace[42] = 1, 3
+	if hasNewBits, _ := m.Update(); !hasNewBits || m.Edges() != 2 {
+		t.Errorf("Update() = %v with %d edges, want true with 2 edges", hasNewBits, m.Edges())
+	}
+	m.Reset()
+	if hasNewBits, isEmpty := m.Update(); hasNewBits || !isEmpty {
+		t.Errorf("Update() after Reset() = %v, %v, want false, true", hasNewBits, isEmpty)
+	}
+}
@@ -0,0 +1,146 @@
+package atnwalk
+
+import (
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+// endModel describes where an ATN matches EOF, i.e., where the input must end. A transition ends the input if it
+// matches EOF or if it enters a rule that cannot be completed without matching EOF, the only valid continuation after
+// such a transition is to produce nothing anymore.
+type endModel struct {
+	atn *antlr.ATN
+	// whether the rule stop state is reachable from the state without consuming tokens (parser) or characters (lexer)
+	empty []bool
+	// whether the rule stop state is reachable from the state without ending the input
+	avoidable []bool
+}
+
+// endModels shares the end models of the ATNs between walkers.
+var endModels = newATNCache(newEndModel)
+
+func newEndModel(atn *antlr.ATN) *endModel {
+	states := atn.GetStates()
+	model := &endModel{atn: atn, empty: make([]bool, len(states)), avoidable: make([]bool, len(states))}
+	for i, state := range states {
+		if state != nil && state.GetStateType() == antlr.ATNStateRuleStop {
+			model.empty[i] = true
+			model.avoidable[i] = true
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for i, state := range states {
+			if state == nil {
+				continue
+			}
+			for _, transition := range state.GetTransitions() {
+				if !model.empty[i] && model.isEmpty(transition) {
+					model.empty[i] = true
+					changed = true
+				}
+				if !model.avoidable[i] && !model.ends(transition) {
+					model.avoidable[i] = true
+					changed = true
+				}
+			}
+		}
+	}
+	return model
+}
+
+// isEOF reports whether the transition matches EOF, in parser and in lexer ATNs EOF is an atom with the label -1.
+func isEOF(transition antlr.Transition) bool {
+	t, ok := transition.(*antlr.AtomTransition)
+	return ok && t.GetLabelValue() == antlr.TokenEOF
+}
+
+// ruleStartState returns the start state of the rule that the transition enters.
+func (m *endModel) ruleStartState(t *antlr.RuleTransition) antlr.ATNState {
+	return m.atn.GetRuleIndexToStartStateSlice()[t.GetRuleIndex()]
+}
+
+// isEmpty reports whether the rule stop state is reachable with the transition without consuming anything.
+func (m *endModel) isEmpty(transition antlr.Transition) bool {
+	switch t := transition.(type) {
+	case *antlr.RuleTransition:
+		return m.empty[m.ruleStartState(t).GetStateNumber()] && m.empty[t.GetFollowState().GetStateNumber()]
+	case *antlr.AtomTransition:
+		return isEOF(t) && m.empty[t.GetTarget().GetStateNumber()]
+	case *antlr.SetTransition, *antlr.NotSetTransition, *antlr.RangeTransition, *antlr.WildcardTransition:
+		return false
+	}
+	return m.empty[transition.(antlr.AnyTransition).GetTarget().GetStateNumber()]
+}
+
+// ends reports whether taking the transition ends the input or whether the input must end before the rule stop state
+// is reached, it is only exact once the model is complete.
+func (m *endModel) ends(transition antlr.Transition) bool {
+	if isEOF(transition) {
+		return true
+	}
+	if t, ok := transition.(*antlr.RuleTransition); ok {
+		return !m.avoidable[m.ruleStartState(t).GetStateNumber()] || !m.avoidable[t.GetFollowState().GetStateNumber()]
+	}
+	return !m.avoidable[transition.(antlr.AnyTransition).GetTarget().GetStateNumber()]
+}
+
+// endsInput reports whether taking the transition ends the input, unlike ends it is false for transitions that end
+// the input later on.
+func (m *endModel) endsInput(transition antlr.Transition) bool {
+	if t, ok := transition.(*antlr.RuleTransition); ok {
+		return !m.avoidable[m.ruleStartState(t).GetStateNumber()]
+	}
+	return isEOF(transition)
+}
+
+// emptyChoice returns the first choice of the state that produces nothing, or 0 if every choice produces something.
+func (m *endModel) emptyChoice(state antlr.ATNState) int {
+	for choice, transition := range state.GetTransitions() {
+		if m.isEmpty(transition) {
+			return choice
+		}
+	}
+	return 0
+}
+
+// canEnd reports whether the nodes that are left to decode can produce nothing, i.e., whether the input can end now.
+func (w *ATNWalker) canEnd() bool {
+	if w.pending == nil {
+		return true
+	}
+	parserEnds := endModels.get(w.Parser.GetATN())
+	for _, node := range w.pending.data {
+		switch n := node.(type) {
+		case *RuleNode:
+			if !n.ended && !parserEnds.empty[n.StartState.GetStateNumber()] {
+				return false
+			}
+		case *SymbolNode:
+			if !n.ended {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// endNodes marks the rule and symbol nodes as ended, they produce nothing.
+func endNodes(nodes []TreeNode) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *RuleNode:
+			n.ended = true
+		case *SymbolNode:
+			n.ended = true
+		}
+	}
+}
+
+// endPending marks the nodes that are left to decode as ended, they produce nothing.
+func (w *ATNWalker) endPending() {
+	if w.pending != nil {
+		endNodes(w.pending.data)
+	}
+}
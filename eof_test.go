@@ -0,0 +1,183 @@
+package atnwalk
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+func eof() step {
+	return step{antlr.TransitionATOM, 0, 0, 1}
+}
+
+// newEOFGrammar returns the parser and the lexer of the grammar:
+//
+//	s : items EOF ;
+//	items : item | item items ;
+//	item : A | end | C ;
+//	end : B EOF tail ;
+//	tail : | A ;
+//	A : 'a' ;
+//	B : 'b' ;
+//	C : 'c' CEND ;
+//	fragment CEND : 'd' | EOF ;
+func newEOFGrammar() (*testParser, *testLexer) {
+	const A, B, C = 1, 2, 3
+
+	lexerBuilder := &atnBuilder{grammarType: antlr.ATNTypeLexer, maxTokenType: C}
+	var starts, stops [4]int
+	for i, tokenType := range []int{A, B, C, 0} {
+		starts[i], stops[i] = lexerBuilder.rule(tokenType)
+	}
+	lexerBuilder.alt(0, starts[0], stops[0], atom('a'))
+	lexerBuilder.alt(1, starts[1], stops[1], atom('b'))
+	lexerBuilder.alt(2, starts[2], stops[2], atom('c'), ruleRef(starts[3], 3))
+	lexerBuilder.alt(3, starts[3], stops[3], atom('d'))
+	lexerBuilder.alt(3, starts[3], stops[3], eof())
+	lexer := &testLexer{antlr.NewBaseLexer(nil), lexerBuilder.build()}
+	lexer.RuleNames = []string{"A", "B", "C", "CEND"}
+
+	b := &atnBuilder{grammarType: antlr.ATNTypeParser, maxTokenType: C}
+	var ruleStarts, ruleStops [5]int
+	for i := range ruleStarts {
+		ruleStarts[i], ruleStops[i] = b.rule(0)
+	}
+	b.alt(0, ruleStarts[0], ruleStops[0], ruleRef(ruleStarts[1], 1), eof())
+	b.alt(1, ruleStarts[1], ruleStops[1], ruleRef(ruleStarts[2], 2))
+	b.alt(1, ruleStarts[1], ruleStops[1], ruleRef(ruleStarts[2], 2), ruleRef(ruleStarts[1], 1))
+	b.alt(2, ruleStarts[2], ruleStops[2], atom(A))
+	b.alt(2, ruleStarts[2], ruleStops[2], ruleRef(ruleStarts[3], 3))
+	b.alt(2, ruleStarts[2], ruleStops[2], atom(C))
+	b.alt(3, ruleStarts[3], ruleStops[3], atom(B), eof(), ruleRef(ruleStarts[4], 4))
+	b.alt(4, ruleStarts[4], ruleStops[4])
+	b.alt(4, ruleStarts[4], ruleStops[4], atom(A))
+	parser := &testParser{antlr.NewBaseParser(nil), b.build()}
+	parser.RuleNames = []string{"s", "items", "item", "end", "tail"}
+	return parser, lexer
+}
+
+func TestEndModel(t *testing.T) {
+	parser, lexer := newEOFGrammar()
+	parserEnds := endModels.get(parser.GetATN())
+	lexerEnds := endModels.get(lexer.GetATN())
+	startState := func(atn *antlr.ATN, ruleIndex int) int {
+		return atn.GetRuleIndexToStartStateSlice()[ruleIndex].GetStateNumber()
+	}
+
+	tests := []struct {
+		name      string
+		model     *endModel
+		ruleIndex int
+		empty     bool
+		avoidable bool
+	}{
+		{"s", parserEnds, 0, false, false},
+		{"items", parserEnds, 1, false, true},
+		{"item", parserEnds, 2, false, true},
+		{"end", parserEnds, 3, false, false},
+		{"tail", parserEnds, 4, true, true},
+		{"C", lexerEnds, 2, false, true},
+		{"CEND", lexerEnds, 3, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := startState(tt.model.atn, tt.ruleIndex)
+			if got := tt.model.empty[state]; got != tt.empty {
+				t.Errorf("empty = %v, want %v", got, tt.empty)
+			}
+			if got := tt.model.avoidable[state]; got != tt.avoidable {
+				t.Errorf("avoidable = %v, want %v", got, tt.avoidable)
+			}
+		})
+	}
+}
+
+func TestATNWalker_DecodeEOF(t *testing.T) {
+	parser, lexer := newEOFGrammar()
+	// nothing follows an end rule or a C without a d, i.e., they can only be the last item
+	language := regexp.MustCompile(`^(a|cd)*(a|cd|b|c)$`)
+	forms := map[string]*regexp.Regexp{
+		"end rule":   regexp.MustCompile(`b$`),
+		"lexer EOF":  regexp.MustCompile(`[^d]c$|^c$`),
+		"many items": regexp.MustCompile(`(a|cd).`),
+	}
+	newWalker := func() *ATNWalker {
+		walker := NewATNWalker(parser, lexer)
+		// the default strategy avoids the recursion of items once the data is exhausted
+		walker.SetRoutingStrategy(&RandomStrategy{})
+		return walker
+	}
+	for output := range decodeRandom(t, newWalker, 1000) {
+		if !language.MatchString(output) {
+			t.Errorf("Decode() = %q which is not in the language", output)
+		}
+		for name, form := range forms {
+			if form.MatchString(output) {
+				delete(forms, name)
+			}
+		}
+	}
+	for name := range forms {
+		t.Errorf("Decode() never produced an output with %s", name)
+	}
+}
+
+// eofTree builds parse trees of the EOF grammar like a parser generated by ANTLR, i.e., with EOF tokens
+type eofTree struct{}
+
+func (eofTree) rule(ruleIndex int, children ...antlr.Tree) antlr.Tree {
+	ctx := antlr.NewBaseParserRuleContext(nil, -1)
+	ctx.RuleIndex = ruleIndex
+	for _, child := range children {
+		switch c := child.(type) {
+		case *antlr.BaseParserRuleContext:
+			c.SetParent(ctx)
+			ctx.AddChild(c)
+		case antlr.TerminalNode:
+			ctx.AddTokenNode(c.GetSymbol())
+		}
+	}
+	return ctx
+}
+
+func (eofTree) token(tokenType int, text string) antlr.Tree {
+	token := antlr.NewCommonToken(&antlr.TokenSourceCharStreamPair{}, tokenType, antlr.TokenDefaultChannel, -1, -1)
+	token.SetText(text)
+	return antlr.NewTerminalNodeImpl(token)
+}
+
+func TestATNWalker_EncodeEOF(t *testing.T) {
+	parser, lexer := newEOFGrammar()
+	var b eofTree
+	end := b.token(antlr.TokenEOF, "<EOF>")
+	item := func(tokenType int, text string) antlr.Tree {
+		return b.rule(2, b.token(tokenType, text))
+	}
+	items := func(children ...antlr.Tree) antlr.Tree {
+		tree := b.rule(1, children[len(children)-1])
+		for i := len(children) - 2; i >= 0; i-- {
+			tree = b.rule(1, children[i], tree)
+		}
+		return tree
+	}
+
+	tests := []struct {
+		name string
+		tree antlr.Tree
+		want string
+	}{
+		{"one token", b.rule(0, items(item(1, "a")), end), "a"},
+		{"tokens", b.rule(0, items(item(1, "a"), item(3, "cd"), item(1, "a")), end), "acda"},
+		{"end rule", b.rule(0, items(item(1, "a"), b.rule(2, b.rule(3, b.token(2, "b"), end, b.rule(4)))), end), "ab"},
+		{"lexer EOF", b.rule(0, items(item(3, "cd"), item(3, "c")), end), "cdc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := NewATNWalker(parser, lexer).Encode(tt.tree)
+			if got := NewATNWalker(parser, lexer).Decode(encoded, nil); got != tt.want {
+				t.Errorf("Decode(Encode()) = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
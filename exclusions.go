@@ -0,0 +1,315 @@
+package atnwalk
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+// RuleExclusions lists the rules (parser or lexer rules) that are never generated, the excluded choices of decision
+// states, and the restricted decision states that only allow the listed choices. Decision states are numbered per
+// rule like in RuleWeights.
+type RuleExclusions struct {
+	Rules      map[string]struct{}
+	Excluded   map[string]map[int][]int
+	Restricted map[string]map[int][]int
+}
+
+func NewRuleExclusions() *RuleExclusions {
+	return &RuleExclusions{
+		Rules:      map[string]struct{}{},
+		Excluded:   map[string]map[int][]int{},
+		Restricted: map[string]map[int][]int{}}
+}
+
+// LoadRuleExclusions reads exclusions from a file, each line has one of the formats:
+//
+//	exclude RULE_NAME
+//	exclude RULE_NAME DECISION CHOICE...
+//	restrict RULE_NAME DECISION CHOICE...
+//
+// Empty lines and lines starting with '#' are ignored.
+func LoadRuleExclusions(path string) (*RuleExclusions, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadRuleExclusions(file, path)
+}
+
+// ReadRuleExclusions reads exclusions in the format of LoadRuleExclusions, the name is only used for error messages.
+func ReadRuleExclusions(reader io.Reader, name string) (*RuleExclusions, error) {
+	exclusions := NewRuleExclusions()
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		var lists map[string]map[int][]int
+		switch {
+		case fields[0] == "exclude" && len(fields) == 2:
+			exclusions.Rules[fields[1]] = struct{}{}
+			continue
+		case fields[0] == "exclude" && len(fields) >= 4:
+			lists = exclusions.Excluded
+		case fields[0] == "restrict" && len(fields) >= 4:
+			lists = exclusions.Restricted
+		default:
+			return nil, fmt.Errorf("%s:%d: expected exclude RULE_NAME [DECISION CHOICE...] or "+
+				"restrict RULE_NAME DECISION CHOICE...", name, lineNumber)
+		}
+		decision, err := strconv.Atoi(fields[2])
+		if err != nil || decision < 0 {
+			return nil, fmt.Errorf("%s:%d: invalid decision %q", name, lineNumber, fields[2])
+		}
+		choices := make([]int, len(fields)-3)
+		for i, field := range fields[3:] {
+			if choices[i], err = strconv.Atoi(field); err != nil || choices[i] < 0 {
+				return nil, fmt.Errorf("%s:%d: invalid choice %q", name, lineNumber, field)
+			}
+		}
+		if _, ok := lists[fields[1]]; !ok {
+			lists[fields[1]] = map[int][]int{}
+		}
+		lists[fields[1]][decision] = append(lists[fields[1]][decision], choices...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return exclusions, nil
+}
+
+// WithExclusions wraps the strategy with the exclusions of the file (see LoadRuleExclusions), an empty file name
+// returns the strategy as is.
+func WithExclusions(strategy RoutingStrategy, exclusionsFile string, parser antlr.Parser,
+	lexer antlr.Lexer) (RoutingStrategy, error) {
+	if exclusionsFile == "" {
+		return strategy, nil
+	}
+	exclusions, err := LoadRuleExclusions(exclusionsFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewATNWalker(parser, lexer).NewExclusionStrategy(exclusions, strategy)
+}
+
+// ExclusionStrategy routes with another strategy but never takes a disabled choice, it implements ChoiceFilter so that
+// the walker also maps decoded choices to allowed ones. Choices are disabled per decision state number and they
+// include all choices that cannot reach the rule stop state without an excluded rule or choice. Use
+// ATNWalker.NewExclusionStrategy to obtain the state numbers from RuleExclusions.
+type ExclusionStrategy struct {
+	Strategy      RoutingStrategy
+	ParserChoices map[int]map[int]struct{}
+	LexerChoices  map[int]map[int]struct{}
+	// the token types that the parser must not produce
+	Tokens map[int]struct{}
+}
+
+func (s *ExclusionStrategy) Route(router *Router, state int, rootPathRules map[int]struct{}) int {
+	choice := s.Strategy.Route(router, state, rootPathRules)
+	if s.ChoiceDisabled(router.isLexerRule, state, choice) {
+		// the remaining choices of a planned route would not match the states anymore
+		router.nextChoices = &Stack[int]{}
+		choice = router.allowedChoice(state, choice)
+	}
+	return choice
+}
+
+func (s *ExclusionStrategy) ObserveChoice(router *Router, state, choice int) {
+	if observer, ok := s.Strategy.(ChoiceObserver); ok {
+		observer.ObserveChoice(router, state, choice)
+	}
+}
+
+func (s *ExclusionStrategy) ChoiceDisabled(isLexerRule bool, state, choice int) bool {
+	choices := s.ParserChoices
+	if isLexerRule {
+		choices = s.LexerChoices
+	}
+	_, ok := choices[state][choice]
+	return ok
+}
+
+func (s *ExclusionStrategy) TokenDisabled(tokenType int) bool {
+	_, ok := s.Tokens[tokenType]
+	return ok
+}
+
+// NewExclusionStrategy resolves the rule names and decisions of the exclusions to the ATN states of the walker's
+// parser and lexer and wraps the strategy (nil is the DefaultStrategy). Unknown rules or decisions and choices that do
+// not exist are reported as errors.
+func (w *ATNWalker) NewExclusionStrategy(exclusions *RuleExclusions,
+	strategy RoutingStrategy) (*ExclusionStrategy, error) {
+	if strategy == nil {
+		strategy = &DefaultStrategy{}
+	}
+	s := &ExclusionStrategy{
+		Strategy:      strategy,
+		ParserChoices: map[int]map[int]struct{}{},
+		LexerChoices:  map[int]map[int]struct{}{},
+		Tokens:        map[int]struct{}{}}
+	parserRules, lexerRules := map[int]struct{}{}, map[int]struct{}{}
+	recognizers := []struct {
+		ruleNames []string
+		atn       *antlr.ATN
+		rules     map[int]struct{}
+		choices   map[int]map[int]struct{}
+	}{
+		{w.Parser.GetRuleNames(), w.Parser.GetATN(), parserRules, s.ParserChoices},
+		{w.Lexer.GetRuleNames(), w.Lexer.GetATN(), lexerRules, s.LexerChoices},
+	}
+	for ruleName := range exclusions.Rules {
+		found := false
+		for _, recognizer := range recognizers {
+			for ruleIndex, name := range recognizer.ruleNames {
+				if name == ruleName {
+					recognizer.rules[ruleIndex] = struct{}{}
+					found = true
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown rule %q", ruleName)
+		}
+	}
+	for _, lists := range []struct {
+		decisions  map[string]map[int][]int
+		isRestrict bool
+	}{{exclusions.Excluded, false}, {exclusions.Restricted, true}} {
+		for ruleName, decisions := range lists.decisions {
+			found := false
+			for _, recognizer := range recognizers {
+				for ruleIndex, name := range recognizer.ruleNames {
+					if name != ruleName {
+						continue
+					}
+					found = true
+					decisionStates := ruleDecisionStates(recognizer.atn, ruleIndex)
+					for decision, choices := range decisions {
+						if decision >= len(decisionStates) {
+							return nil, fmt.Errorf("rule %s has only %d decisions but got choices for decision %d",
+								ruleName, len(decisionStates), decision)
+						}
+						state := decisionStates[decision]
+						numChoices := len(recognizer.atn.GetStates()[state].GetTransitions())
+						listed := map[int]struct{}{}
+						for _, choice := range choices {
+							if choice >= numChoices {
+								return nil, fmt.Errorf("decision %d of rule %s has %d choices but got choice %d",
+									decision, ruleName, numChoices, choice)
+							}
+							listed[choice] = struct{}{}
+						}
+						for choice := 0; choice < numChoices; choice++ {
+							if _, ok := listed[choice]; ok != lists.isRestrict {
+								disableChoice(recognizer.choices, state, choice)
+							}
+						}
+					}
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unknown rule %q", ruleName)
+			}
+		}
+	}
+
+	// the lexer goes first since the parser must not produce the tokens of which all lexer rules are excluded or dead
+	lexerAlive := disableDeadChoices(w.Lexer.GetATN(), lexerRules, s.LexerChoices, nil)
+	for tokenType, ruleIndices := range w.lexerModel().tokenRules {
+		disabled := true
+		for _, ruleIndex := range ruleIndices {
+			startState := w.Lexer.GetATN().GetRuleIndexToStartStateSlice()[ruleIndex]
+			if _, ok := lexerRules[ruleIndex]; !ok && lexerAlive[startState.GetStateNumber()] {
+				disabled = false
+			}
+		}
+		if disabled {
+			s.Tokens[tokenType] = struct{}{}
+		}
+	}
+	disableDeadChoices(w.Parser.GetATN(), parserRules, s.ParserChoices, s.Tokens)
+	return s, nil
+}
+
+func disableChoice(choices map[int]map[int]struct{}, state, choice int) {
+	if _, ok := choices[state]; !ok {
+		choices[state] = map[int]struct{}{}
+	}
+	choices[state][choice] = struct{}{}
+}
+
+// disableDeadChoices determines the states that reach the rule stop state without disabled choices, excluded rules,
+// or disabled tokens (nil for lexers) until a fixed point is reached, then it disables the choices of all decision
+// states that lead to other states. It returns whether each state reaches the rule stop state.
+func disableDeadChoices(atn *antlr.ATN, rules map[int]struct{}, choices map[int]map[int]struct{},
+	tokens map[int]struct{}) []bool {
+	states := atn.GetStates()
+	alive := make([]bool, len(states))
+	for i, state := range states {
+		alive[i] = state != nil && state.GetStateType() == antlr.ATNStateRuleStop
+	}
+	isAllowed := func(state antlr.ATNState, choice int) bool {
+		if _, ok := choices[state.GetStateNumber()][choice]; ok {
+			return false
+		}
+		switch t := state.GetTransitions()[choice].(type) {
+		case *antlr.RuleTransition:
+			if _, ok := rules[t.GetRuleIndex()]; ok {
+				return false
+			}
+			startState := atn.GetRuleIndexToStartStateSlice()[t.GetRuleIndex()]
+			return alive[startState.GetStateNumber()] && alive[t.GetFollowState().GetStateNumber()]
+		case *antlr.AtomTransition:
+			if _, ok := tokens[t.GetLabelValue()]; ok {
+				return false
+			}
+		case *antlr.SetTransition:
+			if tokens != nil {
+				allowedTokens := 0
+				for i := 0; i < t.GetLabel().Length(); i++ {
+					if _, ok := tokens[t.GetLabel().Get(i)]; !ok {
+						allowedTokens++
+					}
+				}
+				if allowedTokens == 0 {
+					return false
+				}
+			}
+		}
+		return alive[state.GetTransitions()[choice].(antlr.AnyTransition).GetTarget().GetStateNumber()]
+	}
+	for changed := true; changed; {
+		changed = false
+		for i, state := range states {
+			if state == nil || alive[i] {
+				continue
+			}
+			for choice := range state.GetTransitions() {
+				if isAllowed(state, choice) {
+					alive[i] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	for _, state := range states {
+		if state == nil || len(state.GetTransitions()) < 2 {
+			continue
+		}
+		for choice := range state.GetTransitions() {
+			if !isAllowed(state, choice) {
+				disableChoice(choices, state.GetStateNumber(), choice)
+			}
+		}
+	}
+	return alive
+}
@@ -0,0 +1,103 @@
+package atnwalk
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadRuleExclusions(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    *RuleExclusions
+		wantErr bool
+	}{
+		{
+			"Rules, excluded and restricted choices with comments and empty lines",
+			"# comment\nexclude attach_stmt\n\nexclude sql_stmt 0 1 2\nexclude sql_stmt 0 4\nrestrict IDENTIFIER 1 0\n",
+			&RuleExclusions{
+				Rules:      map[string]struct{}{"attach_stmt": {}},
+				Excluded:   map[string]map[int][]int{"sql_stmt": {0: {1, 2, 4}}},
+				Restricted: map[string]map[int][]int{"IDENTIFIER": {1: {0}}}},
+			false},
+		{
+			"Restricted rule without decision",
+			"restrict sql_stmt\n",
+			nil, true},
+		{
+			"Missing choices",
+			"exclude sql_stmt 0\n",
+			nil, true},
+		{
+			"Negative choice",
+			"exclude sql_stmt 0 -1\n",
+			nil, true},
+		{
+			"Unknown keyword",
+			"include sql_stmt 0 1\n",
+			nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadRuleExclusions(strings.NewReader(tt.content), "exclusions.txt")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadRuleExclusions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadRuleExclusions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestATNWalker_NewExclusionStrategy(t *testing.T) {
+	parser, lexer := newTestGrammar()
+	tests := []struct {
+		name    string
+		content string
+		// the outputs that the exclusions allow, the outputs are two characters, i.e., these are all possible outputs
+		want    string
+		wantErr bool
+	}{
+		{"Excluded lexer rule", "exclude ATTACH\n", "sx sy sz s0 s1 s2 s3 s4 s5 s6 s7 s8 s9", false},
+		// the third alternative still produces NUM
+		{"Excluded choice", "exclude expr 0 1\n", "sx sy sz s0 s1 s2 s3 s4 s5 s6 s7 s8 s9 ax ay az", false},
+		// excluding a fragment disables NUM in the set and the second alternative of expr
+		{"Excluded fragment", "exclude DIGIT\n", "sx sy sz ax ay az", false},
+		{"Restricted choices", "restrict stmt 0 1\n", "ax ay az", false},
+		{"Unknown rule", "exclude unknown\n", "", true},
+		{"Unknown decision", "exclude expr 1 0\n", "", true},
+		{"Unknown choice", "restrict expr 0 2\n", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exclusions, err := ReadRuleExclusions(strings.NewReader(tt.content), "exclusions.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			strategy, err := NewATNWalker(parser, lexer).NewExclusionStrategy(exclusions, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewExclusionStrategy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			for _, routing := range []RoutingStrategy{&DefaultStrategy{}, &RandomStrategy{}} {
+				strategy.Strategy = routing
+				outputs := decodeRandom(t, func() *ATNWalker {
+					walker := NewATNWalker(parser, lexer)
+					walker.SetRoutingStrategy(strategy)
+					return walker
+				}, 3000)
+				want := map[string]struct{}{}
+				for _, output := range strings.Fields(tt.want) {
+					want[output] = struct{}{}
+				}
+				if !reflect.DeepEqual(outputs, want) {
+					t.Errorf("Decode() with %T produced %v, want %v", routing, outputs, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package atnwalk
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// in the arguments of the target, this placeholder is substituted with the path of a file containing the input,
+// without the placeholder, the input is written to the standard input of the target
+const InputPlaceholder = "@@"
+
+const (
+	// instrumented targets attach the System V shared memory segment with this ID and record the edges they hit
+	AFLShmIdEnv   = "__AFL_SHM_ID"
+	AFLMapSizeEnv = "AFL_MAP_SIZE"
+
+	DefaultMapSize = 1 << 16
+)
+
+const (
+	// the corpus of encoded inputs is kept in memory, once full, inputs are replaced at random
+	MaxCorpusSize = 4096
+	// number of random bytes that are decoded to generate a new input
+	generationBytes = 8
+)
+
+// ExecResult is the outcome of executing the target once.
+type ExecResult struct {
+	ExitCode int
+	Signal   syscall.Signal
+	TimedOut bool
+}
+
+// Crashed reports whether the target was killed by a signal, exited with a non-zero exit code, or timed out.
+func (r ExecResult) Crashed() bool {
+	return r.TimedOut || r.Signal != 0 || r.ExitCode != 0
+}
+
+// Kind describes the crash, e.g., "signal-SIGSEGV", "exit-1", or "timeout".
+func (r ExecResult) Kind() string {
+	switch {
+	case r.TimedOut:
+		return "timeout"
+	case r.Signal != 0:
+		return "signal-" + signalName(r.Signal)
+	case r.ExitCode != 0:
+		return fmt.Sprintf("exit-%d", r.ExitCode)
+	}
+	return "ok"
+}
+
+func signalName(signal syscall.Signal) string {
+	switch signal {
+	case syscall.SIGSEGV:
+		return "SIGSEGV"
+	case syscall.SIGABRT:
+		return "SIGABRT"
+	case syscall.SIGBUS:
+		return "SIGBUS"
+	case syscall.SIGFPE:
+		return "SIGFPE"
+	case syscall.SIGILL:
+		return "SIGILL"
+	case syscall.SIGKILL:
+		return "SIGKILL"
+	}
+	return fmt.Sprintf("SIG%d", int(signal))
+}
+
+// RunTarget executes the target with the input, either through the standard input or through the input file
+// if the arguments contain the InputPlaceholder. The target inherits the environment extended by env and is killed
+// (with its process group) after the timeout.
+func RunTarget(args, env []string, input []byte, inputFile string, timeout time.Duration) (ExecResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	targetArgs := make([]string, len(args))
+	usesFile := false
+	for i, arg := range args {
+		if strings.Contains(arg, InputPlaceholder) {
+			arg = strings.ReplaceAll(arg, InputPlaceholder, inputFile)
+			usesFile = true
+		}
+		targetArgs[i] = arg
+	}
+	if usesFile {
+		if err := os.WriteFile(inputFile, input, 0644); err != nil {
+			return ExecResult{}, err
+		}
+	}
+
+	cmd := exec.Command(targetArgs[0], targetArgs[1:]...)
+	if !usesFile {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return ExecResult{}, err
+	}
+	done, killed := make(chan struct{}), make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			killed <- true
+		case <-done:
+			killed <- false
+		}
+	}()
+	err := cmd.Wait()
+	close(done)
+	timedOut := <-killed
+
+	result := ExecResult{TimedOut: timedOut}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			result.Signal = status.Signal()
+		} else {
+			result.ExitCode = exitErr.ExitCode()
+		}
+	} else if err != nil {
+		return result, err
+	}
+	if timedOut {
+		// the target was killed by us, the signal is not a crash of its own
+		result.Signal = 0
+		result.ExitCode = 0
+	}
+	return result, nil
+}
+
+// Fuzzer generates, mutates, and crosses over encoded inputs of an in-memory corpus, executes the target with the
+// decoded inputs, and saves the crashing inputs to the crashes directory (deduplicated by the decoded text).
+// Without a coverage map, all inputs that do not crash are kept in the corpus, with a coverage map, only inputs that
+// hit new edges of the instrumented target are kept.
+type Fuzzer struct {
+	Target      []string
+	ExecTimeout time.Duration
+	OutputDir   string
+	Coverage    *CoverageMap
+
+	// decode decodes the data and returns the decoded text and the (repaired) encoded data
+	decode func(data []byte) (string, []byte)
+	// the name of the rule that decoding starts with, seeds that record another start rule are rejected
+	startRule string
+	prng      *rand.Rand
+	corpus    [][]byte
+	crashes   map[string]struct{}
+
+	Execs       uint64
+	Crashes     uint64
+	Hangs       uint64
+	NewCoverage uint64
+}
+
+// NewFuzzer creates a fuzzer that decodes the inputs with the grammar of the parser and the lexer starting with the
+// rule (empty for the first rule of the grammar), decoding a single input may take decodeTimeout.
+func NewFuzzer(parser antlr.Parser, lexer antlr.Lexer, strategy RoutingStrategy, startRule string,
+	decodeTimeout time.Duration, seed int64) (*Fuzzer, error) {
+	index, err := ruleIndex(parser, startRule)
+	if err != nil {
+		return nil, err
+	}
+	return &Fuzzer{
+		ExecTimeout: time.Second,
+		OutputDir:   "./fuzz-out",
+		startRule:   parser.GetRuleNames()[index],
+		decode: func(data []byte) (string, []byte) {
+			walker := NewATNWalker(parser, lexer)
+			walker.SetRoutingStrategy(strategy)
+			walker.SetStartRule(startRule)
+			walker.SetDeadline(time.Now().Add(decodeTimeout))
+			writeBack := &([]byte{})
+			decoded := walker.Decode(data, writeBack)
+			if walker.TimedOut() {
+				return "", nil
+			}
+			return decoded, *writeBack
+		},
+		prng:    rand.New(rand.NewSource(seed)),
+		crashes: map[string]struct{}{},
+	}, nil
+}
+
+func (f *Fuzzer) crashesDir() string {
+	return filepath.Join(f.OutputDir, "crashes")
+}
+
+// AddSeeds adds the encoded inputs of all files in the directory to the corpus, the records of the start rule are
+// stripped (see WithStartRule) and seeds that record another start rule than the fuzzer's fail.
+func (f *Fuzzer) AddSeeds(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		recorded, data := SplitStartRule(data)
+		if err = checkStartRule(recorded, f.startRule); err != nil {
+			return fmt.Errorf("seed %s: %v", entry.Name(), err)
+		}
+		f.addToCorpus(data)
+	}
+	return nil
+}
+
+func (f *Fuzzer) addToCorpus(encoded []byte) {
+	if len(encoded) == 0 {
+		return
+	}
+	if len(f.corpus) < MaxCorpusSize {
+		f.corpus = append(f.corpus, encoded)
+	} else {
+		f.corpus[f.prng.Intn(MaxCorpusSize)] = encoded
+	}
+}
+
+// nextInput generates a new input or mutates (and crosses over) inputs of the corpus.
+func (f *Fuzzer) nextInput() []byte {
+	if len(f.corpus) == 0 || f.prng.Intn(8) == 0 {
+		data := make([]byte, generationBytes)
+		f.prng.Read(data)
+		return data
+	}
+	data := f.corpus[f.prng.Intn(len(f.corpus))]
+	if len(f.corpus) > 1 && f.prng.Intn(2) == 0 {
+		data = Crossover(data, f.corpus[f.prng.Intn(len(f.corpus))], f.prng.Int63())
+	}
+	return Mutate(data, f.prng.Int63())
+}
+
+// Step executes the target with one input and reports the result and whether a new crash was saved.
+func (f *Fuzzer) Step() (ExecResult, bool, error) {
+	decoded, encoded := f.decode(f.nextInput())
+	if encoded == nil {
+		// decoding exceeded the timeout, do not waste an execution
+		return ExecResult{}, false, nil
+	}
+	if err := os.MkdirAll(f.crashesDir(), 0755); err != nil {
+		return ExecResult{}, false, err
+	}
+	var env []string
+	if f.Coverage != nil {
+		f.Coverage.Reset()
+		env = f.Coverage.Env()
+	}
+	result, err := RunTarget(f.Target, env, []byte(decoded), filepath.Join(f.OutputDir, ".cur_input"), f.ExecTimeout)
+	if err != nil {
+		return result, false, err
+	}
+	f.Execs++
+	if !result.Crashed() {
+		if f.Coverage == nil {
+			f.addToCorpus(encoded)
+		} else if hasNewBits, _ := f.Coverage.Update(); hasNewBits {
+			f.addToCorpus(encoded)
+			f.NewCoverage++
+		}
+		return result, false, nil
+	}
+	isNew, err := f.saveCrash(result, decoded, encoded)
+	return result, isNew, err
+}
+
+// saveCrash saves the encoded and the decoded input unless a crash with the same decoded text was saved before.
+func (f *Fuzzer) saveCrash(result ExecResult, decoded string, encoded []byte) (bool, error) {
+	sum := sha256.Sum256([]byte(decoded))
+	hash := hex.EncodeToString(sum[:8])
+	if _, ok := f.crashes[hash]; ok {
+		return false, nil
+	}
+	f.crashes[hash] = struct{}{}
+	if result.TimedOut {
+		f.Hangs++
+	} else {
+		f.Crashes++
+	}
+	name := filepath.Join(f.crashesDir(), hash+"."+result.Kind())
+	if err := os.WriteFile(name+".bytes", encoded, 0644); err != nil {
+		return true, err
+	}
+	return true, os.WriteFile(name+".txt", []byte(decoded), 0644)
+}
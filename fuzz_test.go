@@ -0,0 +1,115 @@
+package atnwalk
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunTarget(t *testing.T) {
+	inputFile := filepath.Join(t.TempDir(), "input")
+	tests := []struct {
+		name    string
+		args    []string
+		crashed bool
+		kind    string
+	}{
+		{"stdin", []string{"sh", "-c", "test \"$(cat)\" = 'SELECT 1;'"}, false, "ok"},
+		{"file", []string{"sh", "-c", "test \"$(cat $0)\" = 'SELECT 1;'", InputPlaceholder}, false, "ok"},
+		{"env", []string{"sh", "-c", "test \"$ATNWALK_TEST\" = 42"}, false, "ok"},
+		{"exit", []string{"sh", "-c", "exit 3"}, true, "exit-3"},
+		{"signal", []string{"sh", "-c", "kill -SEGV $$"}, true, "signal-SIGSEGV"},
+		{"timeout", []string{"sh", "-c", "sleep 5"}, true, "timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := RunTarget(tt.args, []string{"ATNWALK_TEST=42"}, []byte("SELECT 1;"), inputFile,
+				200*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Crashed() != tt.crashed || result.Kind() != tt.kind {
+				t.Errorf("RunTarget() = %+v (%s), want crashed %v (%s)", result, result.Kind(), tt.crashed, tt.kind)
+			}
+		})
+	}
+	result, _ := RunTarget([]string{"sh", "-c", "kill -ABRT $$"}, nil, nil, inputFile, time.Second)
+	if result.Signal != syscall.SIGABRT {
+		t.Errorf("RunTarget() = %+v, want SIGABRT", result)
+	}
+}
+
+func TestFuzzer_Step(t *testing.T) {
+	// decoding is replaced with a stand-in so that inputs starting with an even byte crash the target
+	f := &Fuzzer{
+		Target:      []string{"sh", "-c", "case \"$(cat)\" in crash*) kill -SEGV $$;; esac"},
+		ExecTimeout: time.Second,
+		OutputDir:   t.TempDir(),
+		decode: func(data []byte) (string, []byte) {
+			if data[0]%2 == 0 {
+				return "crash", data
+			}
+			return "fine", data
+		},
+		prng:    rand.New(rand.NewSource(1)),
+		crashes: map[string]struct{}{},
+	}
+	saved := 0
+	for i := 0; i < 32; i++ {
+		result, isNew, err := f.Step()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isNew {
+			saved++
+			if result.Kind() != "signal-SIGSEGV" {
+				t.Errorf("Step() saved a crash of kind %s", result.Kind())
+			}
+		}
+	}
+
+	// all crashes decode to the same text, hence, they are deduplicated
+	files, _ := os.ReadDir(filepath.Join(f.OutputDir, "crashes"))
+	if saved != 1 || f.Crashes != 1 || len(files) != 2 {
+		t.Errorf("Step() saved %d crashes, counted %d, wrote %d files, want 1, 1, 2", saved, f.Crashes, len(files))
+	}
+	if f.Execs != 32 || len(f.corpus) == 0 {
+		t.Errorf("Step() executed %d times with a corpus of %d inputs", f.Execs, len(f.corpus))
+	}
+}
+
+func TestFuzzer_AddSeeds(t *testing.T) {
+	parser, lexer := newTestGrammar()
+	f, err := NewFuzzer(parser, lexer, nil, "expr", time.Second, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	seeds := map[string][]byte{"plain": {1, 2}, "recorded": WithStartRule([]byte{3, 4}, "expr")}
+	for name, data := range seeds {
+		if err = os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = f.AddSeeds(dir); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.corpus) != 2 {
+		t.Fatalf("AddSeeds() added %d seeds, want 2", len(f.corpus))
+	}
+	for _, data := range f.corpus {
+		if startRule, _ := SplitStartRule(data); startRule != "" || len(data) != 2 {
+			t.Errorf("AddSeeds() added %q, want the seed without the record", data)
+		}
+	}
+
+	if err = os.WriteFile(filepath.Join(dir, "other"), WithStartRule([]byte{5}, "stmt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.AddSeeds(dir); err == nil {
+		t.Errorf("AddSeeds() accepted a seed that records another start rule")
+	}
+}
@@ -0,0 +1,61 @@
+// Package fuzzadapter plugs ATNWalk into fuzzing engines other than AFL++: Go's native fuzzing (testing.F, see the
+// package fuzztest) decodes the inputs of the fuzzer into grammar-valid text, libFuzzer mutates and crosses over the
+// encoded inputs with the exported LLVMFuzzerCustomMutator and LLVMFuzzerCustomCrossOver (requires cgo).
+package fuzzadapter
+
+import (
+	"atnwalk"
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+	"time"
+)
+
+// DefaultBudget is the time that decoding a single input may take.
+const DefaultBudget = 100 * time.Millisecond
+
+// Adapter decodes the inputs of a fuzzer with the grammar of the parser and the lexer.
+type Adapter struct {
+	parser    antlr.Parser
+	lexer     antlr.Lexer
+	budget    time.Duration
+	strategy  atnwalk.RoutingStrategy
+	startRule string
+}
+
+func New(parser antlr.Parser, lexer antlr.Lexer, budget time.Duration) *Adapter {
+	return &Adapter{parser: parser, lexer: lexer, budget: budget, strategy: &atnwalk.DefaultStrategy{}}
+}
+
+// SetRoutingStrategy sets the strategy that chooses transitions once the data of an input is exhausted.
+func (a *Adapter) SetRoutingStrategy(strategy atnwalk.RoutingStrategy) {
+	a.strategy = strategy
+}
+
+// SetStartRule sets the parser rule that decoding starts with, e.g., to fuzz a sub-language of the grammar.
+func (a *Adapter) SetStartRule(name string) error {
+	if err := atnwalk.NewATNWalker(a.parser, a.lexer).SetStartRule(name); err != nil {
+		return err
+	}
+	a.startRule = name
+	return nil
+}
+
+// Decode turns the input of the fuzzer into grammar-valid text, decoding gives up (and returns an empty string)
+// if it exceeds the budget.
+func (a *Adapter) Decode(data []byte) string {
+	walker := atnwalk.NewATNWalker(a.parser, a.lexer)
+	walker.SetRoutingStrategy(a.strategy)
+	walker.SetStartRule(a.startRule)
+	walker.SetDeadline(time.Now().Add(a.budget))
+	return walker.Decode(data, nil)
+}
+
+// mutateInto mutates the first size bytes of data in place, at most len(data) bytes are used, and returns the size
+// of the mutated data.
+func mutateInto(data []byte, size int, seed int64) int {
+	return copy(data, atnwalk.Mutate(data[:size], seed))
+}
+
+// crossOverInto crosses over data1 and data2, writes the result into out (truncated to len(out)), and returns its size.
+func crossOverInto(data1, data2, out []byte, seed int64) int {
+	return copy(out, atnwalk.Crossover(data1, data2, seed))
+}
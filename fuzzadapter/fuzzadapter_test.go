@@ -0,0 +1,139 @@
+package fuzzadapter
+
+import (
+	"atnwalk"
+	"bytes"
+	"math/rand"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+func TestMutateInto(t *testing.T) {
+	data := make([]byte, 64)
+	copy(data, "encoded data")
+	want := atnwalk.Mutate([]byte("encoded data"), 42)
+	if n := mutateInto(data, 12, 42); n != len(want) || !bytes.Equal(data[:n], want) {
+		t.Errorf("mutateInto() = %v, want %v", data[:n], want)
+	}
+
+	// the mutated data is truncated to the capacity
+	data = []byte("encoded data")
+	if n := mutateInto(data[:4], 4, 1); n > 4 {
+		t.Errorf("mutateInto() = %d exceeds the capacity of 4", n)
+	}
+	if n := mutateInto(data, 0, 1); n != 0 {
+		t.Errorf("mutateInto() of empty data = %d, want 0", n)
+	}
+}
+
+func TestCrossOverInto(t *testing.T) {
+	data1, data2 := []byte("first encoded data"), []byte("second encoded data")
+	want := atnwalk.Crossover(data1, data2, 7)
+	out := make([]byte, 64)
+	if n := crossOverInto(data1, data2, out, 7); n != len(want) || !bytes.Equal(out[:n], want) {
+		t.Errorf("crossOverInto() = %v, want %v", out[:n], want)
+	}
+	if n := crossOverInto(data1, data2, out[:3], 7); n != 3 || !bytes.Equal(out[:n], want[:3]) {
+		t.Errorf("crossOverInto() = %v, want %v", out[:n], want[:3])
+	}
+}
+
+// the serialized ATNs of a grammar like a generated parser and lexer embed them:
+//
+//	stmt : SELECT expr | ATTACH NAME ;
+//	expr : NAME | NUM ;
+//	SELECT : 's' ;
+//	ATTACH : 'a' ;
+//	NAME : [x-z] ;
+//	NUM : DIGIT ;
+//	fragment DIGIT : [0-9] ;
+var (
+	serializedParserATN = []int32{4, 1, 4, 14, 2, 0, 7, 0, 2, 1, 7, 1, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 1, 1,
+		1, 1, 1, 1, 1, 0, 0, 2, 0, 2, 0, 0, 14, 0, 4, 1, 0, 0, 0, 4, 5, 5, 1, 0, 0, 5, 6, 3, 2, 1, 0, 6, 1, 1, 0, 0, 0,
+		0, 7, 1, 0, 0, 0, 7, 8, 5, 2, 0, 0, 8, 9, 5, 3, 0, 0, 9, 1, 1, 0, 0, 0, 2, 10, 1, 0, 0, 0, 10, 11, 5, 3, 0, 0,
+		11, 3, 1, 0, 0, 0, 2, 12, 1, 0, 0, 0, 12, 13, 5, 4, 0, 0, 13, 3, 1, 0, 0, 0, 0}
+	serializedLexerATN = []int32{4, 0, 4, 20, 2, 0, 7, 0, 2, 1, 7, 1, 2, 2, 7, 2, 2, 3, 7, 3, 2, 4, 7, 4, 1, 0, 1, 0,
+		1, 1, 1, 1, 1, 2, 1, 2, 1, 3, 1, 3, 1, 4, 1, 4, 0, 0, 5, 0, 1, 2, 2, 4, 3, 6, 4, 8, 0, 0, 0, 15, 0, 10, 1, 0,
+		0, 0, 10, 11, 5, 115, 0, 0, 11, 1, 1, 0, 0, 0, 2, 12, 1, 0, 0, 0, 12, 13, 5, 97, 0, 0, 13, 3, 1, 0, 0, 0, 4,
+		14, 1, 0, 0, 0, 14, 15, 2, 120, 122, 0, 15, 5, 1, 0, 0, 0, 6, 16, 1, 0, 0, 0, 16, 17, 3, 8, 4, 0, 17, 7, 1, 0,
+		0, 0, 8, 18, 1, 0, 0, 0, 18, 19, 2, 48, 57, 0, 19, 9, 1, 0, 0, 0, 0, 0}
+)
+
+type testParser struct {
+	*antlr.BaseParser
+	atn *antlr.ATN
+}
+
+func (p *testParser) GetATN() *antlr.ATN {
+	return p.atn
+}
+
+type testLexer struct {
+	*antlr.BaseLexer
+	atn *antlr.ATN
+}
+
+func (l *testLexer) GetATN() *antlr.ATN {
+	return l.atn
+}
+
+func newTestAdapter(budget time.Duration) *Adapter {
+	parser := &testParser{antlr.NewBaseParser(nil), antlr.NewATNDeserializer(nil).Deserialize(serializedParserATN)}
+	parser.RuleNames = []string{"stmt", "expr"}
+	lexer := &testLexer{antlr.NewBaseLexer(nil), antlr.NewATNDeserializer(nil).Deserialize(serializedLexerATN)}
+	lexer.RuleNames = []string{"SELECT", "ATTACH", "NAME", "NUM", "DIGIT"}
+	return New(parser, lexer, budget)
+}
+
+// firstChoice always routes to the first option
+type firstChoice struct{}
+
+func (firstChoice) Route(router *atnwalk.Router, state int, rootPathRules map[int]struct{}) int {
+	return 0
+}
+
+func TestAdapter_Decode(t *testing.T) {
+	stmt, expr := regexp.MustCompile(`^(s[x-z0-9]|a[x-z])$`), regexp.MustCompile(`^[x-z0-9]$`)
+	adapter := newTestAdapter(DefaultBudget)
+	prng := rand.New(rand.NewSource(1))
+	data := make([][]byte, 100)
+	for i := range data {
+		data[i] = make([]byte, prng.Intn(16))
+		prng.Read(data[i])
+	}
+	for _, d := range data {
+		if text := adapter.Decode(d); !stmt.MatchString(text) {
+			t.Errorf("Decode(%x) = %q is not a statement", d, text)
+		}
+	}
+
+	if err := adapter.SetStartRule("missing"); err == nil {
+		t.Errorf("SetStartRule() accepted an unknown rule")
+	}
+	if err := adapter.SetStartRule("expr"); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range data {
+		if text := adapter.Decode(d); !expr.MatchString(text) {
+			t.Errorf("Decode(%x) with the start rule expr = %q", d, text)
+		}
+	}
+
+	// the data of a single byte has no header, i.e., the strategy makes all choices of the parser while the
+	// characters of the tokens still vary
+	adapter.SetRoutingStrategy(firstChoice{})
+	isName := regexp.MustCompile(`^[x-z]$`).MatchString
+	want := isName(adapter.Decode([]byte{1}))
+	for i := 2; i < 32; i++ {
+		if text := adapter.Decode([]byte{byte(i)}); isName(text) != want {
+			t.Errorf("Decode(%x) = %q, want the same alternative for all data", []byte{byte(i)}, text)
+		}
+	}
+
+	if text := newTestAdapter(-time.Second).Decode(data[0]); text != "" {
+		t.Errorf("Decode() exceeding the budget = %q, want an empty text", text)
+	}
+}
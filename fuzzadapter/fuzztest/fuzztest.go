@@ -0,0 +1,39 @@
+// Package fuzztest runs Go's native fuzzing (testing.F) with the inputs decoded by a fuzzadapter.Adapter. It is
+// separate from the package fuzzadapter because it imports the package testing which then would be linked into every
+// binary that imports fuzzadapter, e.g., into the static library for libFuzzer.
+package fuzztest
+
+import "testing"
+
+// F is the part of *testing.F that Fuzz uses.
+type F interface {
+	Add(args ...any)
+	Fuzz(ff any)
+}
+
+// Decoder decodes the inputs of the fuzzer into texts, e.g., a *fuzzadapter.Adapter.
+type Decoder interface {
+	Decode(data []byte) string
+}
+
+// Fuzz runs the fuzz target with the decoded inputs, e.g.:
+//
+//	func FuzzSQLite(f *testing.F) {
+//		adapter := fuzzadapter.New(parser.NewSQLiteParser(nil), parser.NewSQLiteLexer(nil), fuzzadapter.DefaultBudget)
+//		fuzztest.Fuzz(f, adapter, func(t *testing.T, text string) {
+//			// pass the text to the code under test
+//		})
+//	}
+//
+// Inputs that decode to an empty text (e.g., due to the budget of the adapter) are skipped.
+func Fuzz(f F, decoder Decoder, target func(t *testing.T, text string)) {
+	// the fuzzer needs a seed, any bytes decode to grammar-valid text
+	f.Add([]byte{0, 0, 0, 0, 0, 0, 0, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		text := decoder.Decode(data)
+		if text == "" {
+			t.Skip()
+		}
+		target(t, text)
+	})
+}
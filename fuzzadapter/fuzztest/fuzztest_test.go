@@ -0,0 +1,63 @@
+package fuzztest
+
+import (
+	"fmt"
+	"testing"
+)
+
+// fakeF runs the fuzz function with the seeds and some random inputs
+type fakeF struct {
+	t     *testing.T
+	seeds [][]byte
+}
+
+func (f *fakeF) Add(args ...any) {
+	f.seeds = append(f.seeds, args[0].([]byte))
+}
+
+func (f *fakeF) Fuzz(ff any) {
+	fuzz := ff.(func(*testing.T, []byte))
+	inputs := append([][]byte{}, f.seeds...)
+	for i := 0; i < 32; i++ {
+		inputs = append(inputs, []byte{byte(i), byte(i * 7), byte(i * 13)})
+	}
+	for _, input := range inputs {
+		f.t.Run("", func(t *testing.T) { fuzz(t, input) })
+	}
+}
+
+// hexDecoder decodes the inputs into their hex representation, or into an empty text if the budget was exceeded
+type hexDecoder struct {
+	exceeded bool
+}
+
+func (d hexDecoder) Decode(data []byte) string {
+	if d.exceeded {
+		return ""
+	}
+	return fmt.Sprintf("%x", data)
+}
+
+func TestFuzz(t *testing.T) {
+	f := &fakeF{t: t}
+	calls := 0
+	Fuzz(f, hexDecoder{}, func(t *testing.T, text string) {
+		calls++
+		if text == "" {
+			t.Errorf("Fuzz() passed an empty text")
+		}
+	})
+	if len(f.seeds) == 0 {
+		t.Errorf("Fuzz() added no seed")
+	}
+	if calls != len(f.seeds)+32 {
+		t.Errorf("Fuzz() called the target %d times, want %d", calls, len(f.seeds)+32)
+	}
+
+	// inputs that exceed the budget are skipped
+	calls = 0
+	Fuzz(&fakeF{t: t}, hexDecoder{exceeded: true}, func(t *testing.T, text string) { calls++ })
+	if calls != 0 {
+		t.Errorf("Fuzz() called the target %d times with inputs that exceeded the budget", calls)
+	}
+}
@@ -0,0 +1,34 @@
+//go:build cgo
+
+package fuzzadapter
+
+// #include <stddef.h>
+// #include <stdint.h>
+import "C"
+import "unsafe"
+
+/*
+	libFuzzer calls the custom mutator and crossover if the fuzz target is linked with them, e.g., build a static
+	library of a main package that imports this package (go build -buildmode=c-archive) and link it with the target.
+	The fuzz target itself decodes the input, e.g., with a Go function that wraps Adapter.Decode.
+*/
+
+//export LLVMFuzzerCustomMutator
+func LLVMFuzzerCustomMutator(data *C.uint8_t, size, maxSize C.size_t, seed C.uint) C.size_t {
+	if maxSize == 0 {
+		return 0
+	}
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(data)), int(maxSize))
+	return C.size_t(mutateInto(buf, int(size), int64(seed)))
+}
+
+//export LLVMFuzzerCustomCrossOver
+func LLVMFuzzerCustomCrossOver(data1 *C.uint8_t, size1 C.size_t, data2 *C.uint8_t, size2 C.size_t, out *C.uint8_t,
+	maxOutSize C.size_t, seed C.uint) C.size_t {
+	if maxOutSize == 0 {
+		return 0
+	}
+	return C.size_t(crossOverInto(C.GoBytes(unsafe.Pointer(data1), C.int(size1)),
+		C.GoBytes(unsafe.Pointer(data2), C.int(size2)),
+		unsafe.Slice((*byte)(unsafe.Pointer(out)), int(maxOutSize)), int64(seed)))
+}
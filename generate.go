@@ -0,0 +1,165 @@
+package atnwalk
+
+import (
+	"crypto/sha256"
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+	"math/rand"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Generator generates distinct decoded/encoded pairs, e.g., as the initial corpus of a fuzzer. Candidates are
+// numbered and each candidate is generated from its own seed, candidates are accepted in the order of their numbers,
+// hence, the same seed yields the same pairs regardless of the number of workers (given a stateless routing strategy).
+type Generator struct {
+	Count   int
+	Workers int
+	Seed    int64
+	// the decoded size in bytes is within [MinSize, MaxSize] (MaxSize 0 is unlimited) and, if MaxSize is set,
+	// uniformly distributed over the number of Buckets
+	MinSize int
+	MaxSize int
+	Buckets int
+	// candidates decode up to MaxDataBytes random bytes, generation stops after MaxAttempts candidates
+	MaxDataBytes int
+	MaxAttempts  int
+
+	// newDecode returns a decode function per worker that returns the decoded text and the encoded data
+	newDecode func() func(data []byte) (string, []byte)
+}
+
+type candidate struct {
+	number  int
+	decoded string
+	encoded []byte
+}
+
+// NewGenerator creates a generator that decodes with the grammar of the parser and the lexer starting with the rule,
+// an empty start rule is the first rule of the grammar.
+func NewGenerator(parser antlr.Parser, lexer antlr.Lexer, strategy RoutingStrategy, startRule string,
+	decodeTimeout time.Duration) (*Generator, error) {
+	if _, err := ruleIndex(parser, startRule); err != nil {
+		return nil, err
+	}
+	return &Generator{
+		Count:        100,
+		Workers:      runtime.NumCPU(),
+		Seed:         time.Now().UnixNano(),
+		Buckets:      10,
+		MaxDataBytes: 64,
+		MaxAttempts:  100000,
+		newDecode: func() func(data []byte) (string, []byte) {
+			return func(data []byte) (string, []byte) {
+				walker := NewATNWalker(parser, lexer)
+				walker.SetRoutingStrategy(strategy)
+				walker.SetStartRule(startRule)
+				walker.SetDeadline(time.Now().Add(decodeTimeout))
+				writeBack := &([]byte{})
+				decoded := walker.Decode(data, writeBack)
+				if walker.TimedOut() {
+					return "", nil
+				}
+				return decoded, *writeBack
+			}
+		},
+	}, nil
+}
+
+// candidateSeed derives the seed of a candidate with SplitMix64 so that neighboring numbers yield unrelated seeds.
+func candidateSeed(seed int64, number int) int64 {
+	z := uint64(seed) + uint64(number+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return int64(z ^ (z >> 31))
+}
+
+// bucket returns the bucket of the decoded size or -1 if the size is out of range.
+func (g *Generator) bucket(size int) int {
+	if size < g.MinSize || (g.MaxSize > 0 && size > g.MaxSize) {
+		return -1
+	}
+	if g.MaxSize == 0 || g.Buckets <= 1 {
+		return 0
+	}
+	return (size - g.MinSize) * g.Buckets / (g.MaxSize - g.MinSize + 1)
+}
+
+// Run generates the pairs and calls emit for each accepted pair with its id (0 to Count-1), it returns the number of
+// candidates that were generated, i.e., fewer than Count pairs were emitted if it equals MaxAttempts.
+func (g *Generator) Run(emit func(id int, decoded string, encoded []byte) error) (int, error) {
+	numbers := make(chan int)
+	candidates := make(chan candidate, g.Workers)
+	stop := make(chan struct{})
+	workers := &sync.WaitGroup{}
+	for i := 0; i < g.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			decode := g.newDecode()
+			for number := range numbers {
+				prng := rand.New(rand.NewSource(candidateSeed(g.Seed, number)))
+				data := make([]byte, prng.Intn(g.MaxDataBytes)+1)
+				prng.Read(data)
+				decoded, encoded := decode(data)
+				select {
+				case candidates <- candidate{number, decoded, encoded}:
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(numbers)
+		for number := 0; number < g.MaxAttempts; number++ {
+			select {
+			case numbers <- number:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		workers.Wait()
+	}()
+
+	quota := g.Count
+	if g.MaxSize > 0 && g.Buckets > 1 {
+		quota = (g.Count + g.Buckets - 1) / g.Buckets
+	}
+	perBucket := map[int]int{}
+	seen := map[[sha256.Size]byte]struct{}{}
+	pending := map[int]candidate{}
+	accepted, next := 0, 0
+	for accepted < g.Count && next < g.MaxAttempts {
+		c := <-candidates
+		pending[c.number] = c
+
+		// accept the candidates in the order of their numbers
+		for c, ok := pending[next]; ok && accepted < g.Count; c, ok = pending[next] {
+			delete(pending, next)
+			next++
+			if c.encoded == nil {
+				// decoding exceeded the timeout
+				continue
+			}
+			b := g.bucket(len(c.decoded))
+			if b < 0 || perBucket[b] >= quota {
+				continue
+			}
+			hash := sha256.Sum256([]byte(c.decoded))
+			if _, ok := seen[hash]; ok {
+				continue
+			}
+			seen[hash] = struct{}{}
+			perBucket[b]++
+			if err := emit(accepted, c.decoded, c.encoded); err != nil {
+				return next, err
+			}
+			accepted++
+		}
+	}
+	return next, nil
+}
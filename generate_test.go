@@ -0,0 +1,67 @@
+package atnwalk
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestGenerator replaces decoding with a stand-in whose output size is the size of the data
+func newTestGenerator(workers int) *Generator {
+	return &Generator{
+		Count:        20,
+		Workers:      workers,
+		Seed:         42,
+		MinSize:      2,
+		MaxSize:      21,
+		Buckets:      4,
+		MaxDataBytes: 32,
+		MaxAttempts:  10000,
+		newDecode: func() func(data []byte) (string, []byte) {
+			return func(data []byte) (string, []byte) {
+				return strings.Repeat("a", len(data)), data
+			}
+		},
+	}
+}
+
+func TestGenerator_Run(t *testing.T) {
+	var sizes []int
+	var encoded [][]byte
+	attempts, err := newTestGenerator(1).Run(func(id int, d string, e []byte) error {
+		if id != len(sizes) {
+			t.Errorf("Run() emitted id %d, want %d", id, len(sizes))
+		}
+		sizes = append(sizes, len(d))
+		encoded = append(encoded, e)
+		return nil
+	})
+	if err != nil || attempts == 0 {
+		t.Fatalf("Run() = %d, %v", attempts, err)
+	}
+
+	// the stand-in only yields 20 distinct outputs in the range, 5 per bucket
+	if len(sizes) != 20 {
+		t.Fatalf("Run() emitted %d pairs, want 20", len(sizes))
+	}
+	perBucket := map[int]int{}
+	for _, size := range sizes {
+		if size < 2 || size > 21 {
+			t.Errorf("Run() emitted size %d out of range", size)
+		}
+		perBucket[(size-2)/5]++
+	}
+	if !reflect.DeepEqual(perBucket, map[int]int{0: 5, 1: 5, 2: 5, 3: 5}) {
+		t.Errorf("Run() emitted sizes per bucket %v", perBucket)
+	}
+
+	// the output does not depend on the number of workers
+	var parallel [][]byte
+	newTestGenerator(8).Run(func(id int, d string, e []byte) error {
+		parallel = append(parallel, e)
+		return nil
+	})
+	if !reflect.DeepEqual(encoded, parallel) {
+		t.Errorf("Run() with 8 workers emitted different pairs than with 1 worker")
+	}
+}
@@ -1,27 +1,61 @@
 package atnwalk
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
-	"io/ioutil"
 	"net"
 	"os"
 	"os/exec"
-	"strconv"
 	"syscall"
 	"time"
 )
 
 const (
-	AreYouAlive  byte = 213
-	YesIAmAlive  byte = 42
-	CrossoverBit byte = 0b00000001
-	MutateBit    byte = 0b00000010
-	DecodeBit    byte = 0b00000100
-	EncodeBit    byte = 0b00001000
+	AreYouAlive      byte = 213
+	YesIAmAlive      byte = 42
+	WhatAreYourStats byte = 117
+	CrossoverBit     byte = 0b00000001
+	MutateBit        byte = 0b00000010
+	DecodeBit        byte = 0b00000100
+	EncodeBit        byte = 0b00001000
 )
 
+// the status of a response is sent before the results, results are only sent with StatusOK
+const (
+	StatusOK byte = iota
+	StatusTimeout
+	StatusBadRequest
+	StatusInternalError
+	// not sent by the server, SendRequest reports it if the connection broke after the handshake
+	StatusBrokenConnection
+)
+
+// requests with more data are rejected with StatusBadRequest
+const MaxRequestBytes = 1 << 26
+
+// ResponseGrace is how long a client waits for the response beyond the timeout, the timeout of the server only starts
+// after it received the request and the response still has to be sent when the server times out
+const ResponseGrace = 250 * time.Millisecond
+
+func StatusText(status byte) string {
+	switch status {
+	case StatusOK:
+		return "OK"
+	case StatusTimeout:
+		return "TIMEOUT"
+	case StatusBadRequest:
+		return "BAD_REQUEST"
+	case StatusInternalError:
+		return "INTERNAL_ERROR"
+	case StatusBrokenConnection:
+		return "BROKEN_CONNECTION"
+	}
+	return "UNKNOWN"
+}
+
 func readAll(conn net.Conn, data []byte) bool {
 	offset := 0
 	for offset < len(data) {
@@ -46,14 +80,34 @@ func writeAll(conn net.Conn, data []byte) bool {
 	return true
 }
 
-func HandleRequest(conn net.Conn, timeout int, parser_ antlr.Parser, lexer antlr.Lexer) {
+func HandleRequest(conn net.Conn, timeout int, parser_ antlr.Parser, lexer antlr.Lexer, startRule string,
+	strategy RoutingStrategy, stats *ServerStats) {
 	defer conn.Close()
 	buf := make([]byte, 8)
-	var result []byte
+	crossoverSeed := make([]byte, 8)
+	var decoded, encoded []byte
+	start := time.Now()
 
 	// see whether the client knows the secret handshake
 	// used to quickly check whether the server is down
-	if ok := readAll(conn, buf[:1]); !ok || buf[0] != AreYouAlive {
+	if ok := readAll(conn, buf[:1]); !ok {
+		return
+	}
+
+	// instead of the handshake, the client may ask for the statistics which are sent in the Prometheus text format
+	if buf[0] == WhatAreYourStats {
+		text := &bytes.Buffer{}
+		if stats != nil {
+			stats.WritePrometheus(text)
+		}
+		binary.BigEndian.PutUint32(buf[:4], uint32(text.Len()))
+		if writeAll(conn, buf[:4]) {
+			writeAll(conn, text.Bytes())
+		}
+		return
+	}
+
+	if buf[0] != AreYouAlive {
 		return
 	}
 
@@ -69,6 +123,19 @@ func HandleRequest(conn net.Conn, timeout int, parser_ antlr.Parser, lexer antlr
 	}
 	wanted := buf[0]
 	nBytes := int(binary.BigEndian.Uint32(buf[1:]))
+	var walker *ATNWalker
+	status := StatusBrokenConnection
+	if stats != nil {
+		defer func() {
+			recordRequest(stats, wanted, status, start, walker, len(decoded), len(encoded))
+		}()
+	}
+
+	if !isValidRequest(wanted) || nBytes > MaxRequestBytes {
+		status = StatusBadRequest
+		writeAll(conn, []byte{status})
+		return
+	}
 	var data1, data2 []byte = make([]byte, nBytes), nil
 
 	// receive the encoded data
@@ -82,6 +149,11 @@ func HandleRequest(conn net.Conn, timeout int, parser_ antlr.Parser, lexer antlr
 			return
 		}
 		nBytes = int(binary.BigEndian.Uint32(buf[:4]))
+		if nBytes > MaxRequestBytes {
+			status = StatusBadRequest
+			writeAll(conn, []byte{status})
+			return
+		}
 		data2 = make([]byte, nBytes)
 
 		// obtain the data to crossover with
@@ -90,10 +162,9 @@ func HandleRequest(conn net.Conn, timeout int, parser_ antlr.Parser, lexer antlr
 		}
 
 		// obtain the seed for crossover
-		if !readAll(conn, buf[:8]) {
+		if !readAll(conn, crossoverSeed) {
 			return
 		}
-		result = Crossover(data1, data2, int64(binary.BigEndian.Uint64(buf[:8])))
 	}
 
 	if wanted&MutateBit > 0 {
@@ -101,153 +172,136 @@ func HandleRequest(conn net.Conn, timeout int, parser_ antlr.Parser, lexer antlr
 		if !readAll(conn, buf[:8]) {
 			return
 		}
+	}
 
-		// if we performed a crossover then mutate that resulting data otherwise the provided data1
-		if wanted&CrossoverBit > 0 {
-			result = Mutate(result, int64(binary.BigEndian.Uint64(buf[:8])))
-		} else {
-			result = Mutate(data1, int64(binary.BigEndian.Uint64(buf[:8])))
-		}
+	var requestStatus byte
+	requestStatus, decoded, encoded, walker = processRequest(wanted, data1, data2,
+		binary.BigEndian.Uint64(crossoverSeed), binary.BigEndian.Uint64(buf[:8]), timeout, parser_, lexer, startRule,
+		strategy)
+	if !writeAll(conn, []byte{requestStatus}) {
+		return
+	}
+	if status = requestStatus; status != StatusOK {
+		return
 	}
 
-	var walker *ATNWalker
 	if wanted&DecodeBit > 0 {
-		walker = NewATNWalker(parser_, lexer)
-		var writeBack *[]byte
-		if wanted&EncodeBit > 0 {
-			writeBack = &([]byte{})
-		}
-		walker.SetDeadline(time.Now().Add(time.Duration(timeout) * time.Millisecond))
-		if wanted&CrossoverBit > 0 || wanted&MutateBit > 0 {
-			result = []byte(walker.Decode(result, writeBack))
-		} else {
-			result = []byte(walker.Decode(data1, writeBack))
-		}
-
-		// send how many bytes decoded data will be sent
-		binary.BigEndian.PutUint32(buf[:4], uint32(len(result)))
-		if !writeAll(conn, buf[:4]) {
+		// send how many bytes decoded data will be sent and the decoded data itself
+		binary.BigEndian.PutUint32(buf[:4], uint32(len(decoded)))
+		if !writeAll(conn, buf[:4]) || !writeAll(conn, decoded) {
+			status = StatusBrokenConnection
 			return
 		}
+	}
 
-		// send the decoded data
-		if !writeAll(conn, result) {
-			return
-		}
+	// send how many bytes of encoded (mutated/crossover/repaired) data will be sent and the encoded data itself,
+	// when decoding, the encoded data is always sent but may be empty
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(encoded)))
+	if !writeAll(conn, buf[:4]) || !writeAll(conn, encoded) {
+		status = StatusBrokenConnection
+	}
+}
 
-		nBytes = 0
-		if writeBack != nil {
-			nBytes = len(*writeBack)
-		}
+// isValidRequest reports whether the client wants at least one operation and only operations that are known.
+func isValidRequest(wanted byte) bool {
+	return wanted != 0 && wanted&^(CrossoverBit|MutateBit|DecodeBit|EncodeBit) == 0
+}
 
-		// send how many bytes encoded data will be sent
-		binary.BigEndian.PutUint32(buf[:4], uint32(nBytes))
-		if !writeAll(conn, buf[:4]) {
-			return
+// processRequest performs the operations of a request, independent of the transport the request was received with.
+// A panic while doing so is reported as StatusInternalError, exceeding the timeout as StatusTimeout, and data that
+// records another start rule than the server's (see WithStartRule) as StatusBadRequest.
+// The walker is nil unless the request wanted to decode or encode.
+func processRequest(wanted byte, data1, data2 []byte, seedCrossover, seedMutation uint64, timeout int,
+	parser_ antlr.Parser, lexer antlr.Lexer, startRule string, strategy RoutingStrategy) (status byte, decoded,
+	encoded []byte, walker *ATNWalker) {
+	defer func() {
+		if r := recover(); r != nil {
+			status, decoded, encoded = StatusInternalError, nil, nil
 		}
+	}()
 
-		// send the encoded data
-		if nBytes > 0 {
-			if !writeAll(conn, *writeBack) {
-				return
-			}
-		}
+	// the record of the start rule is not part of the encoded data, it is checked once the start rule is known
+	recorded1, data1 := SplitStartRule(data1)
+	recorded2, data2 := SplitStartRule(data2)
 
-		// early return to not encode again if the bit is set
-		return
+	var result []byte
+	if wanted&CrossoverBit > 0 {
+		result = Crossover(data1, data2, int64(seedCrossover))
 	}
 
-	if wanted&EncodeBit > 0 {
-		// the client only wants the encoded data, i.e., repair the data
-		walker = NewATNWalker(parser_, lexer)
-		walker.SetDeadline(time.Now().Add(time.Duration(timeout) * time.Millisecond))
-		if wanted&CrossoverBit > 0 || wanted&MutateBit > 0 {
-			result = walker.Repair(result)
+	// if we performed a crossover then mutate that resulting data otherwise the provided data1
+	if wanted&MutateBit > 0 {
+		if wanted&CrossoverBit > 0 {
+			result = Mutate(result, int64(seedMutation))
 		} else {
-			result = walker.Repair(data1)
+			result = Mutate(data1, int64(seedMutation))
 		}
+	}
+	if wanted&(CrossoverBit|MutateBit) == 0 {
+		result = data1
+	}
 
-		// send how many bytes encoded (repaired) data will be sent
-		binary.BigEndian.PutUint32(buf[:4], uint32(len(result)))
-		if !writeAll(conn, buf[:4]) {
-			return
-		}
+	if wanted&(DecodeBit|EncodeBit) == 0 {
+		return StatusOK, nil, result, nil
+	}
 
-		// send the encoded (repaired) data
-		if !writeAll(conn, result) {
-			return
+	walker = NewATNWalker(parser_, lexer)
+	walker.SetRoutingStrategy(strategy)
+	if err := walker.SetStartRule(startRule); err != nil {
+		return StatusInternalError, nil, nil, walker
+	}
+	for _, recorded := range []string{recorded1, recorded2} {
+		if checkStartRule(recorded, parser_.GetRuleNames()[walker.startRuleIndex]) != nil {
+			return StatusBadRequest, nil, nil, walker
 		}
-
-		// early return to avoid sending mutation or crossover results (i.e. non-repaired bytes)
-		return
 	}
-
-	if wanted&CrossoverBit > 0 || wanted&MutateBit > 0 {
-		// send how many bytes of mutated/crossover data will be sent
-		binary.BigEndian.PutUint32(buf[:4], uint32(len(result)))
-		if !writeAll(conn, buf[:4]) {
-			return
+	walker.SetDeadline(time.Now().Add(time.Duration(timeout) * time.Millisecond))
+	if wanted&DecodeBit > 0 {
+		var writeBack *[]byte
+		if wanted&EncodeBit > 0 {
+			writeBack = &([]byte{})
 		}
-
-		// send the mutated/crossover data
-		if !writeAll(conn, result) {
-			return
+		decoded = []byte(walker.Decode(result, writeBack))
+		if writeBack != nil {
+			encoded = *writeBack
 		}
+	} else {
+		// the client only wants the encoded data, i.e., repair the data
+		// (this also avoids sending mutation or crossover results, i.e., non-repaired bytes)
+		encoded = walker.Repair(result)
 	}
+	if walker.TimedOut() {
+		return StatusTimeout, nil, nil, walker
+	}
+	return StatusOK, decoded, encoded, walker
 }
 
-func InitServerProcess(pidFile, socketFile string) {
-	// isolate the process
-	syscall.Setsid()
-
-	// if the file exists the other server process may hang
-	if _, err := os.Stat(pidFile); err == nil {
-
-		// check whether it is healthy first
-		buf := make([]byte, 1)
-		conn, err := net.Dial("unix", socketFile)
-		if err == nil {
-			conn.SetDeadline(time.Now().Add(10 * time.Millisecond))
-			if writeAll(conn, []byte{AreYouAlive}) {
-				if readAll(conn, buf) && buf[0] == YesIAmAlive {
-					conn.Close()
-					os.Exit(0)
-				}
-			}
-		}
-
-		// apparently, the other server process is not healthy, kill it
-		data, err := ioutil.ReadFile(pidFile)
-		if err != nil {
-			panic(err)
-		}
-		pid, err := strconv.Atoi(string(data))
-		if err != nil {
-			panic(err)
-		}
-
-		// TODO: We should at least check whether the process name is correct and if it exists before
-		// killing the PID. However, paper deadline approaches and the risk of killing another process
-		// is already low, because PIDs increment and killing a critical process is not possible, since
-		// we do not execute as root. Thus, only user processes are in danger if the machine spawns a
-		// considerably high amount of processes (PID counter reset), e.g., through a fuzzing job or a reboot.
-		// C'est la vie.
-		syscall.Kill(pid, syscall.SIGKILL)
-	} else if !errors.Is(err, os.ErrNotExist) {
-		panic(err)
+// recordRequest adds the statistics of a request to the server statistics.
+func recordRequest(stats *ServerStats, wanted, status byte, start time.Time, walker *ATNWalker,
+	decodedBytes, encodedBytes int) {
+	// the status tells whether the walker timed out, asking the walker now also counts the time to write the response
+	requestStats := &RequestStats{Wanted: wanted, Status: status, Latency: time.Since(start),
+		TimedOut: status == StatusTimeout}
+	if status == StatusOK {
+		requestStats.DecodedBytes = decodedBytes
+		requestStats.EncodedBytes = encodedBytes
 	}
-
-	// create the pid file
-	os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0644)
+	if walker != nil {
+		requestStats.Decisions, requestStats.RoutedDecisions = walker.DecisionStats()
+		requestStats.Routers, requestStats.RouteOptions = walker.RouterStats()
+	}
+	stats.Record(requestStats)
 }
 
-func SendRequest(socketFile string, timeout int, data1, data2 []byte, wanted byte, seedCrossover, seedMutation uint64,
-	encoded, decoded *[]byte) bool {
+// SendRequest returns false if the handshake with the server failed, i.e., the request can be retried, otherwise it
+// returns the status of the response. The encoded and decoded data are only set with StatusOK.
+func SendRequest(address string, timeout int, data1, data2 []byte, wanted byte, seedCrossover, seedMutation uint64,
+	encoded, decoded *[]byte) (byte, bool) {
 	buf := make([]byte, 8)
 
-	conn, err := net.Dial("unix", socketFile)
+	conn, err := dial(address)
 	if err != nil {
-		return false
+		return StatusBrokenConnection, false
 	}
 	defer conn.Close()
 
@@ -256,100 +310,156 @@ func SendRequest(socketFile string, timeout int, data1, data2 []byte, wanted byt
 
 	// ask whether the server is alive
 	if !writeAll(conn, []byte{AreYouAlive}) {
-		return false
+		return StatusBrokenConnection, false
 	}
 
 	// see whether the server replies as expected
 	if ok := readAll(conn, buf[:1]); !ok || buf[0] != YesIAmAlive {
-		return false
+		return StatusBrokenConnection, false
+	}
+
+	// since the handshake was successful, failures from now on are likely timeouts or rejected requests
+	// do not try again from now on and discard this attempt
+	// (the server closes the connection after sending the status of a rejected request, hence, try to read it)
+	status := func() byte {
+		if readAll(conn, buf[:1]) {
+			return buf[0]
+		}
+		return StatusBrokenConnection
 	}
 
 	// tell the server what we want and how large data1 is
 	buf[0] = wanted
 	binary.BigEndian.PutUint32(buf[1:5], uint32(len(data1)))
 	if !writeAll(conn, buf[:5]) {
-		return false
+		return status(), true
 	}
 
 	// send the encoded data
 	if !writeAll(conn, data1) {
-		// since the handshake was successful, we likely reached a timeout
-		// do not try again from now on and discard this attempt
-		return true
+		return status(), true
 	}
 
 	if wanted&CrossoverBit > 0 {
 		binary.BigEndian.PutUint32(buf[:4], uint32(len(data2)))
-		if !writeAll(conn, buf[:4]) {
-			return false
-		}
-		if !writeAll(conn, data2) {
-			return true
+		if !writeAll(conn, buf[:4]) || !writeAll(conn, data2) {
+			return status(), true
 		}
 		binary.BigEndian.PutUint64(buf[:8], seedCrossover)
 		if !writeAll(conn, buf[:8]) {
-			return true
+			return status(), true
 		}
 	}
 
 	if wanted&MutateBit > 0 {
 		binary.BigEndian.PutUint64(buf[:8], seedMutation)
 		if !writeAll(conn, buf[:8]) {
-			return true
+			return status(), true
 		}
 	}
 
+	// the server's timeout starts now, give it the time to send the status of a request that timed out
+	conn.SetDeadline(time.Now().Add(time.Duration(timeout)*time.Millisecond + ResponseGrace))
+
+	// the results are only sent if the server succeeded
+	if s := status(); s != StatusOK {
+		return s, true
+	}
+
 	if wanted&DecodeBit > 0 {
 		if !readAll(conn, buf[:4]) {
-			return true
+			return StatusBrokenConnection, true
 		}
 		nBytes := int(binary.BigEndian.Uint32(buf[:4]))
 		*decoded = make([]byte, nBytes)
 		// obtain the decoded data
 		if !readAll(conn, *decoded) {
-			return true
+			return StatusBrokenConnection, true
 		}
 	}
 
 	if wanted&CrossoverBit > 0 || wanted&MutateBit > 0 || wanted&EncodeBit > 0 {
 		// obtain the length of the mutated/crossover or encoded data
 		if !readAll(conn, buf[:4]) {
-			return true
+			return StatusBrokenConnection, true
 		}
 		nBytes := int(binary.BigEndian.Uint32(buf[:4]))
 		*encoded = make([]byte, nBytes)
 
 		// receive the encoded data
 		if !readAll(conn, *encoded) {
-			return true
+			return StatusBrokenConnection, true
 		}
 	}
-	return true
+	return StatusOK, true
 }
 
-func RestartServer(lockFile, serverBin string) {
-	var file *os.File
-	if _, err := os.Stat(lockFile); errors.Is(err, os.ErrNotExist) {
-		// apparently, the lock file does not exist, create one and lock it if possible
-		file, err = os.Create(lockFile)
-		if err != nil {
-			return
-		}
-		defer file.Close()
+func SendStatsRequest(address string, timeout int) ([]byte, bool) {
+	buf := make([]byte, 4)
+
+	conn, err := dial(address)
+	if err != nil {
+		return nil, false
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Duration(timeout) * time.Millisecond))
+
+	if !writeAll(conn, []byte{WhatAreYourStats}) || !readAll(conn, buf) {
+		return nil, false
+	}
+	text := make([]byte, binary.BigEndian.Uint32(buf))
+	if !readAll(conn, text) {
+		return nil, false
+	}
+	return text, true
+}
+
+// StartServer starts the server binary with the arguments unless a server process holds the PID file or another
+// process holds the lock file, i.e., only one of many concurrent clients starts the server and a running server is
+// never replaced, even if it is too busy to reply in time. The lock file is held until the started server locked the
+// PID file (or the wait timed out) such that no other client starts a second server in the meantime.
+func StartServer(pidFile, lockFile, serverBin string, args ...string) error {
+	if isLocked(pidFile) {
+		return nil
+	}
+	file, err := os.OpenFile(lockFile, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		// another client is starting the server right now
+		return nil
 	} else if err != nil {
-		return
-	} else {
-		file, err = os.OpenFile(lockFile, os.O_RDWR, 0644)
-		if err != nil {
-			return
-		}
-		defer file.Close()
+		return err
 	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
 
-	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
-	if err == nil {
-		defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
-		// got the lock! now start the server
-		exec.Command(serverBin).Start()
+	// got the lock! the server may have been started while waiting for it
+	if isLocked(pidFile) {
+		return nil
+	}
+	cmd := exec.Command(serverBin, args...)
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		select {
+		case err = <-exited:
+			// a server exits without an error if another server is healthy
+			if err != nil {
+				return fmt.Errorf("the server exited during its start: %w", err)
+			}
+			return nil
+		default:
+		}
+		if isLocked(pidFile) {
+			return nil
+		}
 	}
+	return nil
 }
@@ -0,0 +1,116 @@
+package atnwalk
+
+import (
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+// slowStrategy takes longer than the timeout of the server to route a decision
+type slowStrategy struct {
+	delay time.Duration
+}
+
+func (s slowStrategy) Route(router *Router, state int, rootPathRules map[int]struct{}) int {
+	time.Sleep(s.delay)
+	return 0
+}
+
+// exchange sends a request to HandleRequest over a pipe and returns the status of the response
+func exchange(t *testing.T, timeout int, parser_ antlr.Parser, lexer antlr.Lexer, strategy RoutingStrategy,
+	wanted byte, nBytes int, data []byte) byte {
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleRequest(server, timeout, parser_, lexer, "", strategy, nil)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	buf := make([]byte, 5)
+	if !writeAll(client, []byte{AreYouAlive}) || !readAll(client, buf[:1]) || buf[0] != YesIAmAlive {
+		t.Fatalf("the handshake failed")
+	}
+	buf[0] = wanted
+	binary.BigEndian.PutUint32(buf[1:], uint32(nBytes))
+	if !writeAll(client, buf) {
+		t.Fatalf("could not send the request")
+	}
+	// the server may reject the request without reading the data, i.e., the data is sent while reading the status
+	go writeAll(client, data)
+	if !readAll(client, buf[:1]) {
+		t.Fatalf("could not receive the status")
+	}
+	return buf[0]
+}
+
+func TestHandleRequest(t *testing.T) {
+	parser, lexer := newChoiceGrammar()
+	tests := []struct {
+		name     string
+		timeout  int
+		parser   antlr.Parser
+		strategy RoutingStrategy
+		wanted   byte
+		data     []byte
+		nBytes   int
+		want     byte
+	}{
+		{"ok", 100, parser, nil, DecodeBit, []byte{1}, 0, StatusOK},
+		{"unknown bits", 100, parser, nil, 0b10000000, []byte{1}, 0, StatusBadRequest},
+		{"nothing wanted", 100, parser, nil, 0, []byte{1}, 0, StatusBadRequest},
+		{"oversize", 100, parser, nil, DecodeBit, nil, MaxRequestBytes + 1, StatusBadRequest},
+		// decoding without a parser panics
+		{"panic", 100, nil, nil, DecodeBit, []byte{1}, 0, StatusInternalError},
+		{"timeout", 10, parser, slowStrategy{50 * time.Millisecond}, DecodeBit, []byte{1}, 0, StatusTimeout},
+		{"recorded start rule", 100, parser, nil, DecodeBit, WithStartRule([]byte{1}, "r"), 0, StatusOK},
+		{"other start rule", 100, parser, nil, DecodeBit, WithStartRule([]byte{1}, "s"), 0, StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the size of the data unless the request announces another size
+			nBytes := tt.nBytes
+			if nBytes == 0 {
+				nBytes = len(tt.data)
+			}
+			if status := exchange(t, tt.timeout, tt.parser, lexer, tt.strategy, tt.wanted, nBytes,
+				tt.data); status != tt.want {
+				t.Errorf("HandleRequest() responded %s, want %s", StatusText(status), StatusText(tt.want))
+			}
+		})
+	}
+}
+
+func TestSendRequest_Timeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "atnwalk.socket")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// the server decodes past its deadline, the client still receives the status
+	const timeout = 50
+	parser, lexer := newChoiceGrammar()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go HandleRequest(conn, timeout, parser, lexer, "", slowStrategy{3 * timeout * time.Millisecond}, nil)
+		}
+	}()
+
+	encoded, decoded := &([]byte{}), &([]byte{})
+	status, ok := SendRequest("unix:"+path, timeout, []byte{1}, nil, DecodeBit, 0, 0, encoded, decoded)
+	if !ok || status != StatusTimeout {
+		t.Errorf("SendRequest() = %s, %v, want TIMEOUT", StatusText(status), ok)
+	}
+
+	status, ok = SendRequest("unix:"+path, timeout, []byte{1}, nil, MutateBit, 0, 1, encoded, decoded)
+	if !ok || status != StatusOK || len(*encoded) == 0 {
+		t.Errorf("SendRequest() = %s, %v, %v, want the mutated data", StatusText(status), ok, *encoded)
+	}
+}
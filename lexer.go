@@ -0,0 +1,389 @@
+package atnwalk
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+// lexerRule describes what the lexer does once the rule matched, i.e., the lexer commands of all its alternatives.
+type lexerRule struct {
+	// the token type that the rule emits, 0 for fragment rules
+	tokenType int
+	channel   int
+	skip      bool
+	more      bool
+	// the mode, pushMode, and popMode commands in the order they are executed
+	modeActions []antlr.LexerAction
+}
+
+// lexerModel describes how the lexer produces the tokens of the parser: a token is the text of one rule that emits the
+// token type, preceded by rules with the more command, and the mode of the lexer decides which rules are tried.
+type lexerModel struct {
+	rules []lexerRule
+	// the rule indices of each mode in the order of the transitions of the mode's start state
+	modes [][]int
+	// the rule indices that produce each token type on the default channel, the first one is the canonical rule
+	tokenRules map[int][]int
+
+	mutex    sync.Mutex
+	produces map[producesKey]bool
+	// the token types of parser wildcards, see ATNWalker.wildcardTokens
+	wildcardTokens *antlr.IntervalSet
+}
+
+type producesKey struct {
+	tokenType int
+	modes     string
+}
+
+// lexerModes is the mode and the mode stack of the lexer, the zero value is the default mode with an empty stack.
+type lexerModes struct {
+	mode  int
+	stack []int
+}
+
+// maxModeStack limits the mode stack that is explored when searching rules that lead to a token type.
+const maxModeStack = 16
+
+// lexerModels shares the lexer models of the ATNs between walkers.
+var lexerModels = newATNCache(newLexerModel)
+
+func newLexerModel(atn *antlr.ATN) *lexerModel {
+	model := &lexerModel{
+		rules:      make([]lexerRule, len(atn.GetRuleIndexToStartStateSlice())),
+		tokenRules: map[int][]int{},
+		produces:   map[producesKey]bool{}}
+	for ruleIndex := range model.rules {
+		if ruleIndex < len(atn.GetRuleToTokenType()) {
+			model.rules[ruleIndex].tokenType = atn.GetRuleToTokenType()[ruleIndex]
+		}
+	}
+
+	// the commands of a rule are action transitions at the end of its alternatives
+	for _, state := range atn.GetStates() {
+		if state == nil {
+			continue
+		}
+		for _, transition := range state.GetTransitions() {
+			t, ok := transition.(*antlr.ActionTransition)
+			if !ok || state.GetRuleIndex() < 0 || t.GetActionIndex() < 0 ||
+				t.GetActionIndex() >= len(atn.GetLexerActions()) {
+				continue
+			}
+			rule := &model.rules[state.GetRuleIndex()]
+			switch action := atn.GetLexerActions()[t.GetActionIndex()].(type) {
+			case *antlr.LexerTypeAction:
+				rule.tokenType = action.GetType()
+			case *antlr.LexerChannelAction:
+				rule.channel = action.GetChannel()
+			case *antlr.LexerSkipAction:
+				rule.skip = true
+			case *antlr.LexerMoreAction:
+				rule.more = true
+			case *antlr.LexerModeAction, *antlr.LexerPushModeAction, *antlr.LexerPopModeAction:
+				rule.modeActions = append(rule.modeActions, action)
+			}
+		}
+	}
+
+	for _, startState := range atn.GetModeToStartState() {
+		var ruleIndices []int
+		for _, transition := range startState.GetTransitions() {
+			if target, ok := transition.(antlr.AnyTransition).GetTarget().(*antlr.RuleStartState); ok {
+				ruleIndices = append(ruleIndices, target.GetRuleIndex())
+			}
+		}
+		model.modes = append(model.modes, ruleIndices)
+	}
+	// without modes, every rule that emits a token is tried
+	if len(model.modes) == 0 {
+		var ruleIndices []int
+		for ruleIndex, rule := range model.rules {
+			if rule.tokenType > 0 {
+				ruleIndices = append(ruleIndices, ruleIndex)
+			}
+		}
+		model.modes = [][]int{ruleIndices}
+	}
+
+	// the rule named after the token type comes first, it is the canonical rule of the token type
+	for ruleIndex, rule := range model.rules {
+		if rule.tokenType <= 0 || rule.skip || rule.more || rule.channel != antlr.TokenDefaultChannel {
+			continue
+		}
+		if ruleIndex < len(atn.GetRuleToTokenType()) && atn.GetRuleToTokenType()[ruleIndex] == rule.tokenType {
+			model.tokenRules[rule.tokenType] = append([]int{ruleIndex}, model.tokenRules[rule.tokenType]...)
+		} else {
+			model.tokenRules[rule.tokenType] = append(model.tokenRules[rule.tokenType], ruleIndex)
+		}
+	}
+	return model
+}
+
+// canonicalRule returns the lexer rule that produces the token type regardless of the mode or -1 if there is none.
+func (l *lexerModel) canonicalRule(tokenType int) int {
+	if ruleIndices, ok := l.tokenRules[tokenType]; ok {
+		return ruleIndices[0]
+	}
+	return -1
+}
+
+// producesToken reports whether the rule emits the token type on the default channel.
+func (l *lexerModel) producesToken(ruleIndex, tokenType int) bool {
+	rule := l.rules[ruleIndex]
+	return rule.tokenType == tokenType && !rule.skip && !rule.more && rule.channel == antlr.TokenDefaultChannel
+}
+
+// tokenOptions returns the rules of the current mode that either produce the token type or have the more command and
+// lead to a mode that can produce the token type. The index of a rule in the options is what encode and decode
+// record when a token needs more than one rule or when multiple rules produce the token type in the mode.
+func (l *lexerModel) tokenOptions(tokenType int, modes lexerModes) []int {
+	if modes.mode >= len(l.modes) {
+		return nil
+	}
+	var options []int
+	for _, ruleIndex := range l.modes[modes.mode] {
+		if l.producesToken(ruleIndex, tokenType) ||
+			(l.rules[ruleIndex].more && !l.rules[ruleIndex].skip && l.canProduce(tokenType, modes.apply(l.rules[ruleIndex]))) {
+			options = append(options, ruleIndex)
+		}
+	}
+	return options
+}
+
+// canProduce reports whether a rule of the mode produces the token type, possibly after rules with the more command.
+func (l *lexerModel) canProduce(tokenType int, modes lexerModes) bool {
+	key := producesKey{tokenType, modes.key()}
+	l.mutex.Lock()
+	if result, ok := l.produces[key]; ok {
+		l.mutex.Unlock()
+		return result
+	}
+	l.mutex.Unlock()
+
+	// breadth-first search over the modes that rules with the more command switch to
+	result := false
+	visited := map[string]struct{}{key.modes: {}}
+	queue := &Queue[lexerModes]{}
+	queue.Enqueue(modes)
+	for !queue.IsEmpty() && !result {
+		current := queue.Dequeue()
+		if current.mode >= len(l.modes) {
+			continue
+		}
+		for _, ruleIndex := range l.modes[current.mode] {
+			if l.producesToken(ruleIndex, tokenType) {
+				result = true
+				break
+			}
+			if !l.rules[ruleIndex].more || l.rules[ruleIndex].skip {
+				continue
+			}
+			next := current.apply(l.rules[ruleIndex])
+			if _, ok := visited[next.key()]; !ok && len(next.stack) <= maxModeStack {
+				visited[next.key()] = struct{}{}
+				queue.Enqueue(next)
+			}
+		}
+	}
+
+	l.mutex.Lock()
+	l.produces[key] = result
+	l.mutex.Unlock()
+	return result
+}
+
+// apply returns the modes after the mode commands of the rule, the modes themselves are not changed.
+func (m lexerModes) apply(rule lexerRule) lexerModes {
+	if len(rule.modeActions) == 0 {
+		return m
+	}
+	next := lexerModes{mode: m.mode, stack: append([]int{}, m.stack...)}
+	for _, action := range rule.modeActions {
+		switch a := action.(type) {
+		case *antlr.LexerModeAction:
+			next.mode = a.GetMode()
+		case *antlr.LexerPushModeAction:
+			next.stack = append(next.stack, next.mode)
+			next.mode = a.GetMode()
+		case *antlr.LexerPopModeAction:
+			// popping an empty stack fails in ANTLR, the default mode is the best guess to continue with
+			if len(next.stack) == 0 {
+				next.mode = 0
+				continue
+			}
+			next.mode = next.stack[len(next.stack)-1]
+			next.stack = next.stack[:len(next.stack)-1]
+		}
+	}
+	return next
+}
+
+func (m lexerModes) key() string {
+	return fmt.Sprint(m.mode, m.stack)
+}
+
+// tokenStep is one rule that the lexer matched for a token: the rule's index in the token options and its trace.
+type tokenStep struct {
+	choice     int
+	numOptions int
+	ruleIndex  int
+	trace      *LexerTrace
+}
+
+// matchToken splits the text of a token into the rules that the lexer matched, i.e., rules with the more command
+// followed by a rule that produces the token type, starting with the modes. Rules with the more command match as many
+// runes as possible like the lexer does. It returns nil if the text cannot be split.
+func (l *lexerModel) matchToken(atn *antlr.ATN, text []rune, tokenType int,
+	modes lexerModes) ([]tokenStep, lexerModes) {
+	m := newLexerMatcher(text)
+	failed := map[string]struct{}{}
+	var steps []tokenStep
+	var search func(cursor int, modes lexerModes) (lexerModes, bool)
+	search = func(cursor int, modes lexerModes) (lexerModes, bool) {
+		key := fmt.Sprint(cursor, modes.key())
+		if _, ok := failed[key]; ok {
+			return modes, false
+		}
+		options := l.tokenOptions(tokenType, modes)
+		for choice, ruleIndex := range options {
+			startState := atn.GetRuleIndexToStartStateSlice()[ruleIndex]
+			ends, _ := m.reach(startState, cursor, 0)
+			next := modes.apply(l.rules[ruleIndex])
+			if !l.rules[ruleIndex].more {
+				if len(ends) > 0 && ends[len(ends)-1] == len(text) {
+					steps = append(steps, tokenStep{choice, len(options), ruleIndex, m.trace(startState, cursor, len(text))})
+					return next, true
+				}
+				continue
+			}
+			for i := len(ends) - 1; i >= 0 && ends[i] > cursor; i-- {
+				steps = append(steps, tokenStep{choice, len(options), ruleIndex, m.trace(startState, cursor, ends[i])})
+				if result, ok := search(ends[i], next); ok {
+					return result, true
+				}
+				steps = steps[:len(steps)-1]
+			}
+		}
+		failed[key] = struct{}{}
+		return modes, false
+	}
+	if result, ok := search(0, modes); ok {
+		return steps, result
+	}
+	return nil, modes
+}
+
+// lexerModel returns the lexer model of the walker's lexer.
+func (w *ATNWalker) lexerModel() *lexerModel {
+	if w.tokens == nil {
+		w.tokens = lexerModels.get(w.Lexer.GetATN())
+	}
+	return w.tokens
+}
+
+// tokenStartState returns the start state of the canonical lexer rule of the token type or nil if no lexer rule
+// produces it, the rule that is actually used depends on the lexer's mode (see decodeToken).
+func (w *ATNWalker) tokenStartState(tokenType int) *antlr.RuleStartState {
+	ruleIndex := w.lexerModel().canonicalRule(tokenType)
+	if ruleIndex < 0 {
+		return nil
+	}
+	return w.Lexer.GetATN().GetRuleIndexToStartStateSlice()[ruleIndex]
+}
+
+// decodeToken resolves the lexer rules of a token node in the current mode of the lexer. If a single rule produces the
+// token, the node continues with that rule and false is returned. Otherwise, the choices between the options are
+// decoded with the data of the canonical rule, the rules become the children of the node, and true is returned.
+func (w *ATNWalker) decodeToken(decoder *Decoder, node *SymbolNode) bool {
+	model := w.lexerModel()
+	options := model.tokenOptions(node.TokenType, w.modes)
+	if len(options) == 0 {
+		// the token cannot be produced in the current mode, use the canonical rule anyway
+		w.modes = w.modes.apply(model.rules[node.StartState.GetRuleIndex()])
+		return false
+	}
+	if len(options) == 1 && !model.rules[options[0]].more {
+		node.StartState = w.Lexer.GetATN().GetRuleIndexToStartStateSlice()[options[0]]
+		w.modes = w.modes.apply(model.rules[options[0]])
+		return false
+	}
+
+	initialized := false
+	for steps := 0; ; steps++ {
+		options = model.tokenOptions(node.TokenType, w.modes)
+		if len(options) == 0 {
+			return true
+		}
+		if len(options) > 1 && !initialized {
+			decoder.Init(node.StartState.GetRuleIndex(), true)
+			initialized = true
+		}
+		// the PRNG does not necessarily pick a rule that ends the token, prefer them after many steps
+		if initialized && decoder.usePRNG && steps >= maxTokenSteps {
+			var endingOptions []int
+			for _, ruleIndex := range options {
+				if !model.rules[ruleIndex].more {
+					endingOptions = append(endingOptions, ruleIndex)
+				}
+			}
+			if len(endingOptions) > 0 {
+				options = endingOptions
+			}
+		}
+		ruleIndex := options[decoder.Decode(len(options))]
+		startState := w.Lexer.GetATN().GetRuleIndexToStartStateSlice()[ruleIndex]
+		node.Children = append(node.Children, NewSymbolNode(node, startState))
+		w.modes = w.modes.apply(model.rules[ruleIndex])
+		if !model.rules[ruleIndex].more {
+			return true
+		}
+	}
+}
+
+// maxTokenSteps is the number of rules with the more command after which the PRNG prefers rules that end the token.
+const maxTokenSteps = 32
+
+// encodeTokenSteps writes the choices between the token options like decodeToken reads them and returns the traces of
+// the rules that match the text of the token, or nil if no rule matches.
+func (w *ATNWalker) encodeTokenSteps(encoder *Encoder, tokenType int, text []rune) []*LexerTrace {
+	model := w.lexerModel()
+	atn := w.Lexer.GetATN()
+	canonical := model.canonicalRule(tokenType)
+	if canonical < 0 {
+		return nil
+	}
+	options := model.tokenOptions(tokenType, w.modes)
+	if len(options) == 0 || (len(options) == 1 && !model.rules[options[0]].more) {
+		ruleIndex := canonical
+		if len(options) == 1 {
+			ruleIndex = options[0]
+		}
+		w.modes = w.modes.apply(model.rules[ruleIndex])
+		if trace := match(text, atn.GetRuleIndexToStartStateSlice()[ruleIndex]); trace != nil {
+			return []*LexerTrace{trace}
+		}
+		return nil
+	}
+
+	steps, modes := model.matchToken(atn, text, tokenType, w.modes)
+	if steps == nil {
+		return nil
+	}
+	w.modes = modes
+	headerSet := false
+	traces := make([]*LexerTrace, len(steps))
+	for i, step := range steps {
+		if step.numOptions > 1 {
+			if !headerSet {
+				encoder.WriteRuleHeader(canonical, len(atn.GetRuleIndexToStartStateSlice()), true)
+				headerSet = true
+			}
+			encoder.Encode(step.choice, step.numOptions)
+		}
+		traces[i] = step.trace
+	}
+	return traces
+}
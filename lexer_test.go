@@ -0,0 +1,152 @@
+package atnwalk
+
+import (
+	"math/rand"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+// newModeGrammar returns the parser and the lexer of the grammar:
+//
+//	doc : item | item doc ;
+//	item : WORD | STRING | LT WORD GT ;
+//	WS : ' ' -> skip ;
+//	QUOTE : '"' -> more, pushMode(STR) ;
+//	LT : '<' -> pushMode(TAG) ;
+//	WORD : [a-c] ;
+//	mode STR;
+//	STRING : '"' -> popMode ;
+//	CHAR : [a-c] -> more ;
+//	mode TAG;
+//	GT : '>' -> popMode ;
+//	TAG_WORD : [a-c] -> type(WORD) ;
+//	COMMENT : '#' -> channel(HIDDEN) ;
+func newModeGrammar() (*testParser, *testLexer) {
+	const WS, QUOTE, LT, WORD, STRING, CHAR, GT, TAG_WORD, COMMENT = 1, 2, 3, 4, 5, 6, 7, 8, 9
+	const STR, TAG = 1, 2
+
+	b := &atnBuilder{grammarType: antlr.ATNTypeLexer, maxTokenType: COMMENT}
+	var starts, stops [9]int
+	for i := range starts {
+		starts[i], stops[i] = b.rule(i + 1)
+	}
+	b.alt(0, starts[0], stops[0], atom(' '), b.command(0, antlr.LexerActionTypeSkip, 0))
+	b.alt(1, starts[1], stops[1], atom('"'), b.command(1, antlr.LexerActionTypeMore, 0),
+		b.command(1, antlr.LexerActionTypePushMode, STR))
+	b.alt(2, starts[2], stops[2], atom('<'), b.command(2, antlr.LexerActionTypePushMode, TAG))
+	b.alt(3, starts[3], stops[3], charRange('a', 'c'))
+	b.alt(4, starts[4], stops[4], atom('"'), b.command(4, antlr.LexerActionTypePopMode, 0))
+	b.alt(5, starts[5], stops[5], charRange('a', 'c'), b.command(5, antlr.LexerActionTypeMore, 0))
+	b.alt(6, starts[6], stops[6], atom('>'), b.command(6, antlr.LexerActionTypePopMode, 0))
+	b.alt(7, starts[7], stops[7], charRange('a', 'c'), b.command(7, antlr.LexerActionTypeType, WORD))
+	b.alt(8, starts[8], stops[8], atom('#'), b.command(8, antlr.LexerActionTypeChannel, antlr.TokenHiddenChannel))
+	b.mode(starts[0], starts[1], starts[2], starts[3])
+	b.mode(starts[4], starts[5])
+	b.mode(starts[6], starts[7], starts[8])
+	// the mode start states are decisions without a decision number
+	options := antlr.NewATNDeserializationOptions(nil)
+	options.SetVerifyATN(false)
+	lexer := &testLexer{antlr.NewBaseLexer(nil), b.buildWithOptions(options)}
+	lexer.RuleNames = []string{"WS", "QUOTE", "LT", "WORD", "STRING", "CHAR", "GT", "TAG_WORD", "COMMENT"}
+
+	parserBuilder := &atnBuilder{grammarType: antlr.ATNTypeParser, maxTokenType: COMMENT}
+	docStart, docStop := parserBuilder.rule(0)
+	itemStart, itemStop := parserBuilder.rule(0)
+	parserBuilder.alt(0, docStart, docStop, ruleRef(itemStart, 1))
+	parserBuilder.alt(0, docStart, docStop, ruleRef(itemStart, 1), ruleRef(docStart, 0))
+	parserBuilder.alt(1, itemStart, itemStop, atom(WORD))
+	parserBuilder.alt(1, itemStart, itemStop, atom(STRING))
+	parserBuilder.alt(1, itemStart, itemStop, atom(LT), atom(WORD), atom(GT))
+	parser := &testParser{antlr.NewBaseParser(nil), parserBuilder.build()}
+	parser.RuleNames = []string{"doc", "item"}
+	return parser, lexer
+}
+
+func TestLexerModel(t *testing.T) {
+	_, lexer := newModeGrammar()
+	model := lexerModels.get(lexer.GetATN())
+
+	// skipped and hidden tokens are never produced for the parser, TAG_WORD produces WORD
+	for tokenType, want := range map[int][]int{1: nil, 4: {3, 7}, 5: {4}, 8: nil, 9: nil} {
+		if got := model.tokenRules[tokenType]; !reflect.DeepEqual(got, want) {
+			t.Errorf("tokenRules[%d] = %v, want %v", tokenType, got, want)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		tokenType int
+		modes     lexerModes
+		want      []int
+	}{
+		{"WORD in the default mode", 4, lexerModes{}, []int{3}},
+		{"WORD in the tag mode", 4, lexerModes{2, []int{0}}, []int{7}},
+		{"STRING after QUOTE", 5, lexerModes{}, []int{1}},
+		{"STRING in the string mode", 5, lexerModes{1, []int{0}}, []int{4, 5}},
+		{"GT in the default mode", 7, lexerModes{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := model.tokenOptions(tt.tokenType, tt.modes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tokenOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLexerModes_Apply(t *testing.T) {
+	_, lexer := newModeGrammar()
+	model := lexerModels.get(lexer.GetATN())
+	modes := lexerModes{}.apply(model.rules[2])
+	if !reflect.DeepEqual(modes, lexerModes{2, []int{0}}) {
+		t.Errorf("apply(LT) = %v", modes)
+	}
+	if modes = modes.apply(model.rules[6]); modes.mode != 0 || len(modes.stack) != 0 {
+		t.Errorf("apply(GT) = %v", modes)
+	}
+	// ANTLR fails to pop an empty stack, the default mode is used instead
+	if modes = (lexerModes{mode: 1}).apply(model.rules[6]); modes.mode != 0 {
+		t.Errorf("apply(GT) with an empty stack = %v", modes)
+	}
+}
+
+func TestATNWalker_EncodeModes(t *testing.T) {
+	parser, lexer := newModeGrammar()
+	language := regexp.MustCompile(`^([a-c]|"[a-c]*"|<[a-c]>)+$`)
+	forms := map[string]*regexp.Regexp{
+		"string":       regexp.MustCompile(`"[a-c]+"`),
+		"empty string": regexp.MustCompile(`""`),
+		"tag":          regexp.MustCompile(`<[a-c]>`),
+	}
+	prng := rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		data := make([]byte, 1+prng.Intn(16))
+		prng.Read(data)
+		walker := NewATNWalker(parser, lexer)
+		walker.SetDeadline(time.Now().Add(time.Second))
+		text, tree := decodeTree(walker, data)
+		if tree == nil {
+			t.Fatalf("decodeTree(%v) timed out", data)
+		}
+		if !language.MatchString(text) {
+			t.Fatalf("Decode(%v) = %q which is not in the language", data, text)
+		}
+		for name, form := range forms {
+			if form.MatchString(text) {
+				delete(forms, name)
+			}
+		}
+
+		encoded := NewATNWalker(parser, lexer).Encode(tree)
+		if decoded := NewATNWalker(parser, lexer).Decode(encoded, nil); decoded != text {
+			t.Errorf("Decode(Encode(%q)) = %q", text, decoded)
+		}
+	}
+	for name := range forms {
+		t.Errorf("Decode() never produced a %s", name)
+	}
+}
@@ -0,0 +1,205 @@
+package atnwalk
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// the PID file stays open and locked for the whole lifetime of the server process,
+// the kernel releases the lock when the process dies, no matter how
+var pidFileLock *os.File
+
+// isAlive performs the handshake with the server at the address.
+func isAlive(address string, timeout time.Duration) bool {
+	buf := make([]byte, 1)
+	conn, err := dial(address)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	return writeAll(conn, []byte{AreYouAlive}) && readAll(conn, buf) && buf[0] == YesIAmAlive
+}
+
+// lockPidFile opens (or creates) the PID file and tries to lock it exclusively without blocking.
+func lockPidFile(pidFile string) (*os.File, bool, error) {
+	file, err := os.OpenFile(pidFile, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return file, true, nil
+}
+
+func readPid(pidFile string) (int, error) {
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// isServerProcess verifies that the process runs the same executable as the current process,
+// i.e., that it is an ATNWalk server of the same grammar and not an unrelated process that reuses the PID.
+func isServerProcess(pid int) bool {
+	self, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return false
+	}
+	other, err := os.Readlink("/proc/" + strconv.Itoa(pid) + "/exe")
+	if err != nil {
+		return false
+	}
+	// the executable of a running process may have been replaced by a new build in the meantime
+	return strings.TrimSuffix(other, " (deleted)") == strings.TrimSuffix(self, " (deleted)")
+}
+
+// isLocked reports whether a (server) process holds the lock of the PID file.
+func isLocked(pidFile string) bool {
+	file, err := os.OpenFile(pidFile, os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	return errors.Is(syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB), syscall.EWOULDBLOCK)
+}
+
+// ServerStatus reports the PID of the server as written to the PID file, whether the server process holds the lock
+// of the PID file (running), and whether it replies to the handshake at the address (healthy).
+func ServerStatus(pidFile, address string) (pid int, running, healthy bool) {
+	pid, _ = readPid(pidFile)
+	running = isLocked(pidFile)
+	healthy = running && isAlive(address, 100*time.Millisecond)
+	return pid, running, healthy
+}
+
+// StopServer sends SIGTERM to the server that holds the lock of the PID file and waits until it released the lock,
+// the server is killed with SIGKILL if it did not shut down within the timeout. Processes that do not run the same
+// executable are never signaled.
+func StopServer(pidFile string, timeout time.Duration) error {
+	if !isLocked(pidFile) {
+		return nil
+	}
+	pid, err := readPid(pidFile)
+	if err != nil {
+		return fmt.Errorf("cannot read the PID of the running server: %w", err)
+	}
+	if !isServerProcess(pid) {
+		return fmt.Errorf("process %d does not run this ATNWalk server, not stopping it", pid)
+	}
+	if err = syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if !isLocked(pidFile) {
+			return nil
+		}
+	}
+	if err = syscall.Kill(pid, syscall.SIGKILL); err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if !isLocked(pidFile) {
+			return nil
+		}
+	}
+	return fmt.Errorf("server process %d did not release the PID file %s", pid, pidFile)
+}
+
+func InitServerProcess(pidFile, address string) {
+	// isolate the process
+	syscall.Setsid()
+
+	file, ok, err := lockPidFile(pidFile)
+	if err != nil {
+		panic(err)
+	}
+
+	// another server process holds the lock, exit if it is healthy and stop it if it hangs
+	if !ok {
+		if isAlive(address, 10*time.Millisecond) {
+			os.Exit(0)
+		}
+		if err = StopServer(pidFile, time.Second); err != nil {
+			panic(err)
+		}
+		if file, ok, err = lockPidFile(pidFile); err != nil {
+			panic(err)
+		} else if !ok {
+			// yet another server was started in the meantime
+			os.Exit(0)
+		}
+	}
+
+	// write the pid into the locked file
+	if err = file.Truncate(0); err != nil {
+		panic(err)
+	}
+	if _, err = file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0); err != nil {
+		panic(err)
+	}
+	pidFileLock = file
+}
+
+// ReleaseServerProcess removes the socket file (if any) and the PID file and releases the lock of the PID file.
+func ReleaseServerProcess(pidFile, socketFile string) {
+	if socketFile != "" {
+		os.Remove(socketFile)
+	}
+	if pidFileLock != nil {
+		os.Remove(pidFile)
+		pidFileLock.Close()
+		pidFileLock = nil
+	}
+}
+
+// Serve handles the connections of the listener concurrently (at most maxConcurrent at once) until SIGTERM or SIGINT
+// is received, then it stops accepting connections and returns once all in-flight requests were handled.
+func Serve(listener net.Listener, maxConcurrent int, handle func(conn net.Conn)) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	stopping := make(chan struct{})
+	go func() {
+		<-signals
+		close(stopping)
+		listener.Close()
+	}()
+
+	inFlight := &sync.WaitGroup{}
+	semaphore := make(chan struct{}, maxConcurrent)
+	for {
+		semaphore <- struct{}{}
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-stopping:
+				inFlight.Wait()
+				return
+			default:
+				panic(err)
+			}
+		}
+		inFlight.Add(1)
+		go func() {
+			defer inFlight.Done()
+			handle(conn)
+			<-semaphore
+		}()
+	}
+}
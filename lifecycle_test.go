@@ -0,0 +1,86 @@
+package atnwalk
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockPidFile(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "atnwalk.pid")
+	if isLocked(pidFile) {
+		t.Errorf("isLocked() of a missing PID file should be false")
+	}
+
+	file, ok, err := lockPidFile(pidFile)
+	if err != nil || !ok {
+		t.Fatalf("lockPidFile() = %v, %v", ok, err)
+	}
+	if !isLocked(pidFile) {
+		t.Errorf("isLocked() should be true while the lock is held")
+	}
+	if _, ok, err = lockPidFile(pidFile); err != nil || ok {
+		t.Errorf("lockPidFile() of a locked PID file = %v, %v, want false, nil", ok, err)
+	}
+
+	file.Close()
+	if isLocked(pidFile) {
+		t.Errorf("isLocked() should be false after the lock was released")
+	}
+}
+
+func TestStopServer(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "atnwalk.pid")
+
+	// the PID file is not locked, i.e., the server is not running and the PID must not be signaled
+	if err := os.WriteFile(pidFile, []byte("1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := StopServer(pidFile, time.Millisecond); err != nil {
+		t.Errorf("StopServer() without a running server returned %v", err)
+	}
+
+	// the PID file is locked but the PID belongs to a process that runs another executable
+	file, _, err := lockPidFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := StopServer(pidFile, time.Millisecond); err == nil {
+		t.Errorf("StopServer() should refuse to signal an unrelated process")
+	}
+}
+
+func TestIsServerProcess(t *testing.T) {
+	if !isServerProcess(os.Getpid()) {
+		t.Errorf("isServerProcess(%d) of the current process should be true", os.Getpid())
+	}
+	if isServerProcess(1) {
+		t.Errorf("isServerProcess(1) should be false")
+	}
+	if isServerProcess(-1) {
+		t.Errorf("isServerProcess(-1) should be false")
+	}
+}
+
+func TestStartServer(t *testing.T) {
+	dir := t.TempDir()
+	pidFile, lockFile := filepath.Join(dir, "atnwalk.pid"), filepath.Join(dir, "atnwalk.pid.lock")
+	missing := filepath.Join(dir, "missing-server")
+
+	// nothing runs, i.e., the (missing) server binary is started
+	if err := StartServer(pidFile, lockFile, missing); err == nil {
+		t.Errorf("StartServer() of a missing binary should fail")
+	}
+
+	// a running server is kept even if it does not reply, i.e., nothing is started
+	file, _, err := lockPidFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := StartServer(pidFile, lockFile, missing); err != nil {
+		t.Errorf("StartServer() with a running server returned %v", err)
+	}
+}
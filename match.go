@@ -0,0 +1,223 @@
+package atnwalk
+
+import (
+	"math"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+type matchKey struct {
+	state  int
+	cursor int
+}
+
+// lexerMatcher matches a text with the rules of a lexer ATN. The end positions that can be reached from a state at a
+// cursor without leaving the rule of the state are memoized, hence, each (state, cursor) pair is expanded once and
+// matching takes polynomial time instead of backtracking over all possible lengths of all sub-rule matches.
+type lexerMatcher struct {
+	text []rune
+	ends map[matchKey][]int
+	// the recursion depth of the pairs that are currently expanded, to cut epsilon cycles
+	expanding map[matchKey]int
+}
+
+func newLexerMatcher(text []rune) *lexerMatcher {
+	return &lexerMatcher{text: text, ends: map[matchKey][]int{}, expanding: map[matchKey]int{}}
+}
+
+// match returns a trace of the rule that starts with the state and matches the whole text, or nil if there is none.
+// Choices are tried in the order of the transitions and sub-rules match as few runes as possible.
+func match(text []rune, state antlr.ATNState) *LexerTrace {
+	m := newLexerMatcher(text)
+	if !m.reaches(state, 0, len(text)) {
+		return nil
+	}
+	return m.trace(state, 0, len(text))
+}
+
+// consumes reports whether the transition consumes the rune, it panics if the transition does not consume any rune.
+func consumes(transition antlr.Transition, r rune) bool {
+	switch t := transition.(type) {
+	case *antlr.AtomTransition:
+		return r == rune(t.GetLabelValue())
+	// a NotSetTransition is not a SetTransition in Go, the order does not matter here
+	case *antlr.NotSetTransition:
+		return r >= 0 && r <= antlr.LexerMaxCharValue && !t.GetLabel().Contains(int(r))
+	case *antlr.SetTransition:
+		return t.GetLabel().Contains(int(r))
+	case *antlr.RangeTransition:
+		return t.GetLabel().Contains(int(r))
+	case *antlr.WildcardTransition:
+		return r >= 0 && r <= antlr.LexerMaxCharValue
+	}
+	panic("transition does not consume runes")
+}
+
+func isConsuming(transition antlr.Transition) bool {
+	switch transition.(type) {
+	case *antlr.AtomTransition, *antlr.NotSetTransition, *antlr.SetTransition, *antlr.RangeTransition,
+		*antlr.WildcardTransition:
+		return true
+	}
+	return false
+}
+
+// reaches reports whether the rule stop state can be reached from the state at the cursor with the end position.
+func (m *lexerMatcher) reaches(state antlr.ATNState, cursor, end int) bool {
+	ends, _ := m.reach(state, cursor, 0)
+	for _, e := range ends {
+		if e == end {
+			return true
+		}
+	}
+	return false
+}
+
+// reach returns the sorted end positions at which the rule stop state is reached from the state at the cursor.
+// Pairs that are being expanded are cut, the result is only memoized if no cut happened below the pair's own depth,
+// otherwise the lowest depth of a cut is returned. Cutting the pair itself is sound since a cycle that does not
+// consume any rune cannot reach other end positions than the pair itself.
+func (m *lexerMatcher) reach(state antlr.ATNState, cursor, depth int) ([]int, int) {
+	key := matchKey{state.GetStateNumber(), cursor}
+	if ends, ok := m.ends[key]; ok {
+		return ends, math.MaxInt
+	}
+	if cutDepth, ok := m.expanding[key]; ok {
+		return nil, cutDepth
+	}
+	if state.GetStateType() == antlr.ATNStateRuleStop {
+		m.ends[key] = []int{cursor}
+		return m.ends[key], math.MaxInt
+	}
+
+	m.expanding[key] = depth
+	var ends []int
+	cut := math.MaxInt
+	collect := func(next antlr.ATNState, cursor int) {
+		nextEnds, nextCut := m.reach(next, cursor, depth+1)
+		ends = mergeEnds(ends, nextEnds)
+		if nextCut < cut {
+			cut = nextCut
+		}
+	}
+	for _, transition := range state.GetTransitions() {
+		switch t := transition.(type) {
+		case *antlr.RuleTransition:
+			ruleEnds, ruleCut := m.reach(t.GetTarget(), cursor, depth+1)
+			if ruleCut < cut {
+				cut = ruleCut
+			}
+			for _, ruleEnd := range ruleEnds {
+				collect(t.GetFollowState(), ruleEnd)
+			}
+		default:
+			// EOF consumes nothing but only matches at the end of the text
+			if isEOF(transition) {
+				if cursor == len(m.text) {
+					collect(transition.(antlr.AnyTransition).GetTarget(), cursor)
+				}
+			} else if !isConsuming(transition) {
+				collect(transition.(antlr.AnyTransition).GetTarget(), cursor)
+			} else if cursor < len(m.text) && consumes(transition, m.text[cursor]) {
+				collect(transition.(antlr.AnyTransition).GetTarget(), cursor+1)
+			}
+		}
+	}
+	delete(m.expanding, key)
+
+	if cut < depth {
+		return ends, cut
+	}
+	m.ends[key] = ends
+	return ends, math.MaxInt
+}
+
+// mergeEnds returns the sorted union of two sorted end positions, it reuses a if b adds nothing.
+func mergeEnds(a, b []int) []int {
+	if len(b) == 0 {
+		return a
+	}
+	if len(a) == 0 {
+		return b
+	}
+	merged := make([]int, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case j == len(b) || (i < len(a) && a[i] < b[j]):
+			merged = append(merged, a[i])
+			i++
+		case i == len(a) || b[j] < a[i]:
+			merged = append(merged, b[j])
+			j++
+		default:
+			merged = append(merged, a[i])
+			i++
+			j++
+		}
+	}
+	return merged
+}
+
+// trace returns the trace of the rule from the state at the begin position to the rule stop state at the end
+// position, the end must be reachable. The text and the cursors of the trace are relative to the begin position.
+func (m *lexerMatcher) trace(state antlr.ATNState, begin, end int) *LexerTrace {
+	trace := &LexerTrace{Text: m.text[begin:end]}
+	m.follow(trace, state, begin, begin, end, map[matchKey]struct{}{})
+	return trace
+}
+
+// follow appends the edges from the state at the cursor to the rule stop state at the end to the trace, it only
+// follows transitions that reach the end, i.e., it is a depth-first search that only backtracks at cycles.
+func (m *lexerMatcher) follow(trace *LexerTrace, state antlr.ATNState, begin, cursor, end int,
+	visited map[matchKey]struct{}) bool {
+	if state.GetStateType() == antlr.ATNStateRuleStop {
+		return cursor == end
+	}
+	key := matchKey{state.GetStateNumber(), cursor}
+	if _, ok := visited[key]; ok {
+		return false
+	}
+	visited[key] = struct{}{}
+
+	for choice, transition := range state.GetTransitions() {
+		edge := &LexerTraceEdge{state, choice, cursor - begin, len(trace.SubTraces)}
+		switch t := transition.(type) {
+		case *antlr.RuleTransition:
+			ruleEnds, _ := m.reach(t.GetTarget(), cursor, 0)
+			for _, ruleEnd := range ruleEnds {
+				if ruleEnd > end || !m.reaches(t.GetFollowState(), ruleEnd, end) {
+					continue
+				}
+				trace.Edges = append(trace.Edges, edge)
+				trace.SubTraces = append(trace.SubTraces, m.trace(t.GetTarget(), cursor, ruleEnd))
+				if m.follow(trace, t.GetFollowState(), begin, ruleEnd, end, visited) {
+					return true
+				}
+				trace.Edges = trace.Edges[:len(trace.Edges)-1]
+				trace.SubTraces = trace.SubTraces[:edge.SubTraceLen]
+			}
+			continue
+		}
+		next, nextCursor := transition.(antlr.AnyTransition).GetTarget(), cursor
+		if isEOF(transition) {
+			if cursor != len(m.text) {
+				continue
+			}
+		} else if isConsuming(transition) {
+			if cursor >= end || !consumes(transition, m.text[cursor]) {
+				continue
+			}
+			nextCursor++
+		}
+		if !m.reaches(next, nextCursor, end) {
+			continue
+		}
+		trace.Edges = append(trace.Edges, edge)
+		if m.follow(trace, next, begin, nextCursor, end, visited) {
+			return true
+		}
+		trace.Edges = trace.Edges[:len(trace.Edges)-1]
+	}
+	return false
+}
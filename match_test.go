@@ -0,0 +1,336 @@
+package atnwalk
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+// to avoid memory leaks
+func cleanupTraces(traceNodes ...*LexerTrace) {
+	stack := &Stack[*LexerTrace]{}
+	for _, t := range traceNodes {
+		stack.Push(t)
+	}
+	var node *LexerTrace
+	for !stack.IsEmpty() {
+		node = stack.Pop()
+		if node.SubTraces != nil {
+			for _, st := range node.SubTraces {
+				stack.Push(st)
+			}
+		}
+		node.SubTraces = nil
+	}
+}
+
+func backtrackingRuleMatches(text []rune, cursor int, state antlr.ATNState) *Stack[*LexerTrace] {
+	semaphore := make(chan struct{}, runtime.NumCPU())
+	for i := 0; i < runtime.NumCPU(); i++ {
+		semaphore <- struct{}{}
+	}
+	results := make(chan *LexerTrace, runtime.NumCPU())
+	for i := len(text); i > cursor; i-- {
+		go func(i int) {
+			<-semaphore
+			results <- backtrackingMatch(text[cursor:i], state)
+			semaphore <- struct{}{}
+		}(i)
+	}
+	stack := &Stack[*LexerTrace]{}
+	for i := len(text); i > cursor; i-- {
+		solution := <-results
+		if solution != nil {
+			stack.Push(solution)
+		}
+	}
+	return stack
+}
+
+// backtrackingMatch is the previous implementation of match that tries every length of every sub-rule match and
+// backtracks exhaustively, it is kept to compare the traces and the performance
+func backtrackingMatch(text []rune, state antlr.ATNState) *LexerTrace {
+	// return valid trace if we can match the text, otherwise return nil
+
+	var transition antlr.Transition
+	cursor := 0
+	choice := 0
+	traceStack := &Stack[*LexerTraceEdge]{}
+
+	ruleMatches := map[string]*Stack[*LexerTrace]{}
+
+	var subTraces []*LexerTrace
+	for !(state.GetStateType() == antlr.ATNStateRuleStop && cursor == len(text)) {
+		// backtrack or report mismatch
+		if choice >= len(state.GetTransitions()) && len(state.GetTransitions()) > 0 || state.GetStateType() == antlr.ATNStateRuleStop {
+			// report mismatch
+			if traceStack.IsEmpty() {
+				cleanupTraces(subTraces...)
+				for i := 0; i < len(subTraces); i++ {
+					subTraces[i] = nil
+				}
+				return nil
+			}
+			// backtrack
+			t := traceStack.Pop()
+			state = t.State
+			choice = t.Choice + 1
+			cursor = t.Cursor
+
+			// special case, when other token matches could be possible
+			// do not continue with the next choice then, but with the next match
+			id := traceID(t.State.GetStateNumber(), t.Choice, cursor)
+			if possibleMatches, ok := ruleMatches[id]; ok {
+				if !possibleMatches.IsEmpty() {
+					choice = t.Choice
+				}
+			}
+
+			cleanupTraces(subTraces[t.SubTraceLen:]...)
+			for i := len(subTraces) - 1; i >= t.SubTraceLen; i-- {
+				subTraces[i] = nil
+			}
+			subTraces = subTraces[:t.SubTraceLen]
+			continue
+		}
+
+		transition = state.GetTransitions()[choice]
+		switch t := transition.(type) {
+		case *antlr.RuleTransition:
+			if cursor < len(text) {
+
+				id := traceID(state.GetStateNumber(), choice, cursor)
+				possibleMatches, ok := ruleMatches[id]
+				if !ok {
+					possibleMatches = backtrackingRuleMatches(text, cursor, transition.(antlr.AnyTransition).GetTarget())
+					ruleMatches[id] = possibleMatches
+				}
+
+				if !possibleMatches.IsEmpty() {
+					trace := possibleMatches.Pop()
+					traceStack.Push(&LexerTraceEdge{state, choice, cursor, len(subTraces)})
+					subTraces = append(subTraces, trace)
+					state = t.GetFollowState()
+					cursor += len(trace.Text)
+					choice = 0
+					continue
+				}
+			}
+		case *antlr.AtomTransition:
+			if cursor < len(text) {
+				if text[cursor] == rune(t.GetLabelValue()) {
+					traceStack.Push(&LexerTraceEdge{state, choice, cursor, len(subTraces)})
+					state = transition.(antlr.AnyTransition).GetTarget()
+					cursor++
+					choice = 0
+					continue
+				}
+			}
+		case *antlr.NotSetTransition:
+			if cursor < len(text) {
+				possibleRunes := t.GetLabel().Complement()
+				if possibleRunes.Contains(int(text[cursor])) {
+					traceStack.Push(&LexerTraceEdge{state, choice, cursor, len(subTraces)})
+					state = transition.(antlr.AnyTransition).GetTarget()
+					cursor++
+					choice = 0
+					continue
+				}
+			}
+		case *antlr.SetTransition:
+			if cursor < len(text) {
+				possibleRunes := t.GetLabel()
+				if possibleRunes.Contains(int(text[cursor])) {
+					traceStack.Push(&LexerTraceEdge{state, choice, cursor, len(subTraces)})
+					state = transition.(antlr.AnyTransition).GetTarget()
+					cursor++
+					choice = 0
+					continue
+				}
+			}
+		case *antlr.RangeTransition:
+			if cursor < len(text) {
+				possibleRunes := t.GetLabel()
+				if possibleRunes.Contains(int(text[cursor])) {
+					traceStack.Push(&LexerTraceEdge{state, choice, cursor, len(subTraces)})
+					state = transition.(antlr.AnyTransition).GetTarget()
+					cursor++
+					choice = 0
+					continue
+				}
+			}
+		default:
+			traceStack.Push(&LexerTraceEdge{state, choice, cursor, len(subTraces)})
+			state = transition.(antlr.AnyTransition).GetTarget()
+			choice = 0
+			continue
+		}
+		// try next choice from the current state
+		choice++
+	}
+
+	edges := make([]*LexerTraceEdge, traceStack.Size())
+	for i := traceStack.Size() - 1; i >= 0; i-- {
+		edges[i] = traceStack.Pop()
+	}
+
+	return &LexerTrace{text, edges, subTraces}
+}
+
+func traceID(state, choice, cursor int) string {
+	idBytes := make([]byte, 0, 24)
+	for _, number := range []int{state, choice, cursor} {
+		idBytes = append(idBytes,
+			byte(number>>56),
+			byte(number&0x00ff000000000000>>48),
+			byte(number&0x0000ff0000000000>>40),
+			byte(number&0x000000ff00000000>>32),
+			byte(number&0x00000000ff000000>>24),
+			byte(number&0x0000000000ff0000>>16),
+			byte(number&0x000000000000ff00>>8),
+			byte(number&0x00000000000000ff))
+	}
+	return string(idBytes)
+}
+
+// replay returns the text that the trace produces and fails if an edge does not fit the text or the ATN
+func replay(t *testing.T, trace *LexerTrace) string {
+	builder := strings.Builder{}
+	for i, edge := range trace.Edges {
+		transition := edge.State.GetTransitions()[edge.Choice]
+		next := transition.(antlr.AnyTransition).GetTarget()
+		switch rt := transition.(type) {
+		case *antlr.RuleTransition:
+			builder.WriteString(replay(t, trace.SubTraces[edge.SubTraceLen]))
+			next = rt.GetFollowState()
+		default:
+			if isConsuming(transition) {
+				builder.WriteRune(trace.Text[edge.Cursor])
+				if !consumes(transition, trace.Text[edge.Cursor]) {
+					t.Fatalf("edge %d does not consume %q", i, trace.Text[edge.Cursor])
+				}
+			}
+		}
+		if i+1 < len(trace.Edges) && trace.Edges[i+1].State != next {
+			t.Fatalf("edge %d does not lead to the state of edge %d", i, i+1)
+		}
+	}
+	return builder.String()
+}
+
+func TestMatch(t *testing.T) {
+	_, lexer := newUnicodeGrammar()
+	starts := lexer.GetATN().GetRuleIndexToStartStateSlice()
+	for _, tt := range []struct {
+		text    string
+		rule    int
+		matches bool
+	}{
+		{"α", 0, true},
+		{"ω😀", 0, true},
+		{"ü€", 0, true},
+		{"ß", 1, true},
+		{"αβγ", 0, false},
+		{"a", 0, false},
+		{"", 0, false},
+	} {
+		trace := match([]rune(tt.text), starts[tt.rule])
+		if (trace != nil) != tt.matches {
+			t.Errorf("match(%q) = %v, want a match: %v", tt.text, trace, tt.matches)
+			continue
+		}
+		if (backtrackingMatch([]rune(tt.text), starts[tt.rule]) != nil) != tt.matches {
+			t.Errorf("backtrackingMatch(%q) disagrees with match()", tt.text)
+		}
+		if trace != nil {
+			if replayed := replay(t, trace); replayed != tt.text {
+				t.Errorf("match(%q) returned a trace of %q", tt.text, replayed)
+			}
+		}
+	}
+}
+
+// newRecursiveLexer returns a lexer ATN of the rules below, the loop in ID and the cycle in EMPTY do not consume
+// anything before they return to the same state (the ATN is not verified since the ANTLR tool would reject them):
+//
+//	ID : CHAR ID | CHAR ;
+//	LIST : (ID ',')* ID ;
+//	EMPTY : ('')* 'e' ;
+//	fragment CHAR : [a-z] ;
+func newRecursiveLexer() *antlr.ATN {
+	b := &atnBuilder{grammarType: antlr.ATNTypeLexer, maxTokenType: 3}
+	idStart, idStop := b.rule(1)
+	listStart, listStop := b.rule(2)
+	emptyStart, emptyStop := b.rule(3)
+	charStart, charStop := b.rule(0)
+	b.alt(0, idStart, idStop, ruleRef(charStart, 3), ruleRef(idStart, 0))
+	b.alt(0, idStart, idStop, ruleRef(charStart, 3))
+	b.alt(3, charStart, charStop, charRange('a', 'z'))
+
+	// the loop state of LIST either matches ID ',' and returns or matches the last ID
+	loop := b.state(antlr.ATNStateBasic, 1)
+	b.edge(listStart, loop, step{antlr.TransitionEPSILON})
+	afterID := b.state(antlr.ATNStateBasic, 1)
+	b.edge(loop, afterID, ruleRef(idStart, 0))
+	b.edge(afterID, loop, atom(','))
+	b.alt(1, loop, listStop, ruleRef(idStart, 0))
+
+	// the cycle of EMPTY does not consume anything
+	cycle, other := b.state(antlr.ATNStateBasic, 2), b.state(antlr.ATNStateBasic, 2)
+	b.edge(emptyStart, cycle, step{antlr.TransitionEPSILON})
+	b.edge(cycle, other, step{antlr.TransitionEPSILON})
+	b.edge(other, cycle, step{antlr.TransitionEPSILON})
+	b.alt(2, cycle, emptyStop, atom('e'))
+
+	options := antlr.NewATNDeserializationOptions(nil)
+	options.SetVerifyATN(false)
+	return b.buildWithOptions(options)
+}
+
+func TestMatch_Recursion(t *testing.T) {
+	starts := newRecursiveLexer().GetRuleIndexToStartStateSlice()
+	for _, tt := range []struct {
+		text    string
+		rule    int
+		matches bool
+	}{
+		{"abc", 0, true},
+		{strings.Repeat("x", 200), 0, true},
+		{"ab,c,def", 1, true},
+		{"ab,,c", 1, false},
+		{"ab,", 1, false},
+		{"e", 2, true},
+		{"ee", 2, false},
+	} {
+		trace := match([]rune(tt.text), starts[tt.rule])
+		if (trace != nil) != tt.matches {
+			t.Errorf("match(%q) = %v, want a match: %v", tt.text, trace, tt.matches)
+			continue
+		}
+		if trace != nil {
+			if replayed := replay(t, trace); replayed != tt.text {
+				t.Errorf("match(%q) returned a trace of %q", tt.text, replayed)
+			}
+		}
+	}
+}
+
+func BenchmarkMatch(b *testing.B) {
+	idStart := newRecursiveLexer().GetRuleIndexToStartStateSlice()[0]
+	for _, length := range []int{4, 8, 12} {
+		text := []rune(strings.Repeat("x", length))
+		b.Run(fmt.Sprintf("memoized/%d", length), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				match(text, idStart)
+			}
+		})
+		b.Run(fmt.Sprintf("backtracking/%d", length), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				backtrackingMatch(text, idStart)
+			}
+		})
+	}
+}
@@ -21,10 +21,16 @@ type Router struct {
 	startState     int
 	stopState      int
 	ruleIndex      int
+	isLexerRule    bool
+	strategy       RoutingStrategy
 	nextChoices    *Stack[int]
 }
 
-func NewRouter(ruleIndex, startState, stopState int, atn *antlr.ATN, decoder *Decoder) *Router {
+func NewRouter(ruleIndex, startState, stopState int, isLexerRule bool, atn *antlr.ATN, decoder *Decoder,
+	strategy RoutingStrategy) *Router {
+	if strategy == nil {
+		strategy = &DefaultStrategy{}
+	}
 	return &Router{
 		stateToOptions: map[int]*RouteOptions{},
 		decoder:        decoder,
@@ -32,6 +38,8 @@ func NewRouter(ruleIndex, startState, stopState int, atn *antlr.ATN, decoder *De
 		startState:     startState,
 		stopState:      stopState,
 		ruleIndex:      ruleIndex,
+		isLexerRule:    isLexerRule,
+		strategy:       strategy,
 		nextChoices:    &Stack[int]{}}
 }
 
@@ -45,10 +53,13 @@ type RouteOptions struct {
 func (r *Router) NewRouteOptions(state int) *RouteOptions {
 	numChoices := len(r.atn.GetStates()[state].GetTransitions())
 	choiceToNextState := make([]int, numChoices)
-	notVisitedChoices := make([]int, numChoices)
+	notVisitedChoices := make([]int, 0, numChoices)
 	for i := 0; i < numChoices; i++ {
 		choiceToNextState[i] = -128
-		notVisitedChoices[i] = i
+		// disabled choices are never visited
+		if !r.isDisabled(state, i) {
+			notVisitedChoices = append(notVisitedChoices, i)
+		}
 	}
 	return &RouteOptions{
 		choiceToNextState:               choiceToNextState,
@@ -242,6 +253,48 @@ func NewPriorityQueue(router *Router, initialState int, prngSource rand.Source)
 }
 
 func (r *Router) route(state int, rootPathRules map[int]struct{}) int {
+	return r.strategy.Route(r, state, rootPathRules)
+}
+
+// randomChoice returns one of the allowed transitions of the state uniformly at random.
+func (r *Router) randomChoice(state int) int {
+	numChoices := len(r.atn.GetStates()[state].GetTransitions())
+	choices := make([]int, 0, numChoices)
+	for choice := 0; choice < numChoices; choice++ {
+		if !r.isDisabled(state, choice) {
+			choices = append(choices, choice)
+		}
+	}
+	if len(choices) == 0 {
+		return int(r.decoder.prngSource.Int63()) % numChoices
+	}
+	return choices[int(r.decoder.prngSource.Int63())%len(choices)]
+}
+
+// isDisabled reports whether the routing strategy disables the choice of the state (see ChoiceFilter).
+func (r *Router) isDisabled(state, choice int) bool {
+	filter, ok := r.strategy.(ChoiceFilter)
+	return ok && filter.ChoiceDisabled(r.isLexerRule, state, choice)
+}
+
+// allowedChoice returns the choice or, if it is disabled, the next allowed choice of the state (wrapping around).
+// The choice is returned as is if all choices of the state are disabled.
+func (r *Router) allowedChoice(state, choice int) int {
+	numChoices := len(r.atn.GetStates()[state].GetTransitions())
+	for i := 0; i < numChoices; i++ {
+		if next := (choice + i) % numChoices; !r.isDisabled(state, next) {
+			return next
+		}
+	}
+	return choice
+}
+
+// hasPlannedRoute reports whether choices of a previously planned route are left to follow.
+func (r *Router) hasPlannedRoute() bool {
+	return !r.nextChoices.IsEmpty()
+}
+
+func (r *Router) planRoute(state int, rootPathRules map[int]struct{}) int {
 	// route with the previously found choices
 	if !r.nextChoices.IsEmpty() {
 		return r.nextChoices.Pop()
@@ -249,7 +302,7 @@ func (r *Router) route(state int, rootPathRules map[int]struct{}) int {
 
 	// just return a random choice, nothing about this state has been previously learned
 	if _, ok := r.stateToOptions[state]; !ok {
-		return int(r.decoder.prngSource.Int63()) % len(r.atn.GetStates()[state].GetTransitions())
+		return r.randomChoice(state)
 		// return r.decoder.Decode(len(r.atn.GetStates()[state].GetTransitions()))
 	}
 
@@ -0,0 +1,186 @@
+package atnwalk
+
+import (
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+// Predicate decides whether the walker may take a predicated transition while decoding, i.e., it mimics a semantic
+// predicate {...}? of the grammar. The node is the *RuleNode (parser) or *SymbolNode (lexer) that is decoded.
+type Predicate func(node TreeNode) bool
+
+// Action is called when the walker takes an action transition {...} of the grammar while decoding, e.g., to keep the
+// state that predicates depend on. The node is the *RuleNode (parser) or *SymbolNode (lexer) that is decoded.
+type Action func(node TreeNode)
+
+type semanticKey struct {
+	isLexerRule bool
+	ruleIndex   int
+	index       int
+}
+
+// SetPredicate registers the predicate with the predicate index of the rule (the indices of the generated
+// recognizer's Sempred method), nil removes it. Predicates without a registered callback always hold. Precedence
+// predicates of left-recursive rules are evaluated by the walker and cannot be overridden.
+func (w *ATNWalker) SetPredicate(ruleIndex, predIndex int, isLexerRule bool, predicate Predicate) {
+	key := semanticKey{isLexerRule, ruleIndex, predIndex}
+	if predicate == nil {
+		delete(w.predicates, key)
+		return
+	}
+	if w.predicates == nil {
+		w.predicates = map[semanticKey]Predicate{}
+	}
+	w.predicates[key] = predicate
+}
+
+// SetAction registers the action with the action index of the rule (the indices of the generated recognizer's
+// Action method), nil removes it. Actions without a registered callback are ignored.
+func (w *ATNWalker) SetAction(ruleIndex, actionIndex int, isLexerRule bool, action Action) {
+	key := semanticKey{isLexerRule, ruleIndex, actionIndex}
+	if action == nil {
+		delete(w.actions, key)
+		return
+	}
+	if w.actions == nil {
+		w.actions = map[semanticKey]Action{}
+	}
+	w.actions[key] = action
+}
+
+// holds reports whether the predicate of the transition holds in the node, transitions without a predicate hold. A
+// precedence predicate holds if its precedence is at least the precedence that the rule node was invoked with.
+func (w *ATNWalker) holds(node TreeNode, transition antlr.Transition) bool {
+	switch t := transition.(type) {
+	case *antlr.PrecedencePredicateTransition:
+		if n, ok := node.(*RuleNode); ok {
+			return t.GetPrecedence() >= n.precedence
+		}
+	case *antlr.PredicateTransition:
+		_, isLexerRule := node.(*SymbolNode)
+		if predicate, ok := w.predicates[semanticKey{isLexerRule, t.GetRuleIndex(), t.GetPredIndex()}]; ok {
+			return predicate(node)
+		}
+	}
+	return true
+}
+
+// act calls the registered action of the action transition. In lexers, the action transitions refer to the lexer
+// actions, only custom actions have the rule and action index of the generated lexer's Action method.
+func (w *ATNWalker) act(node TreeNode, t *antlr.ActionTransition) {
+	key := semanticKey{false, t.GetRuleIndex(), t.GetActionIndex()}
+	if _, isLexerRule := node.(*SymbolNode); isLexerRule {
+		lexerActions := w.Lexer.GetATN().GetLexerActions()
+		if t.GetActionIndex() < 0 || t.GetActionIndex() >= len(lexerActions) {
+			return
+		}
+		custom, ok := lexerActions[t.GetActionIndex()].(*antlr.LexerCustomAction)
+		if !ok {
+			return
+		}
+		key = semanticKey{true, custom.GetRuleIndex(), custom.GetActionIndex()}
+	}
+	if action, ok := w.actions[key]; ok {
+		action(node)
+	}
+}
+
+// guardModel describes where predicates guard the paths of an ATN, i.e., where passable needs to evaluate them.
+type guardModel struct {
+	// whether a predicate is reachable from the state without consuming tokens (parser) or characters (lexer) and
+	// without entering or leaving a rule
+	guarded []bool
+}
+
+// guardModels shares the guard models of the ATNs between walkers.
+var guardModels = newATNCache(newGuardModel)
+
+func newGuardModel(atn *antlr.ATN) *guardModel {
+	states := atn.GetStates()
+	model := &guardModel{guarded: make([]bool, len(states))}
+	for changed := true; changed; {
+		changed = false
+		for i, state := range states {
+			if state == nil || model.guarded[i] {
+				continue
+			}
+			for _, transition := range state.GetTransitions() {
+				if model.isGuarded(transition) {
+					model.guarded[i] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	return model
+}
+
+// isGuarded reports whether the transition is a predicate or whether the epsilon path that starts with it reaches one,
+// passable always holds for transitions that are not guarded.
+func (m *guardModel) isGuarded(transition antlr.Transition) bool {
+	switch t := transition.(type) {
+	case *antlr.PrecedencePredicateTransition, *antlr.PredicateTransition:
+		return true
+	case *antlr.EpsilonTransition, *antlr.ActionTransition:
+		return m.guarded[t.(antlr.AnyTransition).GetTarget().GetStateNumber()]
+	}
+	return false
+}
+
+// passable reports whether the predicates on the epsilon path that starts with the transition let the walker reach a
+// transition that matches something, a rule transition, or the rule stop state.
+func (w *ATNWalker) passable(node TreeNode, transition antlr.Transition, visited map[int]struct{}) bool {
+	if !w.holds(node, transition) {
+		return false
+	}
+	switch transition.(type) {
+	case *antlr.EpsilonTransition, *antlr.PrecedencePredicateTransition, *antlr.PredicateTransition,
+		*antlr.ActionTransition:
+	default:
+		return true
+	}
+	target := transition.(antlr.AnyTransition).GetTarget()
+	if target.GetStateType() == antlr.ATNStateRuleStop {
+		return true
+	}
+	if _, ok := visited[target.GetStateNumber()]; ok {
+		return false
+	}
+	visited[target.GetStateNumber()] = struct{}{}
+	for _, next := range target.GetTransitions() {
+		if w.passable(node, next, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// viableChoice maps the choice to the next choice of the state that the router allows, whose predicates hold, and that
+// does not end the input unless the input can end (see canEnd). The choice is kept if there is no such choice, a choice
+// out of the range of the transitions (e.g., from a strategy) is wrapped into it first. If the choice changes, the
+// route that the router planned is dropped.
+func (w *ATNWalker) viableChoice(node TreeNode, ends *endModel, guards *guardModel, router *Router, state,
+	choice int) int {
+	transitions := ends.atn.GetStates()[state].GetTransitions()
+	viable := func(choice int) bool {
+		return (!guards.isGuarded(transitions[choice]) || w.passable(node, transitions[choice], map[int]struct{}{})) &&
+			(!ends.ends(transitions[choice]) || w.canEnd())
+	}
+	routed := choice
+	if choice < 0 || choice >= len(transitions) {
+		choice = (choice%len(transitions) + len(transitions)) % len(transitions)
+	}
+	if !viable(choice) {
+		for i := 1; i < len(transitions); i++ {
+			if next := (choice + i) % len(transitions); !router.isDisabled(state, next) && viable(next) {
+				choice = next
+				break
+			}
+		}
+	}
+	if choice != routed {
+		// the remaining choices of a planned route would not match the states anymore
+		router.nextChoices = &Stack[int]{}
+	}
+	return choice
+}
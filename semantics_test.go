@@ -0,0 +1,226 @@
+package atnwalk
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+func precedence(p int) step {
+	return step{antlr.TransitionPRECEDENCE, p, 0, 0}
+}
+
+func predicate(ruleIndex, predIndex int) step {
+	return step{antlr.TransitionPREDICATE, ruleIndex, predIndex, 0}
+}
+
+func action(ruleIndex, actionIndex int) step {
+	return step{antlr.TransitionACTION, ruleIndex, actionIndex, 0}
+}
+
+// newExprGrammar returns the parser and the lexer of the grammar:
+//
+//	e : e '*' e | e '+' e | INT ;
+//
+// which ANTLR rewrites to:
+//
+//	e[int _p] : INT ( {precpred(_ctx, 2)}? '*' e[3] | {precpred(_ctx, 1)}? '+' e[2] )* ;
+func newExprGrammar() (*testParser, *testLexer) {
+	const INT, MUL, ADD = 1, 2, 3
+
+	lexerBuilder := &atnBuilder{grammarType: antlr.ATNTypeLexer, maxTokenType: ADD}
+	for i, r := range []rune{'1', '*', '+'} {
+		start, stop := lexerBuilder.rule(i + 1)
+		lexerBuilder.alt(i, start, stop, atom(int(r)))
+	}
+	lexer := &testLexer{antlr.NewBaseLexer(nil), lexerBuilder.build()}
+	lexer.RuleNames = []string{"INT", "MUL", "ADD"}
+
+	b := &atnBuilder{grammarType: antlr.ATNTypeParser, maxTokenType: ADD}
+	start, stop := b.rule(0)
+	loop, block := b.state(antlr.ATNStateBasic, 0), b.state(antlr.ATNStateBasic, 0)
+	b.alt(0, start, loop, atom(INT))
+	b.edge(loop, block, step{antlr.TransitionEPSILON})
+	b.edge(loop, stop, step{antlr.TransitionEPSILON})
+	b.alt(0, block, loop, precedence(2), atom(MUL), step{antlr.TransitionRULE, start, 0, 3})
+	b.alt(0, block, loop, precedence(1), atom(ADD), step{antlr.TransitionRULE, start, 0, 2})
+	// the loop uses basic states instead of the loop states of the ANTLR tool
+	options := antlr.NewATNDeserializationOptions(nil)
+	options.SetVerifyATN(false)
+	parser := &testParser{antlr.NewBaseParser(nil), b.buildWithOptions(options)}
+	parser.RuleNames = []string{"e"}
+	return parser, lexer
+}
+
+func TestATNWalker_Precedence(t *testing.T) {
+	parser, lexer := newExprGrammar()
+	// the lowest precedence that each operator can be used with
+	operators := map[int]int{2: 2, 3: 1}
+	var check func(node *RuleNode) bool
+	nested := false
+	check = func(node *RuleNode) bool {
+		for _, child := range node.Children {
+			switch c := child.(type) {
+			case *SymbolNode:
+				if lowest, ok := operators[c.TokenType]; ok && node.precedence > lowest {
+					return false
+				}
+				nested = nested || node.precedence == 2 && c.TokenType == 2
+			case *RuleNode:
+				if !check(c) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	prng := rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		data := make([]byte, 1+prng.Intn(8))
+		prng.Read(data)
+		walker := NewATNWalker(parser, lexer)
+		walker.SetRoutingStrategy(&RandomStrategy{})
+		walker.SetDeadline(time.Now().Add(time.Second))
+		decoder := NewDecoder(data, 1, 3, nil)
+		root := NewRuleNode(nil, parser.GetATN().GetRuleIndexToStartStateSlice()[0])
+		if !walker.AssembleTree(decoder, root, nil) {
+			t.Fatalf("AssembleTree(%v) timed out", data)
+		}
+		text := walker.TreeToString(root, &Stack[TreeNode]{})
+		if !check(root) {
+			t.Fatalf("Decode(%v) = %q violates the operator precedence", data, text)
+		}
+
+		encoded := NewATNWalker(parser, lexer).Encode(toParseTree(root))
+		if decoded := NewATNWalker(parser, lexer).Decode(encoded, nil); decoded != text {
+			t.Errorf("Decode(Encode(%q)) = %q", text, decoded)
+		}
+	}
+	if !nested {
+		t.Errorf("Decode() never produced a '*' within the operand of a '+'")
+	}
+}
+
+// newPredicateGrammar returns the parser and the lexer of the grammar:
+//
+//	s : {p0}? A | B {a0} ;
+func newPredicateGrammar() (*testParser, *testLexer) {
+	const A, B = 1, 2
+
+	lexerBuilder := &atnBuilder{grammarType: antlr.ATNTypeLexer, maxTokenType: B}
+	for i, r := range []rune{'a', 'b'} {
+		start, stop := lexerBuilder.rule(i + 1)
+		lexerBuilder.alt(i, start, stop, atom(int(r)))
+	}
+	lexer := &testLexer{antlr.NewBaseLexer(nil), lexerBuilder.build()}
+	lexer.RuleNames = []string{"A", "B"}
+
+	b := &atnBuilder{grammarType: antlr.ATNTypeParser, maxTokenType: B}
+	start, stop := b.rule(0)
+	b.alt(0, start, stop, predicate(0, 0), atom(A))
+	b.alt(0, start, stop, atom(B), action(0, 0))
+	parser := &testParser{antlr.NewBaseParser(nil), b.build()}
+	parser.RuleNames = []string{"s"}
+	return parser, lexer
+}
+
+func TestATNWalker_SetPredicate(t *testing.T) {
+	parser, lexer := newPredicateGrammar()
+	tests := []struct {
+		name      string
+		predicate Predicate
+		want      []string
+	}{
+		{"unregistered", nil, []string{"a", "b"}},
+		{"false", func(TreeNode) bool { return false }, []string{"b"}},
+		{"true", func(TreeNode) bool { return true }, []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actions := 0
+			outputs := decodeRandom(t, func() *ATNWalker {
+				walker := NewATNWalker(parser, lexer)
+				walker.SetPredicate(0, 0, false, tt.predicate)
+				walker.SetAction(0, 0, false, func(node TreeNode) {
+					if node.(*RuleNode).StartState.GetRuleIndex() == 0 {
+						actions++
+					}
+				})
+				return walker
+			}, 200)
+			if len(outputs) != len(tt.want) {
+				t.Errorf("Decode() produced %v, want %v", outputs, tt.want)
+			}
+			for _, want := range tt.want {
+				if _, ok := outputs[want]; !ok {
+					t.Errorf("Decode() never produced %q", want)
+				}
+			}
+			if actions == 0 {
+				t.Errorf("the action was never called")
+			}
+		})
+	}
+}
+
+func TestGuardModel(t *testing.T) {
+	parser, _ := newPredicateGrammar()
+	atn := parser.GetATN()
+	transitions := atn.GetRuleIndexToStartStateSlice()[0].GetTransitions()
+	guards := guardModels.get(atn)
+	if !guards.isGuarded(transitions[0]) {
+		t.Errorf("isGuarded() of the alternative {p0}? A = false, want true")
+	}
+	if guards.isGuarded(transitions[1]) {
+		t.Errorf("isGuarded() of the alternative B {a0} = true, want false")
+	}
+	if guardModels.get(atn) != guards {
+		t.Errorf("guardModels.get() computed the model again")
+	}
+
+	parser, _ = newTestGrammar()
+	for _, state := range parser.GetATN().GetStates() {
+		for _, transition := range state.GetTransitions() {
+			if guardModels.get(parser.GetATN()).isGuarded(transition) {
+				t.Errorf("isGuarded() of a transition of state %d = true in a grammar without predicates",
+					state.GetStateNumber())
+			}
+		}
+	}
+}
+
+func TestATNWalker_ViableChoice(t *testing.T) {
+	parser, lexer := newPredicateGrammar()
+	walker := NewATNWalker(parser, lexer)
+	walker.SetPredicate(0, 0, false, func(TreeNode) bool { return false })
+	atn := parser.GetATN()
+	state := atn.GetRuleIndexToStartStateSlice()[0].GetStateNumber()
+	ends, guards := endModels.get(atn), guardModels.get(atn)
+	tests := []struct {
+		name   string
+		choice int
+		want   int
+		// whether the planned route is kept
+		planned bool
+	}{
+		{"viable", 1, 1, true},
+		{"predicate fails", 0, 1, false},
+		{"out of range", 5, 1, false},
+		{"negative", -1, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := NewRouter(0, state, -1, false, atn, nil, nil)
+			router.nextChoices.Push(0)
+			if choice := walker.viableChoice(&RuleNode{}, ends, guards, router, state, tt.choice); choice != tt.want {
+				t.Errorf("viableChoice(%d) = %d, want %d", tt.choice, choice, tt.want)
+			}
+			if router.hasPlannedRoute() != tt.planned {
+				t.Errorf("hasPlannedRoute() = %v, want %v", router.hasPlannedRoute(), tt.planned)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package atnwalk
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+// SeparatorPolicy decides what TreeToString writes between two tokens. Grammars usually skip whitespace and comments
+// in the lexer, hence, the parse trees and the outputs lack them, e.g., `SELECTaFROMb` instead of `SELECT a FROM b`.
+// The separators are not part of the tree, Encode ignores them since the lexer of the grammar skips them anyway.
+type SeparatorPolicy int
+
+const (
+	// NoSeparator concatenates the tokens
+	NoSeparator SeparatorPolicy = iota
+	// SpaceSeparator writes a single space between the tokens, the grammar must skip spaces
+	SpaceSeparator
+	// SkippedSeparator writes the random text of a lexer rule that is skipped or not on the default channel, e.g., a
+	// whitespace or comment rule, of the mode that the next token is lexed in
+	SkippedSeparator
+)
+
+const (
+	NoSeparatorName      = "none"
+	SpaceSeparatorName   = "space"
+	SkippedSeparatorName = "skipped"
+)
+
+// DefaultSeparatorPolicy is the policy of new walkers, e.g., to set the policy of the command line tools for all the
+// walkers that they create.
+var DefaultSeparatorPolicy = NoSeparator
+
+// ParseSeparatorPolicy returns the policy of one of the names above, an empty name is NoSeparator.
+func ParseSeparatorPolicy(name string) (SeparatorPolicy, error) {
+	switch name {
+	case NoSeparatorName, "":
+		return NoSeparator, nil
+	case SpaceSeparatorName:
+		return SpaceSeparator, nil
+	case SkippedSeparatorName:
+		return SkippedSeparator, nil
+	}
+	return NoSeparator, fmt.Errorf("unknown separator policy %q", name)
+}
+
+// SetSeparatorPolicy sets what TreeToString, and hence Decode, writes between two tokens, the default is
+// DefaultSeparatorPolicy.
+func (w *ATNWalker) SetSeparatorPolicy(policy SeparatorPolicy) {
+	w.separatorPolicy = policy
+}
+
+// maxSeparatorLength is the length after which a separator takes the shortest way to the end of its rule.
+const maxSeparatorLength = 8
+
+// maxSeparatorAttempts is the number of separators that are generated before the tokens are concatenated instead.
+const maxSeparatorAttempts = 8
+
+// separatorGap is a position in the text where a token starts that follows another token, the previous token starts
+// at the previous offset.
+type separatorGap struct {
+	offset       int
+	mode         int
+	previous     int
+	previousMode int
+}
+
+// separate inserts the separators of the policy into the text at the gaps. Skipped separators are random but they
+// depend on the text only, i.e., the same data always decodes to the same output.
+func (w *ATNWalker) separate(text string, gaps []separatorGap) string {
+	if len(gaps) == 0 {
+		return text
+	}
+	runes := []rune(text)
+	var prng *rand.Rand
+	if w.separatorPolicy == SkippedSeparator {
+		hash := fnv.New64a()
+		hash.Write([]byte(text))
+		prng = rand.New(rand.NewSource(int64(hash.Sum64())))
+	}
+	result := make([]rune, 0, len(runes)+2*len(gaps))
+	begin := 0
+	for i, gap := range gaps {
+		result = append(result, runes[begin:gap.offset]...)
+		begin = gap.offset
+		end := len(runes)
+		if i+1 < len(gaps) {
+			end = gaps[i+1].offset
+		}
+		switch w.separatorPolicy {
+		case SpaceSeparator:
+			result = append(result, ' ')
+		case SkippedSeparator:
+			separator := w.skippedSeparator(prng, gap, runes[gap.previous:gap.offset], runes[gap.offset:end])
+			result = append(result, separator...)
+		}
+	}
+	return string(append(result, runes[begin:]...))
+}
+
+// skippedSeparator returns the random text of a skipped rule of the mode that the lexer skips entirely between the
+// previous and the next token, e.g., a line comment is only used if the next token starts a new line. If no such text
+// is found, the separator is empty.
+func (w *ATNWalker) skippedSeparator(prng *rand.Rand, gap separatorGap, previous, next []rune) []rune {
+	model := w.lexerModel()
+	mode := gap.mode
+	if mode < 0 || mode >= len(model.modes) || gap.previousMode < 0 || gap.previousMode >= len(model.modes) {
+		return nil
+	}
+	var skipped []int
+	for _, ruleIndex := range model.modes[mode] {
+		if rule := model.rules[ruleIndex]; rule.skip || rule.channel != antlr.TokenDefaultChannel {
+			skipped = append(skipped, ruleIndex)
+		}
+	}
+	if len(skipped) == 0 {
+		return nil
+	}
+	atn := w.Lexer.GetATN()
+	for attempt := 0; attempt < maxSeparatorAttempts; attempt++ {
+		ruleIndex := skipped[prng.Intn(len(skipped))]
+		separator := w.generateText(prng, atn.GetRuleIndexToStartStateSlice()[ruleIndex])
+		if len(separator) > 0 && w.lexesAlone(previous, separator, next, model.modes[gap.previousMode],
+			model.modes[mode]) {
+			return separator
+		}
+	}
+	return nil
+}
+
+// lexesAlone reports whether the lexer matches the separator on its own: the longest match of the rules of the previous
+// token's mode at the start of the previous token followed by the separator is still the previous token, e.g., an
+// operator '-' does not turn into a comment '--', and the longest match of the rules at the start of the separator
+// followed by the next token is the separator.
+func (w *ATNWalker) lexesAlone(previous, separator, next []rune, previousRules, rules []int) bool {
+	return w.longestMatch(append(append([]rune{}, previous...), separator...), previousRules) == len(previous) &&
+		w.longestMatch(append(append([]rune{}, separator...), next...), rules) == len(separator)
+}
+
+// longestMatch returns the length of the longest match of the rules at the start of the text.
+func (w *ATNWalker) longestMatch(text []rune, ruleIndices []int) int {
+	m := newLexerMatcher(text)
+	longest := 0
+	for _, ruleIndex := range ruleIndices {
+		ends, _ := m.reach(w.Lexer.GetATN().GetRuleIndexToStartStateSlice()[ruleIndex], 0, 0)
+		if len(ends) > 0 && ends[len(ends)-1] > longest {
+			longest = ends[len(ends)-1]
+		}
+	}
+	return longest
+}
+
+// generateText walks the lexer rule randomly and returns the text, it takes the shortest derivation once the text is
+// longer than maxSeparatorLength. Predicates always hold and actions are ignored.
+func (w *ATNWalker) generateText(prng *rand.Rand, startState antlr.ATNState) []rune {
+	atn := startState.GetATN()
+	costs := derivationCosts.get(atn)
+
+	var text []rune
+	follow := &Stack[antlr.ATNState]{}
+	state := startState
+	for steps := 0; ; steps++ {
+		if state.GetStateType() == antlr.ATNStateRuleStop {
+			if follow.IsEmpty() {
+				return text
+			}
+			state = follow.Pop()
+			continue
+		}
+		transitions := state.GetTransitions()
+		choice := 0
+		if len(text) < maxSeparatorLength && steps < 4*maxSeparatorLength {
+			choice = prng.Intn(len(transitions))
+		} else {
+			best := derivationCost{}
+			found := false
+			for i, transition := range transitions {
+				cost, ok := transitionCost(atn, costs, transition)
+				if ok && (!found || cost.symbols < best.symbols ||
+					cost.symbols == best.symbols && cost.hops < best.hops) {
+					best, choice, found = cost, i, true
+				}
+			}
+		}
+
+		transition := transitions[choice]
+		switch t := transition.(type) {
+		case *antlr.RuleTransition:
+			follow.Push(t.GetFollowState())
+		case *antlr.AtomTransition:
+			// EOF matches nothing
+			if t.GetLabelValue() != antlr.TokenEOF {
+				text = append(text, rune(t.GetLabelValue()))
+			}
+		// a NotSetTransition is also a SetTransition, it prefers the characters of the alphabet that it matches
+		case *antlr.NotSetTransition:
+			text = append(text, w.notSetRune(prng, t.GetLabel()))
+		case *antlr.SetTransition:
+			text = append(text, rune(t.GetLabel().Get(prng.Intn(t.GetLabel().Length()))))
+		case *antlr.RangeTransition:
+			text = append(text, rune(t.GetLabel().Get(prng.Intn(t.GetLabel().Length()))))
+		case *antlr.WildcardTransition:
+			runes := w.wildcardAlphabet()
+			text = append(text, rune(runes.Get(prng.Intn(runes.Length()))))
+		}
+		state = transition.(antlr.AnyTransition).GetTarget()
+	}
+}
+
+// notSetRune returns a random character of the alphabet that is not in the set, or any character that is not in the
+// set if the alphabet's characters are rarely outside the set.
+func (w *ATNWalker) notSetRune(prng *rand.Rand, set *antlr.IntervalSet) rune {
+	alphabet := w.wildcardAlphabet()
+	for attempt := 0; attempt < 4*maxSeparatorLength; attempt++ {
+		if r := alphabet.Get(prng.Intn(alphabet.Length())); !set.Contains(r) {
+			return rune(r)
+		}
+	}
+	runes := set.Complement()
+	return rune(runes.Get(prng.Intn(runes.Length())))
+}
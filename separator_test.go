@@ -0,0 +1,203 @@
+package atnwalk
+
+import (
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+func TestParseSeparatorPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    SeparatorPolicy
+		wantErr bool
+	}{
+		{"", NoSeparator, false},
+		{"none", NoSeparator, false},
+		{"space", SpaceSeparator, false},
+		{"skipped", SkippedSeparator, false},
+		{"tab", NoSeparator, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSeparatorPolicy(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSeparatorPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSeparatorPolicy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestATNWalker_SeparatorPolicy(t *testing.T) {
+	parser, lexer := newModeGrammar()
+	decode := func(policy SeparatorPolicy, data []byte) string {
+		walker := NewATNWalker(parser, lexer)
+		walker.SetRoutingStrategy(&RandomStrategy{})
+		walker.SetSeparatorPolicy(policy)
+		return walker.Decode(data, nil)
+	}
+	// spaces are skipped in the default mode and comments are hidden in the tag mode, strings are single tokens
+	const item = `([a-c]|"[a-c]*"|<#?[a-c]#?>)`
+	language := regexp.MustCompile(`^` + item + `( ?` + item + `)*$`)
+
+	prng := rand.New(rand.NewSource(1))
+	spaces, comments := false, false
+	for i := 0; i < 500; i++ {
+		data := make([]byte, 1+prng.Intn(8))
+		prng.Read(data)
+		plain := decode(NoSeparator, data)
+
+		spaced := decode(SpaceSeparator, data)
+		if strings.ReplaceAll(spaced, " ", "") != plain || strings.Contains(spaced, "  ") {
+			t.Errorf("Decode(%v) = %q with spaces, %q without", data, spaced, plain)
+		}
+
+		skipped := decode(SkippedSeparator, data)
+		if strings.NewReplacer(" ", "", "#", "").Replace(skipped) != plain || !language.MatchString(skipped) {
+			t.Errorf("Decode(%v) = %q with skipped separators, %q without", data, skipped, plain)
+		}
+		if again := decode(SkippedSeparator, data); again != skipped {
+			t.Errorf("Decode(%v) = %q and then %q", data, skipped, again)
+		}
+		spaces = spaces || strings.Contains(skipped, " ")
+		comments = comments || strings.Contains(skipped, "#")
+	}
+	if !spaces || !comments {
+		t.Errorf("Decode() never produced a skipped space (%v) or a hidden comment (%v)", spaces, comments)
+	}
+}
+
+// newLineCommentGrammar returns the parser and the lexer of the grammar:
+//
+//	words : WORD | WORD words ;
+//	WORD : [a-c] ;
+//	COMMENT : '#' ~'\n'* -> skip ;
+//	NL : '\n' -> skip ;
+func newLineCommentGrammar() (*testParser, *testLexer) {
+	const WORD, COMMENT, NL = 1, 2, 3
+
+	b := &atnBuilder{grammarType: antlr.ATNTypeLexer, maxTokenType: NL}
+	var starts, stops [3]int
+	for i := range starts {
+		starts[i], stops[i] = b.rule(i + 1)
+	}
+	b.alt(0, starts[0], stops[0], charRange('a', 'c'))
+	loop := b.state(antlr.ATNStateBasic, 1)
+	b.alt(1, starts[1], loop, atom('#'))
+	b.edge(loop, loop, step{antlr.TransitionNOTSET, b.set('\n'), 0, 0})
+	b.alt(1, loop, stops[1], b.command(1, antlr.LexerActionTypeSkip, 0))
+	b.alt(2, starts[2], stops[2], atom('\n'), b.command(2, antlr.LexerActionTypeSkip, 0))
+	// the loop uses basic states instead of the loop states of the ANTLR tool
+	options := antlr.NewATNDeserializationOptions(nil)
+	options.SetVerifyATN(false)
+	lexer := &testLexer{antlr.NewBaseLexer(nil), b.buildWithOptions(options)}
+	lexer.RuleNames = []string{"WORD", "COMMENT", "NL"}
+
+	parserBuilder := &atnBuilder{grammarType: antlr.ATNTypeParser, maxTokenType: NL}
+	start, stop := parserBuilder.rule(0)
+	parserBuilder.alt(0, start, stop, atom(WORD))
+	parserBuilder.alt(0, start, stop, atom(WORD), ruleRef(start, 0))
+	parser := &testParser{antlr.NewBaseParser(nil), parserBuilder.build()}
+	parser.RuleNames = []string{"words"}
+	return parser, lexer
+}
+
+func TestATNWalker_SkippedSeparatorLexesAlone(t *testing.T) {
+	parser, lexer := newLineCommentGrammar()
+	outputs := decodeRandom(t, func() *ATNWalker {
+		walker := NewATNWalker(parser, lexer)
+		walker.SetRoutingStrategy(&RandomStrategy{})
+		walker.SetSeparatorPolicy(SkippedSeparator)
+		return walker
+	}, 300)
+	// a comment would swallow the next word, hence, only line breaks separate the words
+	language := regexp.MustCompile(`^[a-c](\n[a-c])*$`)
+	separated := false
+	for output := range outputs {
+		if !language.MatchString(output) {
+			t.Errorf("Decode() = %q, want words separated by line breaks", output)
+		}
+		separated = separated || strings.Contains(output, "\n")
+	}
+	if !separated {
+		t.Errorf("Decode() never separated two words")
+	}
+}
+
+// newOperatorCommentGrammar returns the parser and the lexer of the grammar:
+//
+//	items : WORD | WORD MINUS items ;
+//	WORD : [a-c] ;
+//	MINUS : '-' ;
+//	COMMENT : '--' ~'\n'* '\n' -> skip ;
+func newOperatorCommentGrammar() (*testParser, *testLexer) {
+	const WORD, MINUS, COMMENT = 1, 2, 3
+
+	b := &atnBuilder{grammarType: antlr.ATNTypeLexer, maxTokenType: COMMENT}
+	var starts, stops [3]int
+	for i := range starts {
+		starts[i], stops[i] = b.rule(i + 1)
+	}
+	b.alt(0, starts[0], stops[0], charRange('a', 'c'))
+	b.alt(1, starts[1], stops[1], atom('-'))
+	loop := b.state(antlr.ATNStateBasic, 2)
+	b.alt(2, starts[2], loop, atom('-'), atom('-'))
+	b.edge(loop, loop, step{antlr.TransitionNOTSET, b.set('\n'), 0, 0})
+	b.alt(2, loop, stops[2], atom('\n'), b.command(2, antlr.LexerActionTypeSkip, 0))
+	// the loop uses basic states instead of the loop states of the ANTLR tool
+	options := antlr.NewATNDeserializationOptions(nil)
+	options.SetVerifyATN(false)
+	lexer := &testLexer{antlr.NewBaseLexer(nil), b.buildWithOptions(options)}
+	lexer.RuleNames = []string{"WORD", "MINUS", "COMMENT"}
+
+	parserBuilder := &atnBuilder{grammarType: antlr.ATNTypeParser, maxTokenType: COMMENT}
+	start, stop := parserBuilder.rule(0)
+	parserBuilder.alt(0, start, stop, atom(WORD))
+	parserBuilder.alt(0, start, stop, atom(WORD), atom(MINUS), ruleRef(start, 0))
+	parser := &testParser{antlr.NewBaseParser(nil), parserBuilder.build()}
+	parser.RuleNames = []string{"items"}
+	return parser, lexer
+}
+
+func TestATNWalker_SkippedSeparatorKeepsPreviousToken(t *testing.T) {
+	parser, lexer := newOperatorCommentGrammar()
+	// lex drops the comments like the lexer of the grammar, i.e., the longest match wins
+	lex := func(text string) string {
+		var tokens strings.Builder
+		for len(text) > 0 {
+			if end := strings.IndexByte(text, '\n'); strings.HasPrefix(text, "--") && end >= 0 {
+				text = text[end+1:]
+				continue
+			}
+			tokens.WriteByte(text[0])
+			text = text[1:]
+		}
+		return tokens.String()
+	}
+	prng := rand.New(rand.NewSource(1))
+	commented := false
+	for i := 0; i < 300; i++ {
+		data := make([]byte, 1+prng.Intn(8))
+		prng.Read(data)
+		walker := NewATNWalker(parser, lexer)
+		walker.SetRoutingStrategy(&RandomStrategy{})
+		plain := walker.Decode(data, nil)
+		walker = NewATNWalker(parser, lexer)
+		walker.SetRoutingStrategy(&RandomStrategy{})
+		walker.SetSeparatorPolicy(SkippedSeparator)
+		separated := walker.Decode(data, nil)
+		if lex(separated) != plain {
+			t.Errorf("Decode(%v) = %q lexes to %q, want %q", data, separated, lex(separated), plain)
+		}
+		commented = commented || separated != plain
+	}
+	if !commented {
+		t.Errorf("Decode() never separated two tokens with a comment")
+	}
+}
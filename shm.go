@@ -0,0 +1,354 @@
+//go:build linux
+
+package atnwalk
+
+import (
+	"errors"
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+/*
+	The shared memory file consists of a header followed by slots, each slot carries one request at a time:
+
+	header (shmHeaderSize bytes): magic, number of slots, capacity of the data of each slot
+	slot (shmSlotHeaderSize + capacity bytes): state, client PID, request and response fields, data
+
+	A client claims a free slot, writes the request into it, and rings the doorbell, i.e., it sets the state to
+	shmRequest and wakes the futex on the state. The server picks the request up (shmProcessing), writes the response
+	into the same slot, sets the state to shmResponse and wakes the client. The client copies the response and frees the
+	slot. A client that gives up waiting frees a request that was not picked up yet, otherwise the server frees the slot.
+	The request data (data1 followed by data2) and the response data (decoded followed by encoded) share the data of
+	the slot, therefore, the capacity limits both.
+*/
+
+const (
+	shmMagic          uint32 = 0x61746e77
+	shmHeaderSize            = 64
+	shmSlotHeaderSize        = 64
+
+	// futexes are only available on Linux (see shm_other.go)
+	sharedMemorySupported = true
+)
+
+// states of a slot
+const (
+	shmFree uint32 = iota
+	shmClaimed
+	shmRequest
+	shmProcessing
+	shmResponse
+	// the client gave up waiting for the response, the server frees the slot once it is done
+	shmAbandoned
+)
+
+const (
+	futexWait = 0
+	futexWake = 1
+)
+
+type shmHeader struct {
+	Magic    uint32
+	Slots    uint32
+	Capacity uint32
+}
+
+type shmSlotHeader struct {
+	State         uint32
+	Pid           uint32
+	Wanted        uint32
+	Status        uint32
+	Len1          uint32
+	Len2          uint32
+	LenDecoded    uint32
+	LenEncoded    uint32
+	SeedCrossover uint64
+	SeedMutation  uint64
+}
+
+// futexWaitFor blocks while the value at the address equals val, at most for the timeout.
+func futexWaitFor(addr *uint32, val uint32, timeout time.Duration) {
+	ts := syscall.NsecToTimespec(int64(timeout))
+	syscall.Syscall6(syscall.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), futexWait, uintptr(val),
+		uintptr(unsafe.Pointer(&ts)), 0, 0)
+}
+
+func futexWakeAll(addr *uint32) {
+	syscall.Syscall6(syscall.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), futexWake, uintptr(^uint32(0)>>1), 0, 0, 0)
+}
+
+// shmSlot returns the header and the data of the i-th slot of the mapped shared memory.
+func shmSlot(mem []byte, capacity, i int) (*shmSlotHeader, []byte) {
+	offset := shmHeaderSize + i*(shmSlotHeaderSize+capacity)
+	return (*shmSlotHeader)(unsafe.Pointer(&mem[offset])),
+		mem[offset+shmSlotHeaderSize : offset+shmSlotHeaderSize+capacity]
+}
+
+// SharedMemoryServer serves requests through a shared memory file, with the same semantics as HandleRequest.
+type SharedMemoryServer struct {
+	path     string
+	file     *os.File
+	mem      []byte
+	slots    int
+	capacity int
+	stopping chan struct{}
+	serving  sync.WaitGroup
+}
+
+// NewSharedMemoryServer creates the shared memory file with the number of slots, i.e., requests served concurrently,
+// and the capacity of the data of each slot. The server holds a lock of the file as long as it serves requests.
+func NewSharedMemoryServer(path string, slots, capacity int) (*SharedMemoryServer, error) {
+	// round up the capacity so that all slot headers are aligned
+	capacity = (capacity + 63) &^ 63
+
+	// clients that still map a previous file of a dead server must not see the new file
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	size := shmHeaderSize + slots*(shmSlotHeaderSize+capacity)
+	if err = file.Truncate(int64(size)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, err
+	}
+	mem, err := syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	header := (*shmHeader)(unsafe.Pointer(&mem[0]))
+	header.Slots = uint32(slots)
+	header.Capacity = uint32(capacity)
+	// the magic is written last, clients do not use the file before
+	atomic.StoreUint32(&header.Magic, shmMagic)
+	return &SharedMemoryServer{path: path, file: file, mem: mem, slots: slots, capacity: capacity,
+		stopping: make(chan struct{})}, nil
+}
+
+// Serve handles the requests of all slots until Close is called.
+func (s *SharedMemoryServer) Serve(timeout int, parser_ antlr.Parser, lexer antlr.Lexer, startRule string,
+	strategy RoutingStrategy, stats *ServerStats) {
+	for i := 0; i < s.slots; i++ {
+		s.serving.Add(1)
+		go func(i int) {
+			defer s.serving.Done()
+			s.serveSlot(i, timeout, parser_, lexer, startRule, strategy, stats)
+		}(i)
+	}
+	s.serving.Wait()
+}
+
+func (s *SharedMemoryServer) serveSlot(i int, timeout int, parser_ antlr.Parser, lexer antlr.Lexer, startRule string,
+	strategy RoutingStrategy, stats *ServerStats) {
+	slot, data := shmSlot(s.mem, s.capacity, i)
+	for {
+		select {
+		case <-s.stopping:
+			return
+		default:
+		}
+
+		// wait for the doorbell, wake up regularly to see whether the server is stopping
+		state := atomic.LoadUint32(&slot.State)
+		if state != shmRequest {
+			futexWaitFor(&slot.State, state, 100*time.Millisecond)
+			continue
+		}
+		// the client may have taken the request back in the meantime
+		if !atomic.CompareAndSwapUint32(&slot.State, shmRequest, shmProcessing) {
+			continue
+		}
+
+		start := time.Now()
+		wanted := byte(slot.Wanted)
+		status, decoded, encoded := StatusBadRequest, []byte(nil), []byte(nil)
+		var walker *ATNWalker
+		len1, len2 := int(slot.Len1), int(slot.Len2)
+		if isValidRequest(wanted) && len1+len2 <= s.capacity {
+			// copy the request data because the response overwrites it
+			request := append([]byte{}, data[:len1+len2]...)
+			status, decoded, encoded, walker = processRequest(wanted, request[:len1], request[len1:],
+				slot.SeedCrossover, slot.SeedMutation, timeout, parser_, lexer, startRule, strategy)
+		}
+		if status == StatusOK && len(decoded)+len(encoded) > s.capacity {
+			// the response does not fit into the slot
+			status = StatusInternalError
+		}
+		if status == StatusOK {
+			copy(data, decoded)
+			copy(data[len(decoded):], encoded)
+			slot.LenDecoded = uint32(len(decoded))
+			slot.LenEncoded = uint32(len(encoded))
+		}
+		slot.Status = uint32(status)
+		if stats != nil {
+			recordRequest(stats, wanted, status, start, walker, len(decoded), len(encoded))
+		}
+
+		// the client may have given up in the meantime, then the slot is freed
+		if !atomic.CompareAndSwapUint32(&slot.State, shmProcessing, shmResponse) {
+			freeSlot(slot)
+		}
+		futexWakeAll(&slot.State)
+	}
+}
+
+// Close stops serving once the in-flight requests are handled, then it removes the shared memory file.
+func (s *SharedMemoryServer) Close() error {
+	close(s.stopping)
+	s.serving.Wait()
+	os.Remove(s.path)
+	err := syscall.Munmap(s.mem)
+	s.file.Close()
+	return err
+}
+
+// openSharedMemory maps the shared memory file of a running server.
+func openSharedMemory(path string) (mem []byte, slots, capacity int, err error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer file.Close()
+
+	// the server holds the lock as long as it serves requests
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_SH|syscall.LOCK_NB); err == nil {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		return nil, 0, 0, errors.New("no server serves the shared memory file " + path)
+	} else if !errors.Is(err, syscall.EWOULDBLOCK) {
+		return nil, 0, 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if info.Size() < shmHeaderSize {
+		return nil, 0, 0, errors.New("the shared memory file is not initialized yet")
+	}
+	mem, err = syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ|syscall.PROT_WRITE,
+		syscall.MAP_SHARED)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	header := (*shmHeader)(unsafe.Pointer(&mem[0]))
+	slots, capacity = int(header.Slots), int(header.Capacity)
+	if atomic.LoadUint32(&header.Magic) != shmMagic ||
+		int64(shmHeaderSize+slots*(shmSlotHeaderSize+capacity)) != info.Size() {
+		syscall.Munmap(mem)
+		return nil, 0, 0, errors.New("the shared memory file is not initialized yet")
+	}
+	return mem, slots, capacity, nil
+}
+
+// claimSlot claims a free slot or a slot of a dead client. Freed slots have no PID, hence, a slot that was just
+// claimed but has no PID yet is never mistaken for a slot of a dead client.
+func claimSlot(mem []byte, slots, capacity int, deadline time.Time) (*shmSlotHeader, []byte, bool) {
+	pid := uint32(os.Getpid())
+	for time.Now().Before(deadline) {
+		for i := 0; i < slots; i++ {
+			slot, data := shmSlot(mem, capacity, i)
+			state := atomic.LoadUint32(&slot.State)
+			if state == shmFree && atomic.CompareAndSwapUint32(&slot.State, shmFree, shmClaimed) {
+				atomic.StoreUint32(&slot.Pid, pid)
+				return slot, data, true
+			}
+			if state != shmClaimed && state != shmResponse {
+				continue
+			}
+			owner := atomic.LoadUint32(&slot.Pid)
+			if owner != 0 && errors.Is(syscall.Kill(int(owner), 0), syscall.ESRCH) &&
+				atomic.CompareAndSwapUint32(&slot.Pid, owner, pid) {
+				atomic.StoreUint32(&slot.State, shmClaimed)
+				return slot, data, true
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return nil, nil, false
+}
+
+// freeSlot releases the slot, the PID is cleared first (see claimSlot).
+func freeSlot(slot *shmSlotHeader) {
+	atomic.StoreUint32(&slot.Pid, 0)
+	atomic.StoreUint32(&slot.State, shmFree)
+}
+
+// SendSharedMemoryRequest is the counterpart of SendRequest for the shared memory transport, it returns false if
+// the server does not serve the shared memory file or no slot became free in time, i.e., the request can be retried.
+func SendSharedMemoryRequest(path string, timeout int, data1, data2 []byte, wanted byte, seedCrossover,
+	seedMutation uint64, encoded, decoded *[]byte) (byte, bool) {
+	deadline := time.Now().Add(time.Duration(timeout) * time.Millisecond)
+	mem, slots, capacity, err := openSharedMemory(path)
+	if err != nil {
+		return StatusBrokenConnection, false
+	}
+	defer syscall.Munmap(mem)
+
+	if len(data1)+len(data2) > capacity {
+		return StatusBadRequest, true
+	}
+	slot, data, ok := claimSlot(mem, slots, capacity, deadline)
+	if !ok {
+		return StatusBrokenConnection, false
+	}
+
+	// write the request and ring the doorbell
+	copy(data, data1)
+	copy(data[len(data1):], data2)
+	slot.Wanted = uint32(wanted)
+	slot.Len1 = uint32(len(data1))
+	slot.Len2 = uint32(len(data2))
+	slot.SeedCrossover = seedCrossover
+	slot.SeedMutation = seedMutation
+	atomic.StoreUint32(&slot.State, shmRequest)
+	futexWakeAll(&slot.State)
+
+	// wait for the response, give up after the timeout of the server and the grace period
+	deadline = time.Now().Add(time.Duration(timeout)*time.Millisecond + ResponseGrace)
+	for {
+		state := atomic.LoadUint32(&slot.State)
+		if state != shmRequest && state != shmProcessing {
+			break
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			// take the request back if the server did not pick it up yet, otherwise the server frees the slot
+			if atomic.CompareAndSwapUint32(&slot.State, shmRequest, shmClaimed) {
+				freeSlot(slot)
+				return StatusTimeout, true
+			}
+			if atomic.CompareAndSwapUint32(&slot.State, shmProcessing, shmAbandoned) {
+				return StatusTimeout, true
+			}
+			// the server responded in the meantime
+			continue
+		}
+		futexWaitFor(&slot.State, state, remaining)
+	}
+
+	status := byte(slot.Status)
+	if status == StatusOK {
+		lenDecoded, lenEncoded := int(slot.LenDecoded), int(slot.LenEncoded)
+		if wanted&DecodeBit > 0 {
+			*decoded = append([]byte{}, data[:lenDecoded]...)
+		}
+		if wanted&(CrossoverBit|MutateBit|EncodeBit) > 0 {
+			*encoded = append([]byte{}, data[lenDecoded:lenDecoded+lenEncoded]...)
+		}
+	}
+	freeSlot(slot)
+	return status, true
+}
@@ -0,0 +1,40 @@
+//go:build !linux
+
+package atnwalk
+
+import (
+	"errors"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+// the shared memory transport waits on futexes which are only available on Linux, the configuration rejects it
+// elsewhere (see checkTransport)
+const sharedMemorySupported = false
+
+var errSharedMemoryUnsupported = errors.New("unsupported transport: the shared memory transport requires Linux")
+
+// SharedMemoryServer is not supported on this platform, use the socket transport.
+type SharedMemoryServer struct{}
+
+// NewSharedMemoryServer fails because the shared memory transport is not supported on this platform.
+func NewSharedMemoryServer(path string, slots, capacity int) (*SharedMemoryServer, error) {
+	return nil, errSharedMemoryUnsupported
+}
+
+// Serve returns immediately because the shared memory transport is not supported on this platform.
+func (s *SharedMemoryServer) Serve(timeout int, parser_ antlr.Parser, lexer antlr.Lexer, startRule string,
+	strategy RoutingStrategy, stats *ServerStats) {
+}
+
+// Close fails because the shared memory transport is not supported on this platform.
+func (s *SharedMemoryServer) Close() error {
+	return errSharedMemoryUnsupported
+}
+
+// SendSharedMemoryRequest always reports a broken connection because the shared memory transport is not supported on
+// this platform.
+func SendSharedMemoryRequest(path string, timeout int, data1, data2 []byte, wanted byte, seedCrossover,
+	seedMutation uint64, encoded, decoded *[]byte) (byte, bool) {
+	return StatusBrokenConnection, false
+}
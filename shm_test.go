@@ -0,0 +1,83 @@
+//go:build linux
+
+package atnwalk
+
+import (
+	"bytes"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestSharedMemory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "atnwalk.socket.shm")
+	encoded, decoded := &([]byte{}), &([]byte{})
+	if _, ok := SendSharedMemoryRequest(path, 100, []byte{1}, nil, MutateBit, 0, 1, encoded, decoded); ok {
+		t.Errorf("SendSharedMemoryRequest() without a server should fail")
+	}
+
+	server, err := NewSharedMemoryServer(path, 2, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := NewServerStats()
+	go server.Serve(500, nil, nil, "", nil, stats)
+
+	// mutations and crossovers do not need a parser and a lexer, clients share the slots concurrently
+	data1, data2 := []byte("some encoded data"), []byte("other encoded data")
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(seed uint64) {
+			defer wg.Done()
+			encoded, decoded := &([]byte{}), &([]byte{})
+			status, ok := SendSharedMemoryRequest(path, 1000, data1, data2, CrossoverBit|MutateBit, seed, seed+1,
+				encoded, decoded)
+			want := Mutate(Crossover(data1, data2, int64(seed)), int64(seed+1))
+			if !ok || status != StatusOK || !bytes.Equal(*encoded, want) {
+				t.Errorf("SendSharedMemoryRequest() = %v, %v, %v, want %v", status, ok, *encoded, want)
+			}
+		}(uint64(i))
+	}
+	wg.Wait()
+
+	if status, ok := SendSharedMemoryRequest(path, 1000, data1, nil, 0b10000000, 0, 0, encoded,
+		decoded); !ok || status != StatusBadRequest {
+		t.Errorf("SendSharedMemoryRequest() with unknown bits = %v, %v, want BAD_REQUEST", status, ok)
+	}
+	if status, ok := SendSharedMemoryRequest(path, 1000, make([]byte, 200), nil, MutateBit, 0, 0, encoded,
+		decoded); !ok || status != StatusBadRequest {
+		t.Errorf("SendSharedMemoryRequest() exceeding the capacity = %v, %v, want BAD_REQUEST", status, ok)
+	}
+
+	if err = server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := SendSharedMemoryRequest(path, 100, []byte{1}, nil, MutateBit, 0, 1, encoded, decoded); ok {
+		t.Errorf("SendSharedMemoryRequest() after closing the server should fail")
+	}
+	if stats.requests != 9 {
+		t.Errorf("the server recorded %d requests, want 9", stats.requests)
+	}
+}
+
+func TestSharedMemory_Timeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "atnwalk.socket.shm")
+	server, err := NewSharedMemoryServer(path, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	// nobody serves the only slot, the client takes back its request such that the next client can claim the slot
+	encoded, decoded := &([]byte{}), &([]byte{})
+	for i := 0; i < 2; i++ {
+		if status, ok := SendSharedMemoryRequest(path, 10, []byte{1}, nil, MutateBit, 0, 1, encoded,
+			decoded); !ok || status != StatusTimeout {
+			t.Errorf("SendSharedMemoryRequest() #%d = %v, %v, want TIMEOUT", i, StatusText(status), ok)
+		}
+	}
+	if slot, _ := shmSlot(server.mem, server.capacity, 0); slot.State != shmFree || slot.Pid != 0 {
+		t.Errorf("the slot was not freed, state %d, PID %d", slot.State, slot.Pid)
+	}
+}
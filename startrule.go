@@ -0,0 +1,109 @@
+package atnwalk
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+// StartRuleMagic prefixes the name of the start rule that encoded data records, the name ends with a zero byte. Random
+// data practically never starts with it, i.e., data without the record decodes as before (see SplitStartRule).
+const StartRuleMagic = "\x00atnwalk-start-rule:"
+
+// WithStartRule returns the data prefixed with the record of the start rule that the data was encoded with.
+func WithStartRule(data []byte, startRule string) []byte {
+	recorded := make([]byte, 0, len(StartRuleMagic)+len(startRule)+1+len(data))
+	recorded = append(recorded, StartRuleMagic...)
+	recorded = append(recorded, startRule...)
+	recorded = append(recorded, 0)
+	return append(recorded, data...)
+}
+
+// SplitStartRule returns the start rule that the data records and the data without the record, or an empty start rule
+// and the unchanged data if it records none.
+func SplitStartRule(data []byte) (string, []byte) {
+	if !bytes.HasPrefix(data, []byte(StartRuleMagic)) {
+		return "", data
+	}
+	end := bytes.IndexByte(data[len(StartRuleMagic):], 0)
+	if end < 1 {
+		return "", data
+	}
+	return string(data[len(StartRuleMagic) : len(StartRuleMagic)+end]), data[len(StartRuleMagic)+end+1:]
+}
+
+// StripStartRule returns the data without the record of the start rule (see WithStartRule), it fails if the data
+// records another start rule than the walker starts with, such data does not decode to the text it was encoded from.
+func (w *ATNWalker) StripStartRule(data []byte) ([]byte, error) {
+	recorded, rest := SplitStartRule(data)
+	if err := checkStartRule(recorded, w.Parser.GetRuleNames()[w.startRuleIndex]); err != nil {
+		return nil, err
+	}
+	return rest, nil
+}
+
+// checkStartRule fails if the data recorded another start rule than the start rule it is decoded with, data without a
+// record decodes with any start rule.
+func checkStartRule(recorded, startRule string) error {
+	if recorded != "" && recorded != startRule {
+		return fmt.Errorf("the data was encoded with the start rule %s but is decoded with %s", recorded, startRule)
+	}
+	return nil
+}
+
+// DetectStartRule parses the text with each candidate start rule in order, all rules of the grammar of the parser if
+// there are no candidates, and encodes the parse tree of the first rule that matches the whole text without syntax
+// errors: the parser consumed all tokens, the tree has no error nodes, and the children of each rule match the rule,
+// i.e., no rule failed without consuming tokens. A candidate that fails to parse is skipped like any other mismatch,
+// hence, the candidates must be rules of the parser. Lexer errors do not depend on the start rule and are not detected.
+func DetectStartRule(parser_ antlr.Parser, parse ParseFunc, text string, candidates []string) (string, []byte,
+	error) {
+	if len(candidates) == 0 {
+		candidates = parser_.GetRuleNames()
+	}
+	for _, candidate := range candidates {
+		if _, err := ruleIndex(parser_, candidate); err != nil {
+			return "", nil, err
+		}
+	}
+	for _, candidate := range candidates {
+		parser, lexer, tree, err := parse(text, candidate)
+		if err != nil {
+			continue
+		}
+		if encoded, ok := encodeWithoutErrors(parser, lexer, tree); ok {
+			return candidate, encoded, nil
+		}
+	}
+	return "", nil, fmt.Errorf("no start rule matches the whole text without syntax errors, tried: %s",
+		strings.Join(candidates, ", "))
+}
+
+// encodeWithoutErrors encodes the parse tree, false if the parser did not consume all tokens or if the tree contains
+// syntax errors.
+func encodeWithoutErrors(parser antlr.Parser, lexer antlr.Lexer, tree antlr.Tree) (encoded []byte, ok bool) {
+	if parser.GetTokenStream().LA(1) != antlr.TokenEOF || hasErrorNodes(tree) {
+		return nil, false
+	}
+	// the encoder panics if the children of a rule do not match the rule
+	defer func() {
+		if r := recover(); r != nil {
+			encoded, ok = nil, false
+		}
+	}()
+	return NewATNWalker(parser, lexer).Encode(tree), true
+}
+
+func hasErrorNodes(tree antlr.Tree) bool {
+	if _, ok := tree.(antlr.ErrorNode); ok {
+		return true
+	}
+	for _, child := range tree.GetChildren() {
+		if hasErrorNodes(child) {
+			return true
+		}
+	}
+	return false
+}
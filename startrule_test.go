@@ -0,0 +1,165 @@
+package atnwalk
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+// listTokenSource returns the tokens of a list and EOF after them
+type listTokenSource struct {
+	*antlr.BaseLexer
+	tokens []antlr.Token
+}
+
+func (s *listTokenSource) NextToken() antlr.Token {
+	if len(s.tokens) == 0 {
+		return antlr.NewCommonToken(&antlr.TokenSourceCharStreamPair{}, antlr.TokenEOF, antlr.TokenDefaultChannel, -1,
+			-1)
+	}
+	token := s.tokens[0]
+	s.tokens = s.tokens[1:]
+	return token
+}
+
+// parseTestGrammar parses the text with the test grammar like a parser generated by ANTLR that recovers from errors,
+// each character is a token and a statement without an expression fails without consuming tokens
+func parseTestGrammar(text, startRule string) (antlr.Parser, antlr.Lexer, antlr.Tree, error) {
+	const stmt, expr = 0, 1
+	const SELECT, ATTACH, NAME, NUM = 1, 2, 3, 4
+	var b errorTree
+	var tokens []antlr.Token
+	for i, r := range text {
+		tokenType := map[rune]int{'s': SELECT, 'a': ATTACH, 'x': NAME, 'y': NAME, 'z': NAME}[r]
+		if r >= '0' && r <= '9' {
+			tokenType = NUM
+		}
+		tokens = append(tokens, b.newToken(tokenType, string(r), i))
+	}
+	parser, lexer := newTestGrammar()
+	stream := antlr.NewCommonTokenStream(&listTokenSource{antlr.NewBaseLexer(nil), tokens}, antlr.TokenDefaultChannel)
+	parser.SetTokenStream(stream)
+	index, err := ruleIndex(parser, startRule)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	token := func(i int) antlr.Tree {
+		stream.Consume()
+		return antlr.NewTerminalNodeImpl(tokens[i])
+	}
+	typeAt := func(i int) int {
+		if i < len(tokens) {
+			return tokens[i].GetTokenType()
+		}
+		return antlr.TokenEOF
+	}
+	isExpr := func(i int) bool { return typeAt(i) == NAME || typeAt(i) == NUM }
+
+	var tree antlr.Tree
+	switch {
+	case index == expr && isExpr(0):
+		tree = b.rule(expr, token(0))
+	case index == stmt && typeAt(0) == SELECT && isExpr(1):
+		tree = b.rule(stmt, token(0), b.rule(expr, token(1)))
+	case index == stmt && typeAt(0) == ATTACH && typeAt(1) == NAME:
+		tree = b.rule(stmt, token(0), token(1))
+	case index == stmt && typeAt(0) == SELECT:
+		tree = b.rule(stmt, token(0))
+	case typeAt(0) != antlr.TokenEOF:
+		stream.Consume()
+		tree = b.rule(index, antlr.NewErrorNodeImpl(tokens[0]))
+	default:
+		tree = b.rule(index)
+	}
+	return parser, lexer, tree, nil
+}
+
+func TestDetectStartRule(t *testing.T) {
+	tests := []struct {
+		text       string
+		candidates []string
+		want       string
+	}{
+		{"sx", nil, "stmt"},
+		{"ay", nil, "stmt"},
+		{"7", nil, "expr"},
+		{"7", []string{"stmt", "expr"}, "expr"},
+		{"sx", []string{"expr"}, ""},
+		// the statement consumes 's' but fails without an expression
+		{"s", nil, ""},
+		// the expression does not consume the whole text
+		{"x7", nil, ""},
+	}
+	parser, lexer := newTestGrammar()
+	for _, tt := range tests {
+		startRule, encoded, err := DetectStartRule(parser, parseTestGrammar, tt.text, tt.candidates)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("DetectStartRule(%q, %v) = %q, want an error", tt.text, tt.candidates, startRule)
+			}
+			continue
+		}
+		if err != nil || startRule != tt.want {
+			t.Errorf("DetectStartRule(%q, %v) = %q, %v, want %q", tt.text, tt.candidates, startRule, err, tt.want)
+			continue
+		}
+		walker := NewATNWalker(parser, lexer)
+		if err = walker.SetStartRule(startRule); err != nil {
+			t.Fatal(err)
+		}
+		if decoded := walker.Decode(encoded, nil); decoded != tt.text {
+			t.Errorf("Decode(DetectStartRule(%q)) = %q", tt.text, decoded)
+		}
+	}
+
+	for _, candidates := range [][]string{{"missing"}, {"stmt", "missing"}} {
+		if _, _, err := DetectStartRule(parser, parseTestGrammar, "sx", candidates); err == nil {
+			t.Errorf("DetectStartRule(%v) accepted an unknown start rule", candidates)
+		}
+	}
+
+	// a candidate that fails to parse does not match
+	failingExpr := func(text, startRule string) (antlr.Parser, antlr.Lexer, antlr.Tree, error) {
+		if startRule == "expr" {
+			return nil, nil, nil, errors.New("the parser has no method for the rule 'expr'")
+		}
+		return parseTestGrammar(text, startRule)
+	}
+	if startRule, _, err := DetectStartRule(parser, failingExpr, "sx", []string{"expr", "stmt"}); err != nil ||
+		startRule != "stmt" {
+		t.Errorf("DetectStartRule() = %q, %v, want %q", startRule, err, "stmt")
+	}
+	if _, _, err := DetectStartRule(parser, failingExpr, "7", []string{"expr"}); err == nil {
+		t.Errorf("DetectStartRule() matched a candidate that failed to parse")
+	}
+}
+
+func TestSplitStartRule(t *testing.T) {
+	data := []byte{0, 1, 2}
+	startRule, rest := SplitStartRule(WithStartRule(data, "expr"))
+	if startRule != "expr" || !bytes.Equal(rest, data) {
+		t.Errorf("SplitStartRule(WithStartRule()) = %q, %v", startRule, rest)
+	}
+	for _, data := range [][]byte{data, []byte(StartRuleMagic), []byte(StartRuleMagic + "\x00\x01")} {
+		if startRule, rest := SplitStartRule(data); startRule != "" || !bytes.Equal(rest, data) {
+			t.Errorf("SplitStartRule(%q) = %q, %v, want no start rule", data, startRule, rest)
+		}
+	}
+}
+
+func TestATNWalker_StripStartRule(t *testing.T) {
+	parser, lexer := newTestGrammar()
+	walker := NewATNWalker(parser, lexer)
+	data := []byte{0, 1, 2}
+	// the first rule is the start rule by default
+	for _, recorded := range [][]byte{data, WithStartRule(data, "stmt")} {
+		if stripped, err := walker.StripStartRule(recorded); err != nil || !bytes.Equal(stripped, data) {
+			t.Errorf("StripStartRule(%q) = %v, %v, want %v", recorded, stripped, err, data)
+		}
+	}
+	if _, err := walker.StripStartRule(WithStartRule(data, "expr")); err == nil {
+		t.Errorf("StripStartRule() accepted data that records another start rule")
+	}
+}
@@ -0,0 +1,183 @@
+package atnwalk
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// number of recent request latencies kept to estimate the 99th percentile
+const latencyWindow = 1024
+
+// ServerStats collects statistics about the requests served by a server, it is safe for concurrent use.
+type ServerStats struct {
+	mutex           sync.Mutex
+	started         time.Time
+	requests        uint64
+	operations      map[byte]uint64
+	statuses        map[byte]uint64
+	timeouts        uint64
+	latencySum      time.Duration
+	latencies       [latencyWindow]time.Duration
+	latencyCursor   int
+	decodedBytes    uint64
+	decodedCount    uint64
+	encodedBytes    uint64
+	encodedCount    uint64
+	decisions       uint64
+	routedDecisions uint64
+	routers         int
+	routeOptions    int
+	maxRouteOptions int
+}
+
+func NewServerStats() *ServerStats {
+	return &ServerStats{started: time.Now(), operations: map[byte]uint64{}, statuses: map[byte]uint64{}}
+}
+
+// RequestStats are the statistics of a single request that are added to the ServerStats.
+type RequestStats struct {
+	Wanted          byte
+	Status          byte
+	Latency         time.Duration
+	TimedOut        bool
+	DecodedBytes    int
+	EncodedBytes    int
+	Decisions       int
+	RoutedDecisions int
+	Routers         int
+	RouteOptions    int
+}
+
+func (s *ServerStats) Record(r *RequestStats) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests++
+	for _, bit := range []byte{CrossoverBit, MutateBit, DecodeBit, EncodeBit} {
+		if r.Wanted&bit > 0 {
+			s.operations[bit]++
+		}
+	}
+	s.statuses[r.Status]++
+	if r.TimedOut {
+		s.timeouts++
+	}
+	s.latencySum += r.Latency
+	s.latencies[s.latencyCursor%latencyWindow] = r.Latency
+	s.latencyCursor++
+	if r.Wanted&DecodeBit > 0 {
+		s.decodedBytes += uint64(r.DecodedBytes)
+		s.decodedCount++
+	}
+	if r.Wanted&(CrossoverBit|MutateBit|EncodeBit) > 0 {
+		s.encodedBytes += uint64(r.EncodedBytes)
+		s.encodedCount++
+	}
+	s.decisions += uint64(r.Decisions)
+	s.routedDecisions += uint64(r.RoutedDecisions)
+	if r.Wanted&(DecodeBit|EncodeBit) > 0 {
+		s.routers = r.Routers
+		s.routeOptions = r.RouteOptions
+		if r.RouteOptions > s.maxRouteOptions {
+			s.maxRouteOptions = r.RouteOptions
+		}
+	}
+}
+
+// latencyQuantile returns the quantile of the recent latencies, the caller must hold the mutex.
+func (s *ServerStats) latencyQuantile(q float64) time.Duration {
+	n := s.latencyCursor
+	if n > latencyWindow {
+		n = latencyWindow
+	}
+	if n == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, s.latencies[:n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(q*float64(n-1)+0.5)]
+}
+
+// WritePrometheus writes the statistics in the Prometheus text exposition format.
+func (s *ServerStats) WritePrometheus(writer io.Writer) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	w := bufio.NewWriter(writer)
+	fmt.Fprintf(w, "# HELP atnwalk_uptime_seconds Time since the server started.\n")
+	fmt.Fprintf(w, "# TYPE atnwalk_uptime_seconds gauge\n")
+	fmt.Fprintf(w, "atnwalk_uptime_seconds %g\n", time.Since(s.started).Seconds())
+	fmt.Fprintf(w, "# HELP atnwalk_requests_total Requests served.\n")
+	fmt.Fprintf(w, "# TYPE atnwalk_requests_total counter\n")
+	fmt.Fprintf(w, "atnwalk_requests_total %d\n", s.requests)
+	fmt.Fprintf(w, "# HELP atnwalk_operations_total Requests served per operation bit.\n")
+	fmt.Fprintf(w, "# TYPE atnwalk_operations_total counter\n")
+	for _, op := range []struct {
+		bit  byte
+		name string
+	}{{CrossoverBit, "crossover"}, {MutateBit, "mutate"}, {DecodeBit, "decode"}, {EncodeBit, "encode"}} {
+		fmt.Fprintf(w, "atnwalk_operations_total{operation=%q} %d\n", op.name, s.operations[op.bit])
+	}
+	fmt.Fprintf(w, "# HELP atnwalk_responses_total Requests served per response status.\n")
+	fmt.Fprintf(w, "# TYPE atnwalk_responses_total counter\n")
+	for _, status := range []byte{StatusOK, StatusTimeout, StatusBadRequest, StatusInternalError, StatusBrokenConnection} {
+		fmt.Fprintf(w, "atnwalk_responses_total{status=%q} %d\n", StatusText(status), s.statuses[status])
+	}
+	fmt.Fprintf(w, "# HELP atnwalk_timeouts_total Requests that exceeded the decoding deadline.\n")
+	fmt.Fprintf(w, "# TYPE atnwalk_timeouts_total counter\n")
+	fmt.Fprintf(w, "atnwalk_timeouts_total %d\n", s.timeouts)
+	fmt.Fprintf(w, "# HELP atnwalk_request_latency_seconds Request latency, the quantile covers the last %d requests.\n", latencyWindow)
+	fmt.Fprintf(w, "# TYPE atnwalk_request_latency_seconds summary\n")
+	fmt.Fprintf(w, "atnwalk_request_latency_seconds{quantile=\"0.99\"} %g\n", s.latencyQuantile(0.99).Seconds())
+	fmt.Fprintf(w, "atnwalk_request_latency_seconds_sum %g\n", s.latencySum.Seconds())
+	fmt.Fprintf(w, "atnwalk_request_latency_seconds_count %d\n", s.requests)
+	fmt.Fprintf(w, "# HELP atnwalk_output_bytes Size of the decoded and encoded outputs.\n")
+	fmt.Fprintf(w, "# TYPE atnwalk_output_bytes summary\n")
+	fmt.Fprintf(w, "atnwalk_output_bytes_sum{output=\"decoded\"} %d\n", s.decodedBytes)
+	fmt.Fprintf(w, "atnwalk_output_bytes_count{output=\"decoded\"} %d\n", s.decodedCount)
+	fmt.Fprintf(w, "atnwalk_output_bytes_sum{output=\"encoded\"} %d\n", s.encodedBytes)
+	fmt.Fprintf(w, "atnwalk_output_bytes_count{output=\"encoded\"} %d\n", s.encodedCount)
+	fmt.Fprintf(w, "# HELP atnwalk_decisions_total Choices made at decision states, routed ones were made by the PRNG.\n")
+	fmt.Fprintf(w, "# TYPE atnwalk_decisions_total counter\n")
+	fmt.Fprintf(w, "atnwalk_decisions_total{source=\"data\"} %d\n", s.decisions-s.routedDecisions)
+	fmt.Fprintf(w, "atnwalk_decisions_total{source=\"prng\"} %d\n", s.routedDecisions)
+	fmt.Fprintf(w, "# HELP atnwalk_prng_fallback_ratio Ratio of choices made by the PRNG because the data was exhausted.\n")
+	fmt.Fprintf(w, "# TYPE atnwalk_prng_fallback_ratio gauge\n")
+	ratio := 0.0
+	if s.decisions > 0 {
+		ratio = float64(s.routedDecisions) / float64(s.decisions)
+	}
+	fmt.Fprintf(w, "atnwalk_prng_fallback_ratio %g\n", ratio)
+	fmt.Fprintf(w, "# HELP atnwalk_routers Routers (rules) used by the last decoding request.\n")
+	fmt.Fprintf(w, "# TYPE atnwalk_routers gauge\n")
+	fmt.Fprintf(w, "atnwalk_routers %d\n", s.routers)
+	fmt.Fprintf(w, "# HELP atnwalk_route_options Learned decision states of all routers of the last decoding request.\n")
+	fmt.Fprintf(w, "# TYPE atnwalk_route_options gauge\n")
+	fmt.Fprintf(w, "atnwalk_route_options %d\n", s.routeOptions)
+	fmt.Fprintf(w, "atnwalk_route_options_max %d\n", s.maxRouteOptions)
+	return w.Flush()
+}
+
+// DumpPrometheus periodically writes the statistics to a file, the file is replaced atomically so that readers
+// (e.g., the node exporter's textfile collector) never observe partial writes.
+func (s *ServerStats) DumpPrometheus(path string, interval time.Duration) {
+	for range time.Tick(interval) {
+		tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+		if err != nil {
+			continue
+		}
+		err = s.WritePrometheus(tmp)
+		tmp.Close()
+		if err == nil {
+			err = os.Rename(tmp.Name(), path)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package atnwalk
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerStats_WritePrometheus(t *testing.T) {
+	stats := NewServerStats()
+	stats.Record(&RequestStats{Wanted: DecodeBit | EncodeBit, Latency: 10 * time.Millisecond, DecodedBytes: 100,
+		EncodedBytes: 20, Decisions: 8, RoutedDecisions: 2, Routers: 3, RouteOptions: 12})
+	stats.Record(&RequestStats{Wanted: MutateBit | DecodeBit, Status: StatusTimeout,
+		Latency: 30 * time.Millisecond, TimedOut: true,
+		Decisions: 2, RoutedDecisions: 2, Routers: 1, RouteOptions: 4})
+	stats.Record(&RequestStats{Wanted: CrossoverBit, Latency: 20 * time.Millisecond, EncodedBytes: 7})
+
+	buffer := &bytes.Buffer{}
+	if err := stats.WritePrometheus(buffer); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"atnwalk_requests_total 3\n",
+		"atnwalk_operations_total{operation=\"crossover\"} 1\n",
+		"atnwalk_operations_total{operation=\"mutate\"} 1\n",
+		"atnwalk_operations_total{operation=\"decode\"} 2\n",
+		"atnwalk_operations_total{operation=\"encode\"} 1\n",
+		"atnwalk_responses_total{status=\"OK\"} 2\n",
+		"atnwalk_responses_total{status=\"TIMEOUT\"} 1\n",
+		"atnwalk_timeouts_total 1\n",
+		"atnwalk_request_latency_seconds{quantile=\"0.99\"} 0.03\n",
+		"atnwalk_request_latency_seconds_sum 0.06\n",
+		"atnwalk_output_bytes_sum{output=\"decoded\"} 100\n",
+		"atnwalk_output_bytes_count{output=\"decoded\"} 2\n",
+		"atnwalk_output_bytes_sum{output=\"encoded\"} 27\n",
+		"atnwalk_output_bytes_count{output=\"encoded\"} 3\n",
+		"atnwalk_decisions_total{source=\"data\"} 6\n",
+		"atnwalk_decisions_total{source=\"prng\"} 4\n",
+		"atnwalk_prng_fallback_ratio 0.4\n",
+		"atnwalk_routers 1\n",
+		"atnwalk_route_options 4\n",
+		"atnwalk_route_options_max 12\n",
+	} {
+		if !strings.Contains(buffer.String(), want) {
+			t.Errorf("WritePrometheus() does not contain %q:\n%s", want, buffer.String())
+		}
+	}
+}
+
+func TestRecordRequest_TimedOut(t *testing.T) {
+	parser, lexer := newTestGrammar()
+	walker := NewATNWalker(parser, lexer)
+	walker.SetDeadline(time.Now().Add(-time.Second))
+	stats := NewServerStats()
+	// the deadline passed while the response was written, the request itself finished in time
+	recordRequest(stats, DecodeBit, StatusOK, time.Now(), walker, 1, 0)
+	if stats.timeouts != 0 {
+		t.Errorf("recordRequest() counted a request with %s as a timeout", StatusText(StatusOK))
+	}
+	recordRequest(stats, DecodeBit, StatusTimeout, time.Now(), walker, 0, 0)
+	if stats.timeouts != 1 {
+		t.Errorf("recordRequest() did not count a request with %s as a timeout", StatusText(StatusTimeout))
+	}
+}
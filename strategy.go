@@ -0,0 +1,280 @@
+package atnwalk
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+// RoutingStrategy decides which transition to follow at a decision state once the decoder ran out of data and
+// the PRNG drives the generation. A strategy may be shared between multiple walkers, e.g., by the server.
+type RoutingStrategy interface {
+	Route(router *Router, state int, rootPathRules map[int]struct{}) int
+}
+
+// ChoiceObserver is optionally implemented by a RoutingStrategy that wants to see every choice made at a decision
+// state, regardless of whether it was decoded from the data or routed.
+type ChoiceObserver interface {
+	ObserveChoice(router *Router, state, choice int)
+}
+
+// ChoiceFilter is optionally implemented by a RoutingStrategy that disables choices at decision states and tokens of
+// parser set transitions. Decoded choices that are disabled are mapped to the next allowed choice, i.e., the encoding
+// of the data does not change, and the Router only plans routes with allowed choices.
+type ChoiceFilter interface {
+	ChoiceDisabled(isLexerRule bool, state, choice int) bool
+	TokenDisabled(tokenType int) bool
+}
+
+const (
+	DefaultStrategyName  = "default"
+	RandomStrategyName   = "random"
+	ShortestStrategyName = "shortest"
+	CoverageStrategyName = "coverage"
+	WeightedStrategyName = "weighted"
+)
+
+// ParseRoutingStrategy returns a new strategy for one of the names above, the weights file (see LoadRuleWeights) is
+// only required for the weighted strategy. An empty name selects the weighted strategy if a weights file is provided
+// and the default strategy otherwise.
+func ParseRoutingStrategy(name, weightsFile string, parser antlr.Parser, lexer antlr.Lexer) (RoutingStrategy, error) {
+	if name == "" && weightsFile != "" {
+		name = WeightedStrategyName
+	}
+	switch name {
+	case DefaultStrategyName, "":
+		return &DefaultStrategy{}, nil
+	case RandomStrategyName:
+		return &RandomStrategy{}, nil
+	case ShortestStrategyName:
+		return NewShortestDerivationStrategy(), nil
+	case CoverageStrategyName:
+		return NewCoverageStrategy(), nil
+	case WeightedStrategyName:
+		if weightsFile == "" {
+			return nil, fmt.Errorf("the %q routing strategy requires a weights file", name)
+		}
+		ruleWeights, err := LoadRuleWeights(weightsFile)
+		if err != nil {
+			return nil, err
+		}
+		return NewATNWalker(parser, lexer).NewWeightedStrategy(ruleWeights)
+	}
+	return nil, fmt.Errorf("unknown routing strategy %q", name)
+}
+
+// DefaultStrategy plans routes towards the rule stop state with the learned routes of the Router, i.e.,
+// zero paths first, then unvisited, transitive recursive, and finally self recursive paths.
+type DefaultStrategy struct{}
+
+func (s *DefaultStrategy) Route(router *Router, state int, rootPathRules map[int]struct{}) int {
+	return router.planRoute(state, rootPathRules)
+}
+
+// RandomStrategy picks each transition with the same probability. Recursive grammars may not terminate in time with
+// this strategy, the walker's deadline is the only safety net.
+type RandomStrategy struct{}
+
+func (s *RandomStrategy) Route(router *Router, state int, _ map[int]struct{}) int {
+	return router.randomChoice(state)
+}
+
+type derivationCost struct {
+	symbols int
+	hops    int
+}
+
+// ShortestDerivationStrategy always takes the transition that yields the fewest symbols (tokens for parser rules,
+// characters for lexer rules) until the rule stop state is reached. Ties are broken by the number of transitions
+// to follow and then randomly.
+type ShortestDerivationStrategy struct{}
+
+func NewShortestDerivationStrategy() *ShortestDerivationStrategy {
+	return &ShortestDerivationStrategy{}
+}
+
+func (s *ShortestDerivationStrategy) Route(router *Router, state int, _ map[int]struct{}) int {
+	costs := derivationCosts.get(router.atn)
+	transitions := router.atn.GetStates()[state].GetTransitions()
+	best := derivationCost{math.MaxInt32, math.MaxInt32}
+	var candidates []int
+	for choice, transition := range transitions {
+		cost, ok := transitionCost(router.atn, costs, transition)
+		if !ok || router.isDisabled(state, choice) {
+			continue
+		}
+		switch {
+		case cost.symbols < best.symbols || (cost.symbols == best.symbols && cost.hops < best.hops):
+			best = cost
+			candidates = append(candidates[:0], choice)
+		case cost == best:
+			candidates = append(candidates, choice)
+		}
+	}
+	if len(candidates) == 0 {
+		return router.randomChoice(state)
+	}
+	return candidates[int(router.decoder.prngSource.Int63())%len(candidates)]
+}
+
+// derivationCosts shares the costs of the states of the ATNs, e.g., between the strategies and the separators.
+var derivationCosts = newATNCache(computeDerivationCosts)
+
+// transitionCost returns the cost of reaching the rule stop state when taking the transition.
+func transitionCost(atn *antlr.ATN, costs []derivationCost, transition antlr.Transition) (derivationCost, bool) {
+	var symbols int
+	var next antlr.ATNState
+	switch t := transition.(type) {
+	case *antlr.RuleTransition:
+		ruleCost := costs[atn.GetRuleIndexToStartStateSlice()[t.GetRuleIndex()].GetStateNumber()]
+		if ruleCost.symbols == math.MaxInt32 {
+			return derivationCost{}, false
+		}
+		symbols = ruleCost.symbols
+		next = t.GetFollowState()
+	case *antlr.AtomTransition, *antlr.SetTransition, *antlr.NotSetTransition, *antlr.RangeTransition,
+		*antlr.WildcardTransition:
+		symbols = 1
+		next = transition.(antlr.AnyTransition).GetTarget()
+	default:
+		next = transition.(antlr.AnyTransition).GetTarget()
+	}
+	nextCost := costs[next.GetStateNumber()]
+	if nextCost.symbols == math.MaxInt32 {
+		return derivationCost{}, false
+	}
+	return derivationCost{symbols + nextCost.symbols, 1 + nextCost.hops}, true
+}
+
+// computeDerivationCosts relaxes the costs of all states until a fixed point is reached (Bellman-Ford),
+// rule stop states cost nothing and rule transitions cost as much as the rule start state.
+func computeDerivationCosts(atn *antlr.ATN) []derivationCost {
+	states := atn.GetStates()
+	costs := make([]derivationCost, len(states))
+	for i, state := range states {
+		if state != nil && state.GetStateType() == antlr.ATNStateRuleStop {
+			continue
+		}
+		costs[i] = derivationCost{math.MaxInt32, math.MaxInt32}
+	}
+	for changed := true; changed; {
+		changed = false
+		for i, state := range states {
+			if state == nil || state.GetStateType() == antlr.ATNStateRuleStop {
+				continue
+			}
+			for _, transition := range state.GetTransitions() {
+				cost, ok := transitionCost(atn, costs, transition)
+				if !ok {
+					continue
+				}
+				if cost.symbols < costs[i].symbols || (cost.symbols == costs[i].symbols && cost.hops < costs[i].hops) {
+					costs[i] = cost
+					changed = true
+				}
+			}
+		}
+	}
+	return costs
+}
+
+type coverageKey struct {
+	isLexerRule bool
+	state       int
+	choice      int
+}
+
+// CoverageStrategy prefers choices that have not been exercised yet by any walker sharing this strategy.
+// Once all choices of a state were exercised, it falls back to the DefaultStrategy.
+type CoverageStrategy struct {
+	mutex     sync.Mutex
+	exercised map[coverageKey]uint64
+}
+
+func NewCoverageStrategy() *CoverageStrategy {
+	return &CoverageStrategy{exercised: map[coverageKey]uint64{}}
+}
+
+func (s *CoverageStrategy) ObserveChoice(router *Router, state, choice int) {
+	s.mutex.Lock()
+	s.exercised[coverageKey{router.isLexerRule, state, choice}]++
+	s.mutex.Unlock()
+}
+
+func (s *CoverageStrategy) Route(router *Router, state int, rootPathRules map[int]struct{}) int {
+	// do not leave a previously planned route, the remaining choices would not match the states anymore
+	if router.hasPlannedRoute() {
+		return router.planRoute(state, rootPathRules)
+	}
+	var notExercised []int
+	s.mutex.Lock()
+	for choice := range router.atn.GetStates()[state].GetTransitions() {
+		if _, ok := s.exercised[coverageKey{router.isLexerRule, state, choice}]; !ok && !router.isDisabled(state, choice) {
+			notExercised = append(notExercised, choice)
+		}
+	}
+	s.mutex.Unlock()
+	if len(notExercised) > 0 {
+		return notExercised[int(router.decoder.prngSource.Int63())%len(notExercised)]
+	}
+	return router.planRoute(state, rootPathRules)
+}
+
+// WeightedStrategy picks choices according to user-supplied weights that are stored per decision state number,
+// states without (matching) weights are routed with the DefaultStrategy. Use ATNWalker.NewWeightedStrategy to obtain
+// the state numbers from RuleWeights.
+type WeightedStrategy struct {
+	ParserWeights map[int][]float64
+	LexerWeights  map[int][]float64
+}
+
+func (s *WeightedStrategy) Route(router *Router, state int, rootPathRules map[int]struct{}) int {
+	if router.hasPlannedRoute() {
+		return router.planRoute(state, rootPathRules)
+	}
+	weights := s.ParserWeights[state]
+	if router.isLexerRule {
+		weights = s.LexerWeights[state]
+	}
+	if len(weights) != len(router.atn.GetStates()[state].GetTransitions()) {
+		return router.planRoute(state, rootPathRules)
+	}
+	// disabled choices have no weight, if all weighted choices are disabled, the next allowed choice is taken
+	total, allowed := 0.0, make([]float64, len(weights))
+	for choice, weight := range weights {
+		total += weight
+		if !router.isDisabled(state, choice) {
+			allowed[choice] = weight
+		}
+	}
+	if total <= 0 {
+		return router.planRoute(state, rootPathRules)
+	}
+	choice, ok := weightedChoice(router, allowed)
+	if !ok {
+		choice, _ = weightedChoice(router, weights)
+		return router.allowedChoice(state, choice)
+	}
+	return choice
+}
+
+// weightedChoice samples a choice proportionally to the weights, false if the weights sum up to nothing.
+func weightedChoice(router *Router, weights []float64) (int, bool) {
+	total := 0.0
+	for _, weight := range weights {
+		total += weight
+	}
+	if total <= 0 {
+		return 0, false
+	}
+	x := float64(router.decoder.prngSource.Int63()) / float64(math.MaxInt64) * total
+	for choice, weight := range weights {
+		if x < weight {
+			return choice, true
+		}
+		x -= weight
+	}
+	return len(weights) - 1, true
+}
@@ -0,0 +1,127 @@
+package atnwalk
+
+import (
+	"testing"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+func TestParseRoutingStrategy(t *testing.T) {
+	for _, name := range []string{DefaultStrategyName, RandomStrategyName, ShortestStrategyName, CoverageStrategyName} {
+		if _, err := ParseRoutingStrategy(name, "", nil, nil); err != nil {
+			t.Errorf("ParseRoutingStrategy(%q) returned error %v", name, err)
+		}
+	}
+	if _, err := ParseRoutingStrategy(WeightedStrategyName, "", nil, nil); err == nil {
+		t.Errorf("ParseRoutingStrategy(%q) without weights file should fail", WeightedStrategyName)
+	}
+	if _, err := ParseRoutingStrategy("unknown", "", nil, nil); err == nil {
+		t.Errorf("ParseRoutingStrategy(%q) should fail", "unknown")
+	}
+}
+
+// newChoiceGrammar returns the parser and the lexer of the grammar:
+//
+//	r: A A A | A | A A ;
+//	A: 'a' ;
+func newChoiceGrammar() (*testParser, *testLexer) {
+	const A = 1
+
+	lexerBuilder := &atnBuilder{grammarType: antlr.ATNTypeLexer, maxTokenType: A}
+	start, stop := lexerBuilder.rule(A)
+	lexerBuilder.alt(0, start, stop, atom('a'))
+	lexer := &testLexer{antlr.NewBaseLexer(nil), lexerBuilder.build()}
+	lexer.RuleNames = []string{"A"}
+
+	parserBuilder := &atnBuilder{grammarType: antlr.ATNTypeParser, maxTokenType: A}
+	start, stop = parserBuilder.rule(0)
+	parserBuilder.alt(0, start, stop, atom(A), atom(A), atom(A))
+	parserBuilder.alt(0, start, stop, atom(A))
+	parserBuilder.alt(0, start, stop, atom(A), atom(A))
+	parser := &testParser{antlr.NewBaseParser(nil), parserBuilder.build()}
+	parser.RuleNames = []string{"r"}
+	return parser, lexer
+}
+
+// decodeWith decodes data without rule headers, i.e., each decision is routed with the strategy
+func decodeWith(strategy RoutingStrategy, seed byte) string {
+	parser, lexer := newChoiceGrammar()
+	walker := NewATNWalker(parser, lexer)
+	walker.SetRoutingStrategy(strategy)
+	return walker.Decode([]byte{seed}, nil)
+}
+
+func TestShortestDerivationStrategy_Route(t *testing.T) {
+	strategy := NewShortestDerivationStrategy()
+	for seed := 0; seed < 32; seed++ {
+		if output := decodeWith(strategy, byte(seed)); output != "a" {
+			t.Fatalf("Decode() = %q, want the shortest alternative %q", output, "a")
+		}
+	}
+}
+
+func TestCoverageStrategy_Route(t *testing.T) {
+	for seed := 0; seed < 32; seed++ {
+		strategy := NewCoverageStrategy()
+		outputs := map[string]struct{}{}
+		for i := 0; i < 3; i++ {
+			outputs[decodeWith(strategy, byte(seed+i))] = struct{}{}
+		}
+		if len(outputs) != 3 {
+			t.Fatalf("3 decodes with a shared strategy exercised %d alternatives, want 3", len(outputs))
+		}
+	}
+}
+
+func TestWeightedStrategy_Route(t *testing.T) {
+	parser, _ := newChoiceGrammar()
+	state := ruleDecisionStates(parser.GetATN(), 0)[0]
+	strategy := &WeightedStrategy{ParserWeights: map[int][]float64{state: {3, 0, 1}}}
+	counts := map[string]int{}
+	for seed := 0; seed < 256; seed++ {
+		counts[decodeWith(strategy, byte(seed))]++
+	}
+	if counts["a"] > 0 {
+		t.Errorf("Decode() took the alternative without weight %d times", counts["a"])
+	}
+	if ratio := float64(counts["aaa"]) / 256; ratio < 0.65 || ratio > 0.85 {
+		t.Errorf("Decode() took the alternative with 3/4 of the weight in %.2f of the decodes", ratio)
+	}
+}
+
+// disabledChoices routes with the weights but disables choices like an ExclusionStrategy without patching the route
+type disabledChoices struct {
+	*WeightedStrategy
+	disabled map[int]struct{}
+}
+
+func (s disabledChoices) ChoiceDisabled(isLexerRule bool, state, choice int) bool {
+	_, ok := s.disabled[choice]
+	return ok
+}
+
+func (s disabledChoices) TokenDisabled(tokenType int) bool {
+	return false
+}
+
+func TestWeightedStrategy_RouteDisabled(t *testing.T) {
+	parser, _ := newChoiceGrammar()
+	state := ruleDecisionStates(parser.GetATN(), 0)[0]
+	weighted := &WeightedStrategy{ParserWeights: map[int][]float64{state: {3, 0, 1}}}
+	tests := []struct {
+		disabled map[int]struct{}
+		want     string
+	}{
+		// the disabled choice has no weight
+		{map[int]struct{}{0: {}}, "aa"},
+		// all weighted choices are disabled, the allowed choice is taken
+		{map[int]struct{}{0: {}, 2: {}}, "a"},
+	}
+	for _, tt := range tests {
+		for seed := 0; seed < 64; seed++ {
+			if output := decodeWith(disabledChoices{weighted, tt.disabled}, byte(seed)); output != tt.want {
+				t.Fatalf("Decode() with the disabled choices %v = %q, want %q", tt.disabled, output, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package atnwalk
+
+import (
+	"container/heap"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+// tolerance repairs the rule nodes of a tree with syntax errors while the tree is encoded (see EncodeTolerant).
+type tolerance struct {
+	// the walker and the decoder that generate the missing parts, the decoder has no data, i.e., it uses the PRNG
+	filler  *ATNWalker
+	decoder *Decoder
+	// the runes of the tokens of the seed that were dropped
+	dropped int
+}
+
+// EncodeTolerant encodes a parse tree that may contain syntax errors, e.g., the tree of a parser with the default
+// error recovery of ANTLR. Error nodes are dropped and the children of a rule that do not match the rule are aligned
+// with the path through the rule that keeps most of their text: the children that do not fit are dropped and the rules
+// and tokens that are missing are generated with the PRNG. It returns the encoded data and the fraction of the text of
+// the seed's tokens that the encoded data preserves, i.e., 1 if the tree has no errors.
+func (w *ATNWalker) EncodeTolerant(root antlr.Tree) ([]byte, float64) {
+	filler := NewATNWalker(w.Parser, w.Lexer)
+	filler.routingStrategy = w.routingStrategy
+	filler.alphabet = w.alphabet
+	filler.predicates = w.predicates
+	filler.actions = w.actions
+	filler.deadline, filler.deadlineIsSet = w.deadline, w.deadlineIsSet
+	w.tolerance = &tolerance{filler: filler, decoder: NewDecoder(nil,
+		len(w.Parser.GetATN().GetRuleIndexToStartStateSlice()),
+		len(w.Lexer.GetATN().GetRuleIndexToStartStateSlice()), nil)}
+	defer func() { w.tolerance = nil }()
+
+	seed := seedRunes(root)
+	data := w.Encode(root)
+	if seed == 0 {
+		return data, 1
+	}
+	return data, float64(seed-w.tolerance.dropped) / float64(seed)
+}
+
+// isSeedToken reports whether the text of the token is part of the seed, i.e., it is not EOF and not a token that the
+// parser inserted while recovering from an error, such missing tokens are error nodes without a position in the text.
+func isSeedToken(terminal antlr.TerminalNode) bool {
+	if terminal.GetSymbol().GetTokenType() == antlr.TokenEOF {
+		return false
+	}
+	_, isError := terminal.(antlr.ErrorNode)
+	return !isError || terminal.GetSymbol().GetStart() >= 0
+}
+
+// seedRunes returns the number of runes of the seed's tokens in the tree.
+func seedRunes(tree antlr.Tree) int {
+	if terminal, ok := tree.(antlr.TerminalNode); ok {
+		if !isSeedToken(terminal) {
+			return 0
+		}
+		return len([]rune(terminal.GetSymbol().GetText()))
+	}
+	runes := 0
+	for _, child := range tree.GetChildren() {
+		runes += seedRunes(child)
+	}
+	return runes
+}
+
+// dropErrorNodes removes the error nodes from the children of the node.
+func (t *tolerance) dropErrorNodes(node *WrappedTreeNode) {
+	children := node.Children[:0]
+	for _, child := range node.Children {
+		if _, ok := child.OriginalNode.(antlr.ErrorNode); ok {
+			t.dropped += seedRunes(child.OriginalNode)
+			continue
+		}
+		children = append(children, child)
+	}
+	node.Children = children
+}
+
+type alignKey struct {
+	state  int
+	cursor int
+}
+
+// alignStep is how the alignment reached a key from the previous key: the transition matched the child at the previous
+// cursor, the transition inserted a missing rule or token, or the child was dropped (no transition).
+type alignStep struct {
+	previous   alignKey
+	transition antlr.Transition
+	matched    bool
+}
+
+type alignItem struct {
+	key  alignKey
+	cost int
+}
+
+type alignQueue []alignItem
+
+func (q alignQueue) Len() int           { return len(q) }
+func (q alignQueue) Less(i, j int) bool { return q[i].cost < q[j].cost }
+func (q alignQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *alignQueue) Push(x any)        { *q = append(*q, x.(alignItem)) }
+func (q *alignQueue) Pop() any {
+	item := (*q)[len(*q)-1]
+	*q = (*q)[:len(*q)-1]
+	return item
+}
+
+// align replaces the children of the node with the children of the cheapest path through the rule. Following a
+// transition that matches the next child is free, dropping a child costs the runes of its seed tokens (at least 1),
+// and inserting a missing rule or token costs 1.
+func (t *tolerance) align(w *ATNWalker, node *WrappedTreeNode) {
+	atn := w.Parser.GetATN()
+	start := alignKey{atn.GetRuleIndexToStartStateSlice()[node.GetRuleIndex()].GetStateNumber(), 0}
+	costs := map[alignKey]int{start: 0}
+	steps := map[alignKey]alignStep{}
+	queue := &alignQueue{{start, 0}}
+	relax := func(key alignKey, cost int, step alignStep) {
+		if known, ok := costs[key]; ok && known <= cost {
+			return
+		}
+		costs[key] = cost
+		steps[key] = step
+		heap.Push(queue, alignItem{key, cost})
+	}
+
+	var goal *alignKey
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(alignItem)
+		if item.cost > costs[item.key] {
+			continue
+		}
+		state := atn.GetStates()[item.key.state]
+		if state.GetStateType() == antlr.ATNStateRuleStop {
+			if item.key.cursor == len(node.Children) {
+				goal = &item.key
+				break
+			}
+		} else {
+			for _, transition := range state.GetTransitions() {
+				target := transition.(antlr.AnyTransition).GetTarget()
+				if r, ok := transition.(*antlr.RuleTransition); ok {
+					target = r.GetFollowState()
+				}
+				next := alignKey{target.GetStateNumber(), item.key.cursor}
+				if p, ok := transition.(*antlr.PrecedencePredicateTransition); ok {
+					if p.GetPrecedence() >= node.precedence {
+						relax(next, item.cost, alignStep{item.key, transition, false})
+					}
+					continue
+				}
+				if !isRuleOrToken(transition) {
+					relax(next, item.cost, alignStep{item.key, transition, false})
+					continue
+				}
+				if item.key.cursor < len(node.Children) && w.matchesChild(transition, node.Children[item.key.cursor]) {
+					relax(alignKey{next.state, next.cursor + 1}, item.cost, alignStep{item.key, transition, true})
+				}
+				relax(next, item.cost+1, alignStep{item.key, transition, false})
+			}
+		}
+		if item.key.cursor < len(node.Children) {
+			drop := seedRunes(node.Children[item.key.cursor].OriginalNode)
+			if drop < 1 {
+				drop = 1
+			}
+			relax(alignKey{item.key.state, item.key.cursor + 1}, item.cost+drop, alignStep{item.key, nil, false})
+		}
+	}
+	if goal == nil {
+		return
+	}
+
+	var path []alignStep
+	for key := *goal; key != start; key = steps[key].previous {
+		path = append(path, steps[key])
+	}
+	var children []*WrappedTreeNode
+	for i := len(path) - 1; i >= 0; i-- {
+		step := path[i]
+		switch {
+		case step.transition == nil:
+			t.dropped += seedRunes(node.Children[step.previous.cursor].OriginalNode)
+		case step.matched:
+			children = append(children, node.Children[step.previous.cursor])
+		case isRuleOrToken(step.transition):
+			child := w.wrapANTLRTreeAndEliminateLeftRecursion(t.fill(w, step.transition))
+			child.Parent = node
+			children = append(children, child)
+		}
+	}
+	node.Children = children
+}
+
+// isRuleOrToken reports whether the parser transition enters a rule or matches a token (EOF is an atom).
+func isRuleOrToken(transition antlr.Transition) bool {
+	_, ok := transition.(*antlr.RuleTransition)
+	return ok || isConsuming(transition)
+}
+
+// matchesChild reports whether the parser transition matches the child like traceParserRule does.
+func (w *ATNWalker) matchesChild(transition antlr.Transition, child *WrappedTreeNode) bool {
+	switch t := transition.(type) {
+	case *antlr.RuleTransition:
+		ctx, ok := child.OriginalNode.(antlr.ParserRuleContext)
+		return ok && ctx.GetRuleIndex() == t.GetRuleIndex()
+	case *antlr.AtomTransition:
+		terminal, ok := child.OriginalNode.(antlr.TerminalNode)
+		return ok && terminal.GetSymbol().GetTokenType() == t.GetLabelValue()
+	case *antlr.SetTransition:
+		terminal, ok := child.OriginalNode.(antlr.TerminalNode)
+		return ok && t.GetLabel().Contains(terminal.GetSymbol().GetTokenType())
+	case *antlr.NotSetTransition, *antlr.RangeTransition, *antlr.WildcardTransition:
+		terminal, ok := child.OriginalNode.(antlr.TerminalNode)
+		return ok && w.tokenSet(t).Contains(terminal.GetSymbol().GetTokenType())
+	}
+	return false
+}
+
+// fill generates the parse tree of the rule or the token that the transition inserts with the PRNG.
+func (t *tolerance) fill(w *ATNWalker, transition antlr.Transition) antlr.Tree {
+	// the sentinel stays pending while the filler is decoded, i.e., the filler never ends the input (see canEnd)
+	stack := &Stack[TreeNode]{}
+	stack.Push(NewLiteralNode(nil, 0))
+	switch tr := transition.(type) {
+	case *antlr.RuleTransition:
+		root := NewRuleNode(nil, w.Parser.GetATN().GetRuleIndexToStartStateSlice()[tr.GetRuleIndex()])
+		root.precedence = tr.GetPrecedence()
+		stack.Push(root)
+		t.filler.assemble(t.decoder, stack)
+		return toParseTree(root)
+	case *antlr.AtomTransition:
+		return t.fillToken(w, tr.GetLabelValue(), stack)
+	}
+	tokens := w.tokenSet(transition)
+	return t.fillToken(w, tokens.Get(t.decoder.Decode(tokens.Length())), stack)
+}
+
+func (t *tolerance) fillToken(w *ATNWalker, tokenType int, stack *Stack[TreeNode]) antlr.Tree {
+	text := "<EOF>"
+	if tokenType != antlr.TokenEOF {
+		text = ""
+		if startState := w.tokenStartState(tokenType); startState != nil {
+			node := NewSymbolNode(nil, startState)
+			node.TokenType = tokenType
+			stack.Push(node)
+			t.filler.assemble(t.decoder, stack)
+			text = literals(node)
+		}
+	}
+	token := antlr.NewCommonToken(&antlr.TokenSourceCharStreamPair{}, tokenType, antlr.TokenDefaultChannel, -1, -1)
+	token.SetText(text)
+	return antlr.NewTerminalNodeImpl(token)
+}
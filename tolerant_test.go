@@ -0,0 +1,163 @@
+package atnwalk
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+// errorTree builds parse trees of the test grammar like a parser generated by ANTLR that recovered from errors
+type errorTree struct{}
+
+func (errorTree) rule(ruleIndex int, children ...antlr.Tree) antlr.Tree {
+	ctx := antlr.NewBaseParserRuleContext(nil, -1)
+	ctx.RuleIndex = ruleIndex
+	for _, child := range children {
+		switch c := child.(type) {
+		case *antlr.BaseParserRuleContext:
+			c.SetParent(ctx)
+			ctx.AddChild(c)
+		case antlr.ErrorNode:
+			ctx.AddErrorNode(c.GetSymbol())
+		case antlr.TerminalNode:
+			ctx.AddTokenNode(c.GetSymbol())
+		}
+	}
+	return ctx
+}
+
+func (errorTree) newToken(tokenType int, text string, start int) *antlr.CommonToken {
+	token := antlr.NewCommonToken(&antlr.TokenSourceCharStreamPair{}, tokenType, antlr.TokenDefaultChannel, start,
+		start+len(text)-1)
+	token.SetText(text)
+	return token
+}
+
+func (b errorTree) token(tokenType int, text string) antlr.Tree {
+	return antlr.NewTerminalNodeImpl(b.newToken(tokenType, text, -1))
+}
+
+// extraneous is a token of the text that the parser skipped
+func (b errorTree) extraneous(tokenType int, text string) antlr.Tree {
+	return antlr.NewErrorNodeImpl(b.newToken(tokenType, text, 0))
+}
+
+// missing is a token that the parser inserted, it is not part of the text
+func (b errorTree) missing(tokenType int, text string) antlr.Tree {
+	return antlr.NewErrorNodeImpl(b.newToken(tokenType, "<missing "+text+">", -1))
+}
+
+func TestATNWalker_EncodeTolerant(t *testing.T) {
+	const stmt, expr = 0, 1
+	const SELECT, ATTACH, NAME, NUM = 1, 2, 3, 4
+	parser, lexer := newTestGrammar()
+	var b errorTree
+	tests := []struct {
+		name string
+		tree antlr.Tree
+		// the output, or its prefix that is followed by the generated missing parts
+		want      string
+		generated bool
+		preserved float64
+	}{
+		{"valid", b.rule(stmt, b.token(SELECT, "s"), b.rule(expr, b.token(NUM, "4"))), "s4", false, 1},
+		{"extraneous", b.rule(stmt, b.token(ATTACH, "a"), b.extraneous(NUM, "1"), b.token(NAME, "x")), "ax", false,
+			2. / 3},
+		{"mismatch", b.rule(stmt, b.token(NUM, "7")), "s7", false, 1},
+		{"missing token", b.rule(stmt, b.token(ATTACH, "a"), b.missing(NAME, "NAME")), "a", true, 1},
+		{"missing rule", b.rule(stmt, b.token(SELECT, "s")), "s", true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			walker := NewATNWalker(parser, lexer)
+			data, preserved := walker.EncodeTolerant(tt.tree)
+			if preserved != tt.preserved {
+				t.Errorf("EncodeTolerant() preserved %v of the seed, want %v", preserved, tt.preserved)
+			}
+			decoded := NewATNWalker(parser, lexer).Decode(data, nil)
+			if tt.generated && (!strings.HasPrefix(decoded, tt.want) || decoded == tt.want) {
+				t.Errorf("Decode(EncodeTolerant()) = %q, want %q followed by the missing part", decoded, tt.want)
+			} else if !tt.generated && decoded != tt.want {
+				t.Errorf("Decode(EncodeTolerant()) = %q, want %q", decoded, tt.want)
+			}
+		})
+	}
+
+	tree := b.rule(stmt, b.token(SELECT, "s"), b.rule(expr, b.token(NAME, "x")))
+	if data, _ := NewATNWalker(parser, lexer).EncodeTolerant(tree); !bytes.Equal(data,
+		NewATNWalker(parser, lexer).Encode(tree)) {
+		t.Errorf("EncodeTolerant() of a tree without errors differs from Encode()")
+	}
+}
+
+func TestATNWalker_EncodeErrors(t *testing.T) {
+	parser, lexer := newTestGrammar()
+	var b errorTree
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(r.(string), "stmt") {
+			t.Errorf("Encode() of a tree with errors did not panic with the rule, got %v", r)
+		}
+	}()
+	NewATNWalker(parser, lexer).Encode(b.rule(0, b.token(2, "a"), b.token(4, "1")))
+}
+
+func TestATNWalker_EncodeTolerantDeletions(t *testing.T) {
+	grammars := []struct {
+		name    string
+		grammar func() (*testParser, *testLexer)
+	}{
+		{"test", newTestGrammar},
+		{"modes", newModeGrammar},
+		{"EOF", newEOFGrammar},
+		{"precedence", newExprGrammar},
+	}
+	for _, tt := range grammars {
+		t.Run(tt.name, func(t *testing.T) {
+			parser, lexer := tt.grammar()
+			prng := rand.New(rand.NewSource(1))
+			for i := 0; i < 500; i++ {
+				data := make([]byte, 1+prng.Intn(16))
+				prng.Read(data)
+				_, tree := decodeTree(NewATNWalker(parser, lexer), data)
+				// delete a random token from the tree
+				var parents []*antlr.BaseParserRuleContext
+				var indices []int
+				var collect func(ctx *antlr.BaseParserRuleContext)
+				collect = func(ctx *antlr.BaseParserRuleContext) {
+					for j, child := range ctx.GetChildren() {
+						switch c := child.(type) {
+						case *antlr.BaseParserRuleContext:
+							collect(c)
+						case antlr.TerminalNode:
+							if c.GetSymbol().GetTokenType() != antlr.TokenEOF {
+								parents, indices = append(parents, ctx), append(indices, j)
+							}
+						}
+					}
+				}
+				collect(tree.(*antlr.BaseParserRuleContext))
+				if len(parents) == 0 {
+					continue
+				}
+				k := prng.Intn(len(parents))
+				children := parents[k].GetChildren()
+				copy(children[indices[k]:], children[indices[k]+1:])
+				parents[k].RemoveLastChild()
+
+				encoded, preserved := NewATNWalker(parser, lexer).EncodeTolerant(tree)
+				if preserved < 0 || preserved > 1 {
+					t.Errorf("EncodeTolerant() preserved %v of the seed of data %x", preserved, data)
+				}
+				walker := NewATNWalker(parser, lexer)
+				walker.SetDeadline(time.Now().Add(time.Second))
+				if walker.Decode(encoded, nil); walker.TimedOut() {
+					t.Errorf("Decode(EncodeTolerant()) of data %x timed out", data)
+				}
+			}
+		})
+	}
+}
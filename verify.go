@@ -0,0 +1,209 @@
+package atnwalk
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+// ParseFunc parses the text with the rule of a parser generated by ANTLR (see ParseRule) and returns the parser and
+// the lexer that parsed it together with the parse tree.
+type ParseFunc func(text, startRule string) (antlr.Parser, antlr.Lexer, antlr.Tree, error)
+
+// the checks of the Verifier
+const (
+	// decoding the written back data yields the same output
+	WriteBackCheck = "write-back"
+	// repairing repaired data changes nothing and the repaired data decodes to the same output
+	RepairCheck = "repair"
+	// encoding the parse tree of the output and decoding it yields the same output
+	EncodeCheck = "encode"
+)
+
+// VerifyFailure is an input that failed a check, Want and Got are the outputs, or the encoded data for the repair
+// check, that differ.
+type VerifyFailure struct {
+	Check string
+	Data  []byte
+	Want  string
+	Got   string
+}
+
+func (f *VerifyFailure) String() string {
+	return fmt.Sprintf("%s check failed for data %x: want %q, got %q", f.Check, f.Data, f.Want, f.Got)
+}
+
+// VerifyReport summarizes a run of the Verifier, inputs that timed out while decoding are not checked.
+type VerifyReport struct {
+	Inputs   int
+	TimedOut int
+	Failures []*VerifyFailure
+}
+
+// Verifier decodes random inputs and checks that the walker is consistent with itself and with the grammar's parser:
+// decode→encode→decode is stable for the written back data (WriteBackCheck), the repaired data (RepairCheck), and the
+// parse trees of the outputs (EncodeCheck). It validates a grammar before a campaign, e.g., ambiguous lexer rules or
+// predicates that the walker cannot evaluate let the parser produce other trees than the decoded ones.
+type Verifier struct {
+	Inputs       int
+	MaxDataBytes int
+	Seed         int64
+	// the run stops after MaxFailures failures, 0 for no limit
+	MaxFailures int
+	// Parse parses the outputs for the EncodeCheck, without it, the decoded trees are encoded instead, which checks the
+	// walker but not whether the grammar's lexer and parser agree with it
+	Parse ParseFunc
+
+	startRule string
+	newWalker func() *ATNWalker
+}
+
+// NewVerifier creates a verifier that decodes with the grammar of the parser and the lexer starting with the rule, an
+// empty start rule is the first rule of the grammar.
+func NewVerifier(parser antlr.Parser, lexer antlr.Lexer, strategy RoutingStrategy, startRule string,
+	decodeTimeout time.Duration) (*Verifier, error) {
+	if _, err := ruleIndex(parser, startRule); err != nil {
+		return nil, err
+	}
+	return &Verifier{
+		Inputs:       10000,
+		MaxDataBytes: 64,
+		Seed:         time.Now().UnixNano(),
+		MaxFailures:  10,
+		startRule:    startRule,
+		newWalker: func() *ATNWalker {
+			walker := NewATNWalker(parser, lexer)
+			walker.SetRoutingStrategy(strategy)
+			walker.SetStartRule(startRule)
+			walker.SetDeadline(time.Now().Add(decodeTimeout))
+			return walker
+		},
+	}, nil
+}
+
+// Run checks Inputs inputs of 1 to MaxDataBytes random bytes.
+func (v *Verifier) Run() *VerifyReport {
+	report := &VerifyReport{}
+	prng := rand.New(rand.NewSource(v.Seed))
+	for i := 0; i < v.Inputs && (v.MaxFailures <= 0 || len(report.Failures) < v.MaxFailures); i++ {
+		data := make([]byte, 1+prng.Intn(v.MaxDataBytes))
+		prng.Read(data)
+		report.Inputs++
+		failures, ok := v.Check(data)
+		if !ok {
+			report.TimedOut++
+		}
+		report.Failures = append(report.Failures, failures...)
+	}
+	return report
+}
+
+// Check runs all checks with the data, it returns false if decoding timed out.
+func (v *Verifier) Check(data []byte) (failures []*VerifyFailure, ok bool) {
+	fail := func(check, want, got string) {
+		failures = append(failures, &VerifyFailure{check, data, want, got})
+	}
+
+	walker := v.newWalker()
+	decoder := NewDecoder(data,
+		len(walker.Parser.GetATN().GetRuleIndexToStartStateSlice()),
+		len(walker.Lexer.GetATN().GetRuleIndexToStartStateSlice()), &([]byte{}))
+	root := NewRuleNode(nil, walker.Parser.GetATN().GetRuleIndexToStartStateSlice()[walker.startRuleIndex])
+	if !walker.AssembleTree(decoder, root, nil) {
+		return nil, false
+	}
+	output := walker.TreeToString(root, &Stack[TreeNode]{})
+	if walker.TimedOut() {
+		return nil, false
+	}
+	writeBack := decoder.writeBackEncoder.Bytes()
+
+	decode := func(data []byte) (string, bool) {
+		walker := v.newWalker()
+		decoded := walker.Decode(data, nil)
+		return decoded, !walker.TimedOut()
+	}
+	if decoded, ok := decode(writeBack); ok && decoded != output {
+		fail(WriteBackCheck, output, decoded)
+	}
+
+	repaired := v.newWalker().Repair(data)
+	if again := v.newWalker().Repair(repaired); !bytes.Equal(again, repaired) {
+		fail(RepairCheck, fmt.Sprintf("%x", repaired), fmt.Sprintf("%x", again))
+	} else if decoded, ok := decode(repaired); ok && decoded != output {
+		fail(RepairCheck, output, decoded)
+	}
+
+	encoded, err := v.encode(root, output)
+	if err != nil {
+		fail(EncodeCheck, output, err.Error())
+	} else if decoded, ok := decode(encoded); ok && decoded != output {
+		fail(EncodeCheck, output, decoded)
+	}
+	return failures, true
+}
+
+// encode parses the output and encodes its parse tree, or encodes the decoded tree if there is no parser.
+func (v *Verifier) encode(root *RuleNode, output string) (encoded []byte, err error) {
+	// the encoder panics if the tree does not match the grammar
+	defer func() {
+		if r := recover(); r != nil {
+			encoded, err = nil, fmt.Errorf("failed to encode: %v", r)
+		}
+	}()
+	walker := v.newWalker()
+	if v.Parse == nil {
+		return walker.Encode(toParseTree(root)), nil
+	}
+	parser, lexer, tree, err := v.Parse(output, v.startRule)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse: %v", err)
+	}
+	return NewATNWalker(parser, lexer).Encode(tree), nil
+}
+
+// toParseTree returns the tree that a parser generated by ANTLR would produce for the decoded tree.
+func toParseTree(node *RuleNode) *antlr.BaseParserRuleContext {
+	ctx := antlr.NewBaseParserRuleContext(nil, -1)
+	ctx.RuleIndex = node.StartState.GetRuleIndex()
+	eofs := node.eofs
+	addEOFs := func(position int) {
+		for ; len(eofs) > 0 && eofs[0] == position; eofs = eofs[1:] {
+			token := antlr.NewCommonToken(&antlr.TokenSourceCharStreamPair{}, antlr.TokenEOF,
+				antlr.TokenDefaultChannel, -1, -1)
+			token.SetText("<EOF>")
+			ctx.AddTokenNode(token)
+		}
+	}
+	for i, child := range node.Children {
+		addEOFs(i)
+		switch c := child.(type) {
+		case *RuleNode:
+			childCtx := toParseTree(c)
+			childCtx.SetParent(ctx)
+			ctx.AddChild(childCtx)
+		case *SymbolNode:
+			token := antlr.NewCommonToken(&antlr.TokenSourceCharStreamPair{}, c.TokenType,
+				antlr.TokenDefaultChannel, -1, -1)
+			token.SetText(literals(c))
+			ctx.AddTokenNode(token)
+		}
+	}
+	addEOFs(len(node.Children))
+	return ctx
+}
+
+// literals returns the text of the literal nodes below the node.
+func literals(node TreeNode) string {
+	if literal, ok := node.(*LiteralNode); ok {
+		return string(literal.Text)
+	}
+	text := ""
+	for _, child := range node.GetChildren() {
+		text += literals(child)
+	}
+	return text
+}
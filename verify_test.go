@@ -0,0 +1,70 @@
+package atnwalk
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+func TestVerifier_Run(t *testing.T) {
+	grammars := []struct {
+		name    string
+		grammar func() (*testParser, *testLexer)
+	}{
+		{"test", newTestGrammar},
+		{"unicode", newUnicodeGrammar},
+		{"wildcard", newWildcardGrammar},
+		{"modes", newModeGrammar},
+		{"EOF", newEOFGrammar},
+		{"precedence", newExprGrammar},
+		{"predicate", newPredicateGrammar},
+	}
+	for _, tt := range grammars {
+		for _, strategy := range []RoutingStrategy{&DefaultStrategy{}, &RandomStrategy{}} {
+			t.Run(fmt.Sprintf("%s/%T", tt.name, strategy), func(t *testing.T) {
+				parser, lexer := tt.grammar()
+				verifier, err := NewVerifier(parser, lexer, strategy, "", time.Second)
+				if err != nil {
+					t.Fatal(err)
+				}
+				verifier.Inputs = 1000
+				verifier.MaxDataBytes = 16
+				verifier.Seed = 1
+				report := verifier.Run()
+				if report.Inputs != verifier.Inputs || report.TimedOut > 0 {
+					t.Errorf("Run() checked %d inputs, %d timed out", report.Inputs, report.TimedOut)
+				}
+				for _, failure := range report.Failures {
+					t.Error(failure)
+				}
+			})
+		}
+	}
+}
+
+func TestVerifier_Check(t *testing.T) {
+	parser, lexer := newTestGrammar()
+	verifier, err := NewVerifier(parser, lexer, &DefaultStrategy{}, "", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a parser that does not agree with the grammar, it always returns the tree of another output
+	verifier.Parse = func(text, startRule string) (antlr.Parser, antlr.Lexer, antlr.Tree, error) {
+		_, tree := decodeTree(NewATNWalker(parser, lexer), []byte(text+"?"))
+		return parser, lexer, tree, nil
+	}
+	verifier.MaxFailures = 1
+	report := verifier.Run()
+	if len(report.Failures) != 1 || report.Failures[0].Check != EncodeCheck {
+		t.Fatalf("Run() = %v, want one failure of the %s check", report.Failures, EncodeCheck)
+	}
+	if failure := report.Failures[0]; failure.Want == failure.Got {
+		t.Errorf("the failure %v does not differ", failure)
+	}
+
+	if _, err := NewVerifier(parser, lexer, &DefaultStrategy{}, "missing", time.Second); err == nil {
+		t.Errorf("NewVerifier() accepted an unknown start rule")
+	}
+}
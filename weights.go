@@ -0,0 +1,202 @@
+package atnwalk
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+// RuleWeights maps a rule name (parser or lexer rule) to the weights of the choices of each decision state in that
+// rule. The decision states of a rule are numbered by the ascending order of their ATN state numbers, i.e., in the
+// order they appear in the grammar. Unlike ATN state numbers, rule names are stable when the grammar changes.
+type RuleWeights map[string]map[int][]float64
+
+// LoadRuleWeights reads weights from a file, each line has the format:
+//
+//	RULE_NAME DECISION WEIGHT_CHOICE_0 WEIGHT_CHOICE_1 ...
+//
+// Empty lines and lines starting with '#' are ignored.
+func LoadRuleWeights(path string) (RuleWeights, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadRuleWeights(file, path)
+}
+
+// ReadRuleWeights reads weights in the format of LoadRuleWeights, the name is only used for error messages.
+func ReadRuleWeights(reader io.Reader, name string) (RuleWeights, error) {
+	ruleWeights := RuleWeights{}
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("%s:%d: expected RULE_NAME DECISION WEIGHT...", name, lineNumber)
+		}
+		decision, err := strconv.Atoi(fields[1])
+		if err != nil || decision < 0 {
+			return nil, fmt.Errorf("%s:%d: invalid decision %q", name, lineNumber, fields[1])
+		}
+		weights := make([]float64, len(fields)-2)
+		for i, field := range fields[2:] {
+			if weights[i], err = strconv.ParseFloat(field, 64); err != nil || weights[i] < 0 {
+				return nil, fmt.Errorf("%s:%d: invalid weight %q", name, lineNumber, field)
+			}
+		}
+		if _, ok := ruleWeights[fields[0]]; !ok {
+			ruleWeights[fields[0]] = map[int][]float64{}
+		}
+		ruleWeights[fields[0]][decision] = weights
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ruleWeights, nil
+}
+
+// Write writes the weights sorted by rule name and decision in the format of LoadRuleWeights.
+func (rw RuleWeights) Write(writer io.Writer) error {
+	ruleNames := make([]string, 0, len(rw))
+	for ruleName := range rw {
+		ruleNames = append(ruleNames, ruleName)
+	}
+	sort.Strings(ruleNames)
+	buffered := bufio.NewWriter(writer)
+	for _, ruleName := range ruleNames {
+		decisions := make([]int, 0, len(rw[ruleName]))
+		for decision := range rw[ruleName] {
+			decisions = append(decisions, decision)
+		}
+		sort.Ints(decisions)
+		for _, decision := range decisions {
+			buffered.WriteString(ruleName + " " + strconv.Itoa(decision))
+			for _, weight := range rw[ruleName][decision] {
+				buffered.WriteString(" " + strconv.FormatFloat(weight, 'g', 6, 64))
+			}
+			buffered.WriteString("\n")
+		}
+	}
+	return buffered.Flush()
+}
+
+// ruleDecisionStates returns the state numbers of all states in the rule that have more than one transition.
+func ruleDecisionStates(atn *antlr.ATN, ruleIndex int) []int {
+	var decisionStates []int
+	for _, state := range atn.GetStates() {
+		if state != nil && state.GetRuleIndex() == ruleIndex && len(state.GetTransitions()) > 1 {
+			decisionStates = append(decisionStates, state.GetStateNumber())
+		}
+	}
+	return decisionStates
+}
+
+// NewWeightedStrategy resolves the rule names and decisions of the weights to the ATN states of the walker's parser
+// and lexer. Unknown rules or decisions and weights that do not match the number of choices are reported as errors.
+func (w *ATNWalker) NewWeightedStrategy(ruleWeights RuleWeights) (*WeightedStrategy, error) {
+	strategy := &WeightedStrategy{ParserWeights: map[int][]float64{}, LexerWeights: map[int][]float64{}}
+	recognizers := []struct {
+		ruleNames []string
+		atn       *antlr.ATN
+		weights   map[int][]float64
+	}{
+		{w.Parser.GetRuleNames(), w.Parser.GetATN(), strategy.ParserWeights},
+		{w.Lexer.GetRuleNames(), w.Lexer.GetATN(), strategy.LexerWeights},
+	}
+	for ruleName, decisions := range ruleWeights {
+		found := false
+		for _, recognizer := range recognizers {
+			for ruleIndex, name := range recognizer.ruleNames {
+				if name != ruleName {
+					continue
+				}
+				found = true
+				decisionStates := ruleDecisionStates(recognizer.atn, ruleIndex)
+				for decision, weights := range decisions {
+					if decision >= len(decisionStates) {
+						return nil, fmt.Errorf("rule %s has only %d decisions but got weights for decision %d",
+							ruleName, len(decisionStates), decision)
+					}
+					state := decisionStates[decision]
+					if numChoices := len(recognizer.atn.GetStates()[state].GetTransitions()); numChoices != len(weights) {
+						return nil, fmt.Errorf("decision %d of rule %s has %d choices but got %d weights",
+							decision, ruleName, numChoices, len(weights))
+					}
+					recognizer.weights[state] = weights
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown rule %q", ruleName)
+		}
+	}
+	return strategy, nil
+}
+
+// ChoiceCounter is a RoutingStrategy that routes like the DefaultStrategy but counts all choices that are observed,
+// decoding encoded inputs with it learns how often each alternative is used in a corpus.
+type ChoiceCounter struct {
+	DefaultStrategy
+	mutex  sync.Mutex
+	counts map[coverageKey]uint64
+}
+
+func NewChoiceCounter() *ChoiceCounter {
+	return &ChoiceCounter{counts: map[coverageKey]uint64{}}
+}
+
+func (c *ChoiceCounter) ObserveChoice(router *Router, state, choice int) {
+	c.mutex.Lock()
+	c.counts[coverageKey{router.isLexerRule, state, choice}]++
+	c.mutex.Unlock()
+}
+
+// RuleWeights returns the relative frequencies of the observed choices for each decision state that was observed at
+// least once. Laplace smoothing (adding one to every count) keeps unobserved alternatives possible.
+func (c *ChoiceCounter) RuleWeights(parser antlr.Parser, lexer antlr.Lexer) RuleWeights {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	ruleWeights := RuleWeights{}
+	for _, recognizer := range []struct {
+		isLexerRule bool
+		ruleNames   []string
+		atn         *antlr.ATN
+	}{
+		{false, parser.GetRuleNames(), parser.GetATN()},
+		{true, lexer.GetRuleNames(), lexer.GetATN()},
+	} {
+		for ruleIndex, ruleName := range recognizer.ruleNames {
+			for decision, state := range ruleDecisionStates(recognizer.atn, ruleIndex) {
+				numChoices := len(recognizer.atn.GetStates()[state].GetTransitions())
+				counts := make([]uint64, numChoices)
+				var total uint64
+				for choice := 0; choice < numChoices; choice++ {
+					counts[choice] = c.counts[coverageKey{recognizer.isLexerRule, state, choice}]
+					total += counts[choice]
+				}
+				if total == 0 {
+					continue
+				}
+				weights := make([]float64, numChoices)
+				for choice, count := range counts {
+					weights[choice] = float64(count+1) / float64(total+uint64(numChoices))
+				}
+				if _, ok := ruleWeights[ruleName]; !ok {
+					ruleWeights[ruleName] = map[int][]float64{}
+				}
+				ruleWeights[ruleName][decision] = weights
+			}
+		}
+	}
+	return ruleWeights
+}
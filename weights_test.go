@@ -0,0 +1,110 @@
+package atnwalk
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr/v4"
+)
+
+func TestReadRuleWeights(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    RuleWeights
+		wantErr bool
+	}{
+		{
+			"Parser and lexer rules with comments and empty lines",
+			"# comment\nsql_stmt 0 0.5 0.25 0.25\n\nsql_stmt 2 1 0\nIDENTIFIER 1 1 9\n",
+			RuleWeights{"sql_stmt": {0: {0.5, 0.25, 0.25}, 2: {1, 0}}, "IDENTIFIER": {1: {1, 9}}},
+			false},
+		{
+			"Negative decision",
+			"sql_stmt -1 0.5 0.5\n",
+			nil, true},
+		{
+			"Negative weight",
+			"sql_stmt 0 -0.5 0.5\n",
+			nil, true},
+		{
+			"Missing weights",
+			"sql_stmt 0\n",
+			nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadRuleWeights(strings.NewReader(tt.content), "weights.txt")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadRuleWeights() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadRuleWeights() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleWeights_Write(t *testing.T) {
+	ruleWeights := RuleWeights{"sql_stmt": {2: {1, 0}, 0: {0.5, 0.25, 0.25}}, "IDENTIFIER": {1: {0.1, 0.9}}}
+	buffer := &bytes.Buffer{}
+	if err := ruleWeights.Write(buffer); err != nil {
+		t.Fatal(err)
+	}
+	want := "IDENTIFIER 1 0.1 0.9\nsql_stmt 0 0.5 0.25 0.25\nsql_stmt 2 1 0\n"
+	if buffer.String() != want {
+		t.Errorf("Write() = %q, want %q", buffer.String(), want)
+	}
+	got, err := ReadRuleWeights(buffer, "buffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, ruleWeights) {
+		t.Errorf("ReadRuleWeights(Write()) = %v, want %v", got, ruleWeights)
+	}
+}
+
+func TestChoiceCounter_RuleWeights(t *testing.T) {
+	const stmt, expr = 0, 1
+	const SELECT, ATTACH, NAME, NUM = 1, 2, 3, 4
+	parser, lexer := newTestGrammar()
+	var b errorTree
+	attach := b.rule(stmt, b.token(ATTACH, "a"), b.token(NAME, "x"))
+	selectExpr := b.rule(stmt, b.token(SELECT, "s"), b.rule(expr, b.token(NUM, "4")))
+	counter := NewChoiceCounter()
+	for _, tree := range []antlr.Tree{attach, attach, attach, selectExpr} {
+		walker := NewATNWalker(parser, lexer)
+		walker.SetRoutingStrategy(counter)
+		walker.Decode(NewATNWalker(parser, lexer).Encode(tree), nil)
+	}
+
+	// stmt: SELECT expr (once) | ATTACH NAME (3 times) | SELECT (NAME|NUM) (never), expr: NAME (never) | NUM (once),
+	// each count plus one
+	ruleWeights := counter.RuleWeights(parser, lexer)
+	want := RuleWeights{"stmt": {0: {2. / 7, 4. / 7, 1. / 7}}, "expr": {0: {1. / 3, 2. / 3}}}
+	for _, ruleName := range []string{"stmt", "expr"} {
+		if !reflect.DeepEqual(ruleWeights[ruleName], want[ruleName]) {
+			t.Errorf("RuleWeights()[%s] = %v, want %v", ruleName, ruleWeights[ruleName], want[ruleName])
+		}
+	}
+
+	// the decisions of the rules map to the decision states of the parser's ATN
+	strategy, err := NewATNWalker(parser, lexer).NewWeightedStrategy(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ruleIndex, ruleName := range []string{"stmt", "expr"} {
+		state := ruleDecisionStates(parser.GetATN(), ruleIndex)[0]
+		if !reflect.DeepEqual(strategy.ParserWeights[state], want[ruleName][0]) {
+			t.Errorf("NewWeightedStrategy() weights of state %d = %v, want %v", state,
+				strategy.ParserWeights[state], want[ruleName][0])
+		}
+	}
+	for _, invalid := range []RuleWeights{{"missing": {0: {1, 1}}}, {"stmt": {1: {1, 1}}}, {"expr": {0: {1, 1, 1}}}} {
+		if _, err := NewATNWalker(parser, lexer).NewWeightedStrategy(invalid); err == nil {
+			t.Errorf("NewWeightedStrategy(%v) accepted invalid weights", invalid)
+		}
+	}
+}